@@ -0,0 +1,24 @@
+package auth
+
+import (
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+// parsePersonalAccessToken authenticates a personal access token and returns claims equivalent to a JWT of its own
+func parsePersonalAccessToken(token string) (*TokenClaims, error) {
+	accessToken, err := models.AuthenticatePersonalAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenClaims{
+		PersonalTokenID: accessToken.GetId().Hex(),
+		Scope:           models.JoinScopes(accessToken.Scopes),
+		StandardClaims: jwt.StandardClaims{
+			Id:        accessToken.GetId().Hex(),
+			Subject:   accessToken.User.Hex(),
+			IssuedAt:  accessToken.Created.Unix(),
+			ExpiresAt: accessToken.ExpiresAt.Unix(),
+		},
+	}, nil
+}
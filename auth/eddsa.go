@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method of RFC 8037 with Ed25519 keys
+type SigningMethodEdDSA struct{}
+
+// SigningMethodEd25519 is the EdDSA signing method, registered in jwt-go under the "EdDSA" alg
+var SigningMethodEd25519 = &SigningMethodEdDSA{}
+
+var errEdDSAVerification = errors.New("EdDSA verification failed")
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+// Alg returns the name of the method as written in the alg header
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks signature with an ed25519.PublicKey
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errEdDSAVerification
+	}
+	return nil
+}
+
+// Sign signs signingString with an ed25519.PrivateKey
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// oidEd25519 identifies Ed25519 keys in PKCS #8 and PKIX structures, as told by RFC 8410
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// pkcs8Key is a PKCS #8 private key, the optional attributes and public key that may follow are ignored
+type pkcs8Key struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// pkixKey is a PKIX public key
+type pkixKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+/*
+parseEd25519Key parses der as an Ed25519 private key in PKCS #8 or public key in PKIX, as told by private. It
+returns a nil key without error if der holds a key of another algorithm, which crypto/x509 can parse, since the
+version of crypto/x509 the server is built with doesn't know Ed25519 keys or returns types jwt-go can't use
+*/
+func parseEd25519Key(der []byte, private bool) (interface{}, error) {
+	if private {
+		var key pkcs8Key
+		if _, err := asn1.Unmarshal(der, &key); err != nil || !key.Algorithm.Algorithm.Equal(oidEd25519) {
+			return nil, nil
+		}
+		var seed []byte
+		if _, err := asn1.Unmarshal(key.PrivateKey, &seed); err != nil || len(seed) != ed25519.SeedSize {
+			return nil, errors.New("Malformed Ed25519 private key")
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	var key pkixKey
+	if _, err := asn1.Unmarshal(der, &key); err != nil || !key.Algorithm.Algorithm.Equal(oidEd25519) {
+		return nil, nil
+	}
+	if key.PublicKey.BitLength != 8*ed25519.PublicKeySize {
+		return nil, errors.New("Malformed Ed25519 public key")
+	}
+	return ed25519.PublicKey(key.PublicKey.Bytes), nil
+}
@@ -1,24 +1,13 @@
 package auth
 
 import (
-	"crypto/tls"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"net/http"
 	"strings"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/jenarvaezg/magicbox/models"
-	"github.com/mendsley/gojwk"
 	"golang.org/x/oauth2"
 )
 
-const (
-	googleCertURL    = "https://www.googleapis.com/oauth2/v3/certs"
-	expectedAudience = "144467579021-gmpp7n1a9m3b82svfs51eqjbs0bhidkk.apps.googleusercontent.com"
-	expectedIssuer   = "accounts.google.com"
-)
+const googleProvider = "google"
 
 // GoogleUserProfile is the representation of the profile given by Google
 type GoogleUserProfile struct {
@@ -40,71 +29,37 @@ type GoogleToken struct {
 type GoogleFrontendRequest struct {
 	Token   GoogleToken       `json:"tokenObj"`
 	Profile GoogleUserProfile `json:"profileObj"`
+	Nonce   string            `json:"nonce,omitempty"`
 }
 
-func getGoogleKeys() (keys []*gojwk.Key, err error) {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //TODO REMOVE
-	r, err := http.Get(googleCertURL)
-	if err != nil {
-		return
-	}
-	defer r.Body.Close()
-
-	var key gojwk.Key
-
-	err = json.NewDecoder(r.Body).Decode(&key)
-	keys = key.Keys
-	return
-}
-
-func parseToken(googleToken GoogleToken) (*jwt.Token, error) {
-	var token *jwt.Token
-	keys, err := getGoogleKeys()
-	if err != nil {
-		return &jwt.Token{}, err
-	}
-
-	for _, key := range keys {
-		token, err = jwt.Parse(googleToken.JWT, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-			return key.DecodePublicKey()
-		})
-		if err == nil {
-			return token, nil
-		}
+/*
+GetAuthTokenFromGoogleToken returns an auth token from a frontend google auth requests. The profile sent by the
+frontend is ignored, the user is built from the verified ID token
+*/
+func GetAuthTokenFromGoogleToken(googleReq GoogleFrontendRequest, client models.SessionClient) (TokenResponse, error) {
+	provider, ok := GetOIDCProvider(googleProvider)
+	if !ok {
+		return TokenResponse{}, newTokenError(errInvalidRequest, "Google login is not enabled")
 	}
-	return nil, errors.New("No google cert match found")
+	return loginWithIDToken(provider, googleReq.Token.JWT, googleReq.Nonce, client)
 }
 
-func validateGoogleToken(token GoogleToken) error {
-
-	parsedToken, err := parseToken(token)
+// userRequestFromIDToken returns the sign up of a new user, named after the local part of their email if it is free
+func userRequestFromIDToken(claims *IDTokenClaims) (models.UserRequest, error) {
+	username, err := models.UniqueUsername(strings.Split(claims.Email, "@")[0])
 	if err != nil {
-		return err
-	}
-
-	claims := parsedToken.Claims.(jwt.MapClaims)
-	if err := claims.Valid(); !claims.VerifyAudience(expectedAudience, true) ||
-		!claims.VerifyIssuer(expectedIssuer, true) || err != nil {
-		return errors.New("Unvalid jwt internals")
+		return models.UserRequest{}, err
 	}
-
-	return nil
-}
-
-func userRequestFromGoogleProfile(profile GoogleUserProfile) models.UserRequest {
 	pass := ""
 	req := models.UserRequest{
-		Username:   strings.Split(profile.Email, "@")[0],
-		Email:      profile.Email,
-		FirstName:  profile.GivenName,
-		LastName:   profile.FamilyName,
+		Username:   username,
+		Email:      claims.Email,
+		FirstName:  claims.GivenName,
+		LastName:   claims.FamilyName,
 		Password:   &pass,
 		FromGoogle: true,
-		ImageURL:   profile.ImageURL,
+		ImageURL:   claims.Picture,
 	}
 
-	return req
+	return req, nil
 }
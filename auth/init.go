@@ -1,13 +1,34 @@
 package auth
 
 import (
+	crand "crypto/rand"
+	"log"
 	"math/rand"
+	"os"
 	"time"
 )
 
 var r *rand.Rand
 
+// secretKey signs the links mailed to users, it must be shared by every server replica
+var secretKey []byte
+
+func loadSecretKey() {
+	if secret := os.Getenv("MAGICBOX_SECRET"); secret != "" {
+		secretKey = []byte(secret)
+		return
+	}
+	log.Println("MAGICBOX_SECRET is not set, mailed links will only be valid until the server restarts")
+	secretKey = make([]byte, 32)
+	if _, err := crand.Read(secretKey); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func init() {
 	r = rand.New(rand.NewSource(time.Now().UnixNano()))
-
+	loadSecretKey()
+	loadSigningKeys()
+	loadOIDCProviders()
+	loadThrottleStore()
 }
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/mendsley/gojwk"
+	"golang.org/x/crypto/ed25519"
+)
+
+/*
+Auth tokens are signed with keys read from the PEM files of JWT_KEYS_DIR, the key id (kid) of each one is its
+file name without the .pem extension. Private keys can sign and verify, public keys only verify, which is how a
+retired key is kept around until the tokens it signed expire. JWT_SIGNING_KEY is the kid of the key that signs new
+tokens, it may be omitted when there is a single private key.
+
+RSA keys sign with RS256, P-256 keys with ES256 and Ed25519 keys with EdDSA. Without JWT_KEYS_DIR tokens are
+signed with HS512 and a key derived from MAGICBOX_SECRET, which can't be published.
+*/
+
+const hmacKeyID = "hs512"
+
+// signingKey is a key tokens are verified with and, when private is set, signed with
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+var (
+	signingKeys      = make(map[string]*signingKey)
+	currentKey       *signingKey
+	errUnknownKey    = errors.New("Token was signed with an unknown key")
+	errWrongKeyUsage = errors.New("Token algorithm does not match its key")
+)
+
+// newSigningKey returns the signingKey for a parsed PEM key
+func newSigningKey(id string, key interface{}) (*signingKey, error) {
+	k := &signingKey{id: id}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, key
+	case *ecdsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodES256, key, &key.PublicKey
+	case *ecdsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodES256, key
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = SigningMethodEd25519, key, key.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = SigningMethodEd25519, key
+	default:
+		return nil, fmt.Errorf("Key %s has an unsupported type %T", id, key)
+	}
+	if ecKey, ok := k.public.(*ecdsa.PublicKey); ok && ecKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("Key %s must use the P-256 curve", id)
+	}
+	return k, nil
+}
+
+// parsePEMKey parses the first PEM block of data as a private or public key
+func parsePEMKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		if key, err := parseEd25519Key(block.Bytes, true); key != nil || err != nil {
+			return key, err
+		}
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		if key, err := parseEd25519Key(block.Bytes, false); key != nil || err != nil {
+			return key, err
+		}
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("Unsupported PEM block %q", block.Type)
+}
+
+func loadKeysDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".pem" {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), ".pem")
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		parsed, err := parsePEMKey(data)
+		if err != nil {
+			return fmt.Errorf("Could not read key %s: %s", id, err)
+		}
+		key, err := newSigningKey(id, parsed)
+		if err != nil {
+			return err
+		}
+		signingKeys[id] = key
+	}
+	return nil
+}
+
+func selectCurrentKey(id string) error {
+	if id != "" {
+		key, ok := signingKeys[id]
+		if !ok || key.private == nil {
+			return fmt.Errorf("JWT_SIGNING_KEY %s is not a private key of JWT_KEYS_DIR", id)
+		}
+		currentKey = key
+		return nil
+	}
+	for _, key := range signingKeys {
+		if key.private == nil {
+			continue
+		}
+		if currentKey != nil {
+			return errors.New("JWT_KEYS_DIR has several private keys, choose one with JWT_SIGNING_KEY")
+		}
+		currentKey = key
+	}
+	if currentKey == nil {
+		return errors.New("JWT_KEYS_DIR has no private key to sign tokens with")
+	}
+	return nil
+}
+
+func loadSigningKeys() {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR is not set, auth tokens are signed with HS512 and can't be verified by other services")
+		mac := hmac.New(sha256.New, secretKey)
+		mac.Write([]byte("jwt"))
+		currentKey = &signingKey{id: hmacKeyID, method: jwt.SigningMethodHS512, private: mac.Sum(nil)}
+		currentKey.public = currentKey.private
+		signingKeys[hmacKeyID] = currentKey
+		return
+	}
+	if err := loadKeysDir(dir); err != nil {
+		log.Fatal(err)
+	}
+	if err := selectCurrentKey(os.Getenv("JWT_SIGNING_KEY")); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Signing auth tokens with key %s (%s), %d keys accepted", currentKey.id, currentKey.method.Alg(), len(signingKeys))
+}
+
+// signToken signs claims with the current key, whose id goes in the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(currentKey.method, claims)
+	token.Header["kid"] = currentKey.id
+	return token.SignedString(currentKey.private)
+}
+
+// keyFunc returns the key a token must be verified with, only the algorithm of that key is accepted
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	key, ok := signingKeys[id]
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errWrongKeyUsage
+	}
+	return key.public, nil
+}
+
+// ParseToken verifies an auth token, a JWT or a personal access token, and returns its claims
+func ParseToken(tokenString string) (*TokenClaims, error) {
+	if strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix) {
+		return parsePersonalAccessToken(tokenString)
+	}
+	claims := &TokenClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, keyFunc); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// JWKS is a JSON Web Key Set as published in /.well-known/jwks.json
+type JWKS struct {
+	Keys []*gojwk.Key `json:"keys"`
+}
+
+// ecJWK encodes a P-256 key, whose coordinates must be padded to the curve size unlike gojwk does
+func ecJWK(key *ecdsa.PublicKey) *gojwk.Key {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x, y := make([]byte, size), make([]byte, size)
+	xBytes, yBytes := key.X.Bytes(), key.Y.Bytes()
+	copy(x[size-len(xBytes):], xBytes)
+	copy(y[size-len(yBytes):], yBytes)
+	return &gojwk.Key{Kty: "EC", Crv: "P-256", X: jwt.EncodeSegment(x), Y: jwt.EncodeSegment(y)}
+}
+
+func ed25519JWK(key ed25519.PublicKey) *gojwk.Key {
+	return &gojwk.Key{Kty: "OKP", Crv: "Ed25519", X: jwt.EncodeSegment(key)}
+}
+
+// GetJWKS returns the set of public keys auth tokens can be verified with, HMAC keys are never included
+func GetJWKS() JWKS {
+	set := JWKS{Keys: make([]*gojwk.Key, 0, len(signingKeys))}
+	for _, key := range signingKeys {
+		var jwk *gojwk.Key
+		var err error
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk = ed25519JWK(public)
+		case *ecdsa.PublicKey:
+			jwk = ecJWK(public)
+		case *rsa.PublicKey:
+			jwk, err = gojwk.PublicKey(public)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Println("Could not publish key", key.id, err)
+			continue
+		}
+		jwk.Kid, jwk.Alg, jwk.Use = key.id, key.method.Alg(), "sig"
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+const (
+	mfaPurpose      = "mfa-challenge"
+	mfaChallengeTTL = 5 * time.Minute
+	grantTypeMFAOTP = "urn:magicbox:params:oauth:grant-type:mfa-otp"
+)
+
+var errInvalidMFAToken = errors.New("Invalid or expired mfa_token")
+
+/*
+newMFAChallenge returns the mfa_required error of a login of user, carrying the token the second step needs, or the
+error that kept it from being issued
+*/
+func newMFAChallenge(user models.User) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	expires := strconv.FormatInt(time.Now().Add(mfaChallengeTTL).Unix(), 10)
+	tokenErr := newTokenError(errMFARequired, "Two-factor authentication is required")
+	tokenErr.MFAToken = newSignedToken(mfaPurpose, user.GetId().Hex(), expires, hex.EncodeToString(b))
+	return tokenErr
+}
+
+// completeLogin logs user in, unless a second factor is required, in which case the challenge is returned
+func completeLogin(user models.User, client models.SessionClient) (TokenResponse, error) {
+	if user.MFAEnabled() {
+		return TokenResponse{}, newMFAChallenge(user)
+	}
+	return login(user, client)
+}
+
+// getMFAToken answers the second step of a login, exchanging the mfa_token of the challenge and a code for tokens
+func getMFAToken(mfaToken, code string, client models.SessionClient) (TokenResponse, error) {
+	if mfaToken == "" || code == "" {
+		return TokenResponse{}, newTokenError(errInvalidRequest, "Parameters mfa_token and otp are required")
+	}
+	fields, ok := parseSignedToken(mfaPurpose, mfaToken, 3)
+	if !ok || isExpired(fields[1]) {
+		return TokenResponse{}, newTokenError(errInvalidGrant, errInvalidMFAToken.Error())
+	}
+	user, err := models.GetUserByID(fields[0])
+	if err != nil {
+		return TokenResponse{}, newTokenError(errInvalidGrant, errInvalidMFAToken.Error())
+	}
+	// Codes are short, guessing them is throttled like guessing passwords
+	attempt, err := startLoginAttempt(loginThrottleKeys(user.Username, client))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if err := user.VerifyMFA(code); err == models.ErrInvalidMFACode {
+		attempt.failed()
+		return TokenResponse{}, newTokenError(errInvalidGrant, err.Error())
+	} else if err != nil {
+		attempt.forget()
+		return TokenResponse{}, err
+	}
+	attempt.succeeded()
+	return login(user, client)
+}
+
+/*
+Reauthenticate checks the user is the one operating a logged in session from client before a sensitive change.
+Users with a password must provide it, users without one an ID token of a linked identity, and every user with
+two-factor authentication a code as well. Wrong passwords and codes are throttled like those of logins
+*/
+func Reauthenticate(user *models.User, client models.SessionClient, password, idToken, nonce, code string) error {
+	attempt, err := startLoginAttempt(loginThrottleKeys(user.Username, client))
+	if err != nil {
+		if tokenErr, ok := err.(*TokenError); ok {
+			return models.NewError(models.KindTooManyRequests, "%s", tokenErr.Description)
+		}
+		return err
+	}
+	if user.Password != "" {
+		if !user.ChallengePassword(password) {
+			attempt.failed()
+			return models.NewError(models.KindUnauthorized, "Invalid password")
+		}
+	} else {
+		identity, err := VerifyIdentity(idToken, nonce)
+		if err != nil {
+			attempt.forget()
+			return err
+		}
+		linked, ok := user.GetIdentity(identity.Provider)
+		if !ok || linked.Subject != identity.Subject {
+			attempt.forget()
+			return models.NewError(models.KindUnauthorized, "ID token does not belong to a linked identity")
+		}
+	}
+	if err := user.VerifyMFA(code); err != nil {
+		if err == models.ErrInvalidMFACode {
+			attempt.failed()
+		} else {
+			attempt.forget()
+		}
+		return err
+	}
+	attempt.succeeded()
+	return nil
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReauthenticateIsThrottled(t *testing.T) {
+	defer useMemoryThrottleStore()()
+	user := &models.User{Username: "test" + bson.NewObjectId().Hex()}
+	user.SetId(bson.NewObjectId())
+	user.SetPassword("correct horse battery")
+	client := models.SessionClient{IP: "192.0.2.1"}
+
+	if err := Reauthenticate(user, client, "correct horse battery", "", "", ""); err != nil {
+		t.Fatalf("Reauthenticate() with the password returned %v", err)
+	}
+	for i := 0; i < freeAttempts; i++ {
+		err := Reauthenticate(user, client, "wrong password", "", "", "")
+		if domainErr, ok := err.(*models.Error); !ok || domainErr.Kind != models.KindUnauthorized {
+			t.Fatalf("Reauthenticate() with a wrong password returned %v, want an unauthorized *models.Error", err)
+		}
+	}
+	err := Reauthenticate(user, client, "correct horse battery", "", "", "")
+	if domainErr, ok := err.(*models.Error); !ok || domainErr.Kind != models.KindTooManyRequests {
+		t.Fatalf("Reauthenticate() after %d failures returned %v, want a too-many-requests *models.Error",
+			freeAttempts, err)
+	}
+}
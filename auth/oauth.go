@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Error codes of RFC 6749 section 5.2
+const (
+	errInvalidRequest       = "invalid_request"
+	errInvalidClient        = "invalid_client"
+	errInvalidGrant         = "invalid_grant"
+	errUnsupportedGrantType = "unsupported_grant_type"
+	errInvalidScope         = "invalid_scope"
+	errServerError          = "server_error"
+)
+
+// Error codes MagicBox adds, mfa_required asks for the second step of a login and too_many_attempts throttles it
+const (
+	errMFARequired     = "mfa_required"
+	errTooManyAttempts = "too_many_attempts"
+)
+
+// TokenError is an RFC 6749 error response of the token endpoint
+type TokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// RetryAfter is sent in the Retry-After header of throttled requests
+	RetryAfter time.Duration `json:"-"`
+}
+
+func newTokenError(code, description string) *TokenError {
+	return &TokenError{Code: code, Description: description}
+}
+
+/*
+newHiddenTokenError logs err, which may tell internals of the server or of an identity provider, and returns an
+error whose description is only the fixed one given
+*/
+func newHiddenTokenError(code, description string, err error) *TokenError {
+	log.Printf("%s: %s", description, err)
+	return newTokenError(code, description)
+}
+
+// InvalidRequest returns an invalid_request error, for token requests that can't even be parsed
+func InvalidRequest(description string) *TokenError {
+	return newTokenError(errInvalidRequest, description)
+}
+
+func (e *TokenError) Error() string {
+	return e.Description
+}
+
+// Status returns the HTTP status the error is answered with
+func (e *TokenError) Status() int {
+	switch e.Code {
+	case errInvalidClient:
+		return http.StatusUnauthorized
+	case errMFARequired:
+		return http.StatusForbidden
+	case errTooManyAttempts:
+		return http.StatusTooManyRequests
+	case errServerError:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// IsInvalidClient returns whether the error is a failed client authentication
+func (e *TokenError) IsInvalidClient() bool {
+	return e.Code == errInvalidClient
+}
+
+// AsTokenError returns err as a *TokenError, errors of any other type become a server_error without their text
+func AsTokenError(err error) *TokenError {
+	if tokenErr, ok := err.(*TokenError); ok {
+		return tokenErr
+	}
+	return newTokenError(errServerError, "The request could not be completed")
+}
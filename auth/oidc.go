@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mendsley/gojwk"
+)
+
+const (
+	discoveryPath    = "/.well-known/openid-configuration"
+	discoveryTTL     = 24 * time.Hour
+	providerKeysTTL  = time.Hour
+	minRefreshPeriod = time.Minute
+	clockLeeway      = time.Minute
+)
+
+const googleClientID = "144467579021-gmpp7n1a9m3b82svfs51eqjbs0bhidkk.apps.googleusercontent.com"
+
+// defaultProviders are used when OIDC_PROVIDERS is not set
+var defaultProviders = `[{
+	"name": "google",
+	"issuer": "https://accounts.google.com",
+	"additionalIssuers": ["accounts.google.com"],
+	"clientId": "` + googleClientID + `"
+}]`
+
+/*
+OIDCProvider is an OpenID Connect identity provider users can log in with. Its discovery document and keys are
+cached, the keys are fetched again when a token is signed with an unknown one, at most once per minute
+*/
+type OIDCProvider struct {
+	Name              string   `json:"name"`
+	Issuer            string   `json:"issuer"`
+	ClientID          string   `json:"clientId"`
+	DiscoveryURL      string   `json:"discoveryUrl,omitempty"`
+	AdditionalIssuers []string `json:"additionalIssuers,omitempty"`
+
+	Client *http.Client     `json:"-"`
+	Now    func() time.Time `json:"-"`
+
+	mu               sync.Mutex
+	jwksURI          string
+	discoveryExpires time.Time
+	keys             map[string]crypto.PublicKey
+	keysExpires      time.Time
+	keysFetched      time.Time
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token MagicBox uses
+type IDTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	ExpiresAt       int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce,omitempty"`
+	AuthorizedParty string       `json:"azp,omitempty"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	GivenName       string       `json:"given_name"`
+	FamilyName      string       `json:"family_name"`
+	Picture         string       `json:"picture"`
+
+	now time.Time
+}
+
+// audience is the aud claim, which is either a single string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = audience(many)
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// flexibleBool accepts both true and "true", some providers send email_verified as a string
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := strconv.ParseBool(s)
+		*b = flexibleBool(parsed)
+		return err
+	}
+	var parsed bool
+	err := json.Unmarshal(data, &parsed)
+	*b = flexibleBool(parsed)
+	return err
+}
+
+// Valid checks the time claims, it is called by jwt-go while parsing
+func (c *IDTokenClaims) Valid() error {
+	if c.ExpiresAt == 0 || c.now.Add(-clockLeeway).Unix() > c.ExpiresAt {
+		return errors.New("ID token is expired")
+	}
+	if c.IssuedAt > c.now.Add(clockLeeway).Unix() {
+		return errors.New("ID token was issued in the future")
+	}
+	return nil
+}
+
+var (
+	oidcProviders    []*OIDCProvider
+	errUnknownIssuer = errors.New("ID token was issued by an unknown provider")
+)
+
+// isLoopback returns whether host is the local machine, where a stand-in provider may be served over http
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (p *OIDCProvider) validate() error {
+	if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+		return errors.New("OIDC providers need a name, an issuer and a clientId")
+	}
+	if p.DiscoveryURL == "" {
+		p.DiscoveryURL = strings.TrimSuffix(p.Issuer, "/") + discoveryPath
+	}
+	u, err := url.Parse(p.DiscoveryURL)
+	if err != nil || (u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Host))) {
+		return fmt.Errorf("OIDC provider %s must be served over https", p.Name)
+	}
+	if p.Client == nil {
+		p.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if p.Now == nil {
+		p.Now = time.Now
+	}
+	return nil
+}
+
+func loadOIDCProviders() {
+	config := os.Getenv("OIDC_PROVIDERS")
+	if config == "" {
+		config = defaultProviders
+	}
+	if err := json.Unmarshal([]byte(config), &oidcProviders); err != nil {
+		log.Fatal("OIDC_PROVIDERS is not valid JSON: ", err)
+	}
+	for _, p := range oidcProviders {
+		if err := p.validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// GetOIDCProvider returns the configured provider called name
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+	for _, p := range oidcProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// isIssuer returns whether iss identifies p
+func (p *OIDCProvider) isIssuer(iss string) bool {
+	if iss == p.Issuer {
+		return true
+	}
+	for _, additional := range p.AdditionalIssuers {
+		if iss == additional {
+			return true
+		}
+	}
+	return false
+}
+
+// getOIDCProviderForToken returns the provider that issued idToken, reading its claims without verifying them
+func getOIDCProviderForToken(idToken string) (*OIDCProvider, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token is malformed")
+	}
+	payload, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := &IDTokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, err
+	}
+	for _, p := range oidcProviders {
+		if p.isIssuer(claims.Issuer) {
+			return p, nil
+		}
+	}
+	return nil, errUnknownIssuer
+}
+
+// maxAge returns the max-age of a response's Cache-Control header, or fallback
+func maxAge(resp *http.Response, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				if age := time.Duration(seconds) * time.Second; age > minRefreshPeriod {
+					return age
+				}
+				return minRefreshPeriod
+			}
+		}
+	}
+	return fallback
+}
+
+func (p *OIDCProvider) getJSON(url string, v interface{}, fallbackTTL time.Duration) (time.Duration, error) {
+	resp, err := p.Client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return maxAge(resp, fallbackTTL), json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover fetches the discovery document if the cached one expired, p.mu must be held
+func (p *OIDCProvider) discover(now time.Time) error {
+	if p.jwksURI != "" && now.Before(p.discoveryExpires) {
+		return nil
+	}
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	ttl, err := p.getJSON(p.DiscoveryURL, &doc, discoveryTTL)
+	if err != nil {
+		return err
+	}
+	if doc.Issuer != p.Issuer || doc.JWKSURI == "" {
+		return fmt.Errorf("Discovery document of %s does not match its issuer", p.Name)
+	}
+	p.jwksURI, p.discoveryExpires = doc.JWKSURI, now.Add(ttl)
+	return nil
+}
+
+// fetchKeys downloads the provider keys, p.mu must be held
+func (p *OIDCProvider) fetchKeys(now time.Time) error {
+	if err := p.discover(now); err != nil {
+		return err
+	}
+	var set gojwk.Key
+	ttl, err := p.getJSON(p.jwksURI, &set, providerKeysTTL)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.DecodePublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys, p.keysExpires, p.keysFetched = keys, now.Add(ttl), now
+	return nil
+}
+
+// getKey returns the provider key kid, fetching the keys again when they expired or kid is unknown
+func (p *OIDCProvider) getKey(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.Now()
+	key, ok := p.keys[kid]
+	if ok && now.Before(p.keysExpires) {
+		return key, nil
+	}
+	if !ok && now.Sub(p.keysFetched) < minRefreshPeriod {
+		return nil, fmt.Errorf("ID token was signed with an unknown key of %s", p.Name)
+	}
+	if err := p.fetchKeys(now); err != nil {
+		return nil, err
+	}
+	if key, ok = p.keys[kid]; !ok {
+		return nil, fmt.Errorf("ID token was signed with an unknown key of %s", p.Name)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := p.getKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+}
+
+/*
+Verify checks the signature and the claims of an ID token issued for MagicBox by p. nonce is the value the client
+asked the token for, it is required and must match the nonce claim so tokens can't be replayed
+*/
+func (p *OIDCProvider) Verify(idToken, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{now: p.Now()}
+	if _, err := jwt.ParseWithClaims(idToken, claims, p.keyFunc); err != nil {
+		return nil, err
+	}
+	if !p.isIssuer(claims.Issuer) {
+		return nil, errUnknownIssuer
+	}
+	if !claims.Audience.contains(p.ClientID) {
+		return nil, errors.New("ID token was not issued for MagicBox")
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("ID token was authorized for another party")
+	}
+	if nonce == "" {
+		return nil, errors.New("Parameter nonce is required")
+	}
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(claims.Nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
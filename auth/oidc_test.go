@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/mendsley/gojwk"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	testClientID = "magicbox-test"
+	testKeyID    = "test-key"
+	testNonce    = "test-nonce"
+)
+
+var testNow = time.Date(2019, time.January, 10, 12, 0, 0, 0, time.UTC)
+
+// testIdP is a stand-in identity provider serving a discovery document and the keys of its ID tokens
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   idp.server.URL,
+			"jwks_uri": idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := gojwk.PublicKey(&key.PublicKey)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		jwk.Kid, jwk.Use = testKeyID, "sig"
+		json.NewEncoder(w).Encode(gojwk.Key{Keys: []*gojwk.Key{jwk}})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *testIdP) provider(t *testing.T) *OIDCProvider {
+	p := &OIDCProvider{
+		Name:     "test",
+		Issuer:   idp.server.URL,
+		ClientID: testClientID,
+		Now:      func() time.Time { return testNow },
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// claims returns valid claims of a token issued by idp for MagicBox
+func (idp *testIdP) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"sub":            "1234567890",
+		"aud":            testClientID,
+		"exp":            testNow.Add(time.Hour).Unix(),
+		"iat":            testNow.Unix(),
+		"nonce":          testNonce,
+		"email":          "test@example.com",
+		"email_verified": true,
+	}
+}
+
+func (idp *testIdP) sign(t *testing.T, claims jwt.MapClaims, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCProviderVerify(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		kid    string
+		nonce  string
+		token  func(claims jwt.MapClaims) string
+		valid  bool
+	}{
+		{name: "valid", nonce: testNonce, valid: true},
+		{name: "audience list authorized for MagicBox", nonce: testNonce, valid: true, modify: func(c jwt.MapClaims) {
+			c["aud"], c["azp"] = []string{testClientID, "other"}, testClientID
+		}},
+		{name: "missing nonce", nonce: ""},
+		{name: "missing nonce of a token without one", nonce: "", modify: func(c jwt.MapClaims) {
+			delete(c, "nonce")
+		}},
+		{name: "nonce the token wasn't issued for", nonce: "other-nonce"},
+		{name: "token without nonce", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			delete(c, "nonce")
+		}},
+		{name: "wrong audience", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			c["aud"] = "other"
+		}},
+		{name: "authorized for another party", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			c["aud"], c["azp"] = []string{testClientID, "other"}, "other"
+		}},
+		{name: "wrong issuer", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			c["iss"] = "https://issuer.example.com"
+		}},
+		{name: "expired", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			c["exp"] = testNow.Add(-2 * clockLeeway).Unix()
+		}},
+		{name: "issued in the future", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			c["iat"] = testNow.Add(2 * clockLeeway).Unix()
+		}},
+		{name: "without subject", nonce: testNonce, modify: func(c jwt.MapClaims) {
+			delete(c, "sub")
+		}},
+		{name: "unknown key", nonce: testNonce, kid: "other-key"},
+		{name: "signed with another key", nonce: testNonce, token: func(c jwt.MapClaims) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+			token.Header["kid"] = testKeyID
+			signed, _ := token.SignedString(otherKey)
+			return signed
+		}},
+		{name: "unsigned", nonce: testNonce, token: func(c jwt.MapClaims) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, c)
+			token.Header["kid"] = testKeyID
+			signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return signed
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := idp.claims()
+			if test.modify != nil {
+				test.modify(claims)
+			}
+			kid := test.kid
+			if kid == "" {
+				kid = testKeyID
+			}
+			var idToken string
+			if test.token != nil {
+				idToken = test.token(claims)
+			} else {
+				idToken = idp.sign(t, claims, kid)
+			}
+
+			verified, err := idp.provider(t).Verify(idToken, test.nonce)
+			if test.valid && err != nil {
+				t.Fatalf("Verify() returned %v, want no error", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("Verify() accepted the token, want an error")
+			}
+			if test.valid && verified.Subject != claims["sub"] {
+				t.Errorf("Verify() subject = %q, want %q", verified.Subject, claims["sub"])
+			}
+		})
+	}
+}
+
+func TestOIDCProviderRefusesDiscoveryOfAnotherIssuer(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	p := idp.provider(t)
+	p.Issuer = "https://issuer.example.com"
+	p.AdditionalIssuers = []string{idp.server.URL}
+
+	if _, err := p.Verify(idp.sign(t, idp.claims(), testKeyID), testNonce); err == nil {
+		t.Fatal("Verify() accepted a token whose keys were discovered for another issuer")
+	}
+}
+
+func TestVerifyIDTokenHidesTheCause(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	p := idp.provider(t)
+	expired := idp.claims()
+	expired["exp"] = testNow.Add(-time.Hour).Unix()
+	unknownKey := idp.sign(t, idp.claims(), "other-key")
+
+	for _, token := range []string{"malformed", idp.sign(t, expired, testKeyID), unknownKey} {
+		_, err := verifyIDToken(p, token, testNonce)
+		if tokenErr, ok := err.(*TokenError); !ok || tokenErr.Description != descInvalidIDToken {
+			t.Errorf("verifyIDToken() returned %v, want an invalid_grant *TokenError described %q", err,
+				descInvalidIDToken)
+		}
+	}
+}
+
+var connectOnce sync.Once
+
+// requireDatabase skips tests which need mongo when MONGO_URL is not set, and connects to it otherwise
+func requireDatabase(t *testing.T) {
+	if os.Getenv("MONGO_URL") == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	connectOnce.Do(models.Connect)
+}
+
+func TestVerifyIDTokenConsumesNonceOnlyOnceVerified(t *testing.T) {
+	requireDatabase(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	p := idp.provider(t)
+	nonce, err := models.NewLoginNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := idp.claims()
+	claims["nonce"] = nonce
+	valid := idp.sign(t, claims, testKeyID)
+	claims["aud"] = "other"
+	forged := idp.sign(t, claims, testKeyID)
+
+	if _, err := verifyIDToken(p, forged, nonce); err == nil {
+		t.Fatal("verifyIDToken() accepted a token issued for another client")
+	}
+	if _, err := verifyIDToken(p, valid, nonce); err != nil {
+		t.Fatalf("verifyIDToken() returned %v after a failed attempt, the nonce must not be spent", err)
+	}
+	if _, err := verifyIDToken(p, valid, nonce); err == nil {
+		t.Fatal("verifyIDToken() accepted a nonce twice")
+	}
+}
+
+func TestUserRequestFromIDTokenPicksAFreeUsername(t *testing.T) {
+	requireDatabase(t)
+	local := "test" + bson.NewObjectId().Hex()
+
+	for _, want := range []string{local, local + "2", local + "3"} {
+		claims := &IDTokenClaims{Email: local + "@" + bson.NewObjectId().Hex() + ".example.com", GivenName: "Test"}
+		request, err := userRequestFromIDToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.Username != want {
+			t.Fatalf("userRequestFromIDToken() returned the username %q, want %q", request.Username, want)
+		}
+		user, err := models.NewUser(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := user.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+/*
+Failed logins are counted within a sliding window per username and per client IP. After a few failures every new
+attempt of a key must wait a delay which doubles with each failure, and after too many the key is locked for a
+while, so a username can't be guessed at and an IP can't spray passwords at many usernames. A successful login only
+clears its username, and admins can unlock any key. LOGIN_THROTTLE_STORE chooses where failures are kept, mongo
+(the default, shared by every replica) or memory.
+*/
+
+const (
+	throttleWindow      = 15 * time.Minute
+	freeAttempts        = 3
+	maxThrottleDelay    = 30 * time.Second
+	userLockoutFailures = 10
+	ipLockoutFailures   = 50
+	lockoutDuration     = 15 * time.Minute
+	// nonceLimit is how many login nonces a client IP can ask for within the window
+	nonceLimit = 30
+)
+
+// Kinds of throttled keys
+const (
+	ThrottleUser = "user"
+	ThrottleIP   = "ip"
+)
+
+var throttleStore models.ThrottleStore
+
+// throttleKey is a key failures are counted for, locked once it reaches lockoutAfter failures
+type throttleKey struct {
+	kind         string
+	key          string
+	lockoutAfter int
+}
+
+// LockoutResponse is a struct that resembles a response for the throttling state of a username or an IP
+type LockoutResponse struct {
+	Kind        string     `json:"kind"`
+	Value       string     `json:"value"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"lockedUntil"`
+}
+
+func loadThrottleStore() {
+	switch store := os.Getenv("LOGIN_THROTTLE_STORE"); store {
+	case "", "mongo":
+		throttleStore = models.NewMongoThrottleStore()
+	case "memory":
+		throttleStore = models.NewMemoryThrottleStore()
+	default:
+		log.Fatalf("LOGIN_THROTTLE_STORE %q must be mongo or memory", store)
+	}
+}
+
+func throttleKeyOf(kind, value string) (throttleKey, error) {
+	switch kind {
+	case ThrottleUser:
+		key := "user:" + strings.ToLower(strings.TrimSpace(value))
+		return throttleKey{kind: kind, key: key, lockoutAfter: userLockoutFailures}, nil
+	case ThrottleIP:
+		return throttleKey{kind: kind, key: "ip:" + value, lockoutAfter: ipLockoutFailures}, nil
+	}
+	return throttleKey{}, fmt.Errorf("Unknown lockout kind %q", kind)
+}
+
+// loginThrottleKeys returns the keys a login of username from client counts for
+func loginThrottleKeys(username string, client models.SessionClient) []throttleKey {
+	user, _ := throttleKeyOf(ThrottleUser, username)
+	ip, _ := throttleKeyOf(ThrottleIP, client.IP)
+	return []throttleKey{user, ip}
+}
+
+// throttleDelay returns how long after its last failure a key with failures can try again
+func throttleDelay(failures int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	shift := uint(failures - freeAttempts)
+	if shift > 5 {
+		return maxThrottleDelay
+	}
+	if delay := time.Second << shift; delay < maxThrottleDelay {
+		return delay
+	}
+	return maxThrottleDelay
+}
+
+func newThrottleError(description string, retryAfter time.Duration) *TokenError {
+	tokenErr := newTokenError(errTooManyAttempts, description)
+	tokenErr.RetryAfter = retryAfter
+	return tokenErr
+}
+
+// loginAttempt is a login being tried for keys, counted as failed from the start so concurrent guesses see each other
+type loginAttempt struct {
+	keys []throttleKey
+	at   time.Time
+}
+
+/*
+startLoginAttempt records a login for keys and returns an error telling when to retry if any of them is locked or
+waiting its delay. The attempt counts as failed until it succeeds or is forgotten, and the delay is decided from the
+failures recorded before it in the same atomic operation, so concurrent attempts can't all slip through the check
+before any of them fails. Refused attempts are forgotten, waiting doesn't make the delay longer
+*/
+func startLoginAttempt(keys []throttleKey) (loginAttempt, error) {
+	attempt := loginAttempt{at: time.Now()}
+	for _, k := range keys {
+		attempt.keys = append(attempt.keys, k)
+		if err := throttleAttempt(k, attempt.at); err != nil {
+			attempt.forget()
+			return loginAttempt{}, err
+		}
+	}
+	return attempt, nil
+}
+
+// throttleAttempt records an attempt of k at now, it returns an error if k is locked or the attempts before wait
+func throttleAttempt(k throttleKey, now time.Time) error {
+	lockedUntil, err := throttleStore.LockedUntil(k.key)
+	if err != nil {
+		return err
+	}
+	if lockedUntil.After(now) {
+		return newThrottleError("Too many failed logins, try again later", lockedUntil.Sub(now))
+	}
+	failures, err := throttleStore.RecordFailure(k.key, now, now.Add(-throttleWindow))
+	if err != nil {
+		return err
+	}
+	before := failures[:len(failures)-1]
+	if len(before) == 0 {
+		return nil
+	}
+	if retry := before[len(before)-1].Add(throttleDelay(len(before))); retry.After(now) {
+		return newThrottleError("Too many failed logins, slow down", retry.Sub(now))
+	}
+	return nil
+}
+
+// failed leaves the attempt counted as a failure, locking the keys that failed too many times
+func (a loginAttempt) failed() {
+	for _, k := range a.keys {
+		failures, err := throttleStore.Failures(k.key, a.at.Add(-throttleWindow))
+		if err != nil {
+			log.Println("Could not count failed logins", err)
+			continue
+		}
+		if len(failures) >= k.lockoutAfter {
+			log.Printf("Locking %s after %d failed logins", k.key, len(failures))
+			if err := throttleStore.Lock(k.key, a.at.Add(lockoutDuration)); err != nil {
+				log.Println("Could not lock", k.key, err)
+			}
+		}
+	}
+}
+
+// succeeded forgets the failures of the username that logged in, those of its IP still count
+func (a loginAttempt) succeeded() {
+	for _, k := range a.keys {
+		var err error
+		if k.kind == ThrottleUser {
+			err = throttleStore.Reset(k.key)
+		} else {
+			err = throttleStore.ForgetFailure(k.key, a.at)
+		}
+		if err != nil {
+			log.Println("Could not reset login throttle", err)
+		}
+	}
+}
+
+// forget stops counting the attempt, for those which ended before its credentials were checked
+func (a loginAttempt) forget() {
+	for _, k := range a.keys {
+		if err := throttleStore.ForgetFailure(k.key, a.at); err != nil {
+			log.Println("Could not forget login attempt", err)
+		}
+	}
+}
+
+/*
+throttleRequest counts a request of key and returns how long until key can make another one, zero if this one is
+within the limit of requests of the window. Refused requests count too, so a client that keeps asking keeps waiting
+*/
+func throttleRequest(key string, limit int, window time.Duration) (time.Duration, error) {
+	now := time.Now()
+	requests, err := throttleStore.RecordFailure(key, now, now.Add(-window))
+	if err != nil || len(requests) <= limit {
+		return 0, err
+	}
+	return requests[len(requests)-limit-1].Add(window).Sub(now), nil
+}
+
+// ThrottleLoginNonce counts a request for a login nonce from ip and returns how long it must wait if it asked too many
+func ThrottleLoginNonce(ip string) (time.Duration, error) {
+	return throttleRequest("nonce:"+ip, nonceLimit, throttleWindow)
+}
+
+// GetLockout returns the throttling state of a username or an IP, as told by kind
+func GetLockout(kind, value string) (LockoutResponse, error) {
+	k, err := throttleKeyOf(kind, value)
+	if err != nil {
+		return LockoutResponse{}, err
+	}
+	now := time.Now()
+	failures, err := throttleStore.Failures(k.key, now.Add(-throttleWindow))
+	if err != nil {
+		return LockoutResponse{}, err
+	}
+	response := LockoutResponse{Kind: kind, Value: value, Failures: len(failures)}
+	lockedUntil, err := throttleStore.LockedUntil(k.key)
+	if err != nil {
+		return LockoutResponse{}, err
+	}
+	if lockedUntil.After(now) {
+		response.LockedUntil = &lockedUntil
+	}
+	return response, nil
+}
+
+// Unlock clears the failures and the lock of a username or an IP, as told by kind
+func Unlock(kind, value string) error {
+	k, err := throttleKeyOf(kind, value)
+	if err != nil {
+		return err
+	}
+	return throttleStore.Reset(k.key)
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+// useMemoryThrottleStore throttles with a new memory store for the test, it returns a function restoring the store
+func useMemoryThrottleStore() func() {
+	store := throttleStore
+	throttleStore = models.NewMemoryThrottleStore()
+	return func() { throttleStore = store }
+}
+
+func TestThrottleLoginNonce(t *testing.T) {
+	defer useMemoryThrottleStore()()
+
+	for i := 0; i < nonceLimit; i++ {
+		if wait, err := ThrottleLoginNonce("192.0.2.1"); err != nil || wait != 0 {
+			t.Fatalf("ThrottleLoginNonce() of request %d returned %s, %v, want it allowed", i+1, wait, err)
+		}
+	}
+	wait, err := ThrottleLoginNonce("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait <= 0 || wait > throttleWindow {
+		t.Errorf("ThrottleLoginNonce() past the limit returned a wait of %s, want up to %s", wait, throttleWindow)
+	}
+	if wait, err := ThrottleLoginNonce("192.0.2.2"); err != nil || wait != 0 {
+		t.Errorf("ThrottleLoginNonce() of another IP returned %s, %v, want it allowed", wait, err)
+	}
+}
+
+func TestThrottleRequestFreesSlotsAsTheWindowSlides(t *testing.T) {
+	defer useMemoryThrottleStore()()
+	window := 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := throttleRequest("key", 2, window); wait != 0 {
+			t.Fatalf("throttleRequest() refused request %d of 2", i+1)
+		}
+	}
+	wait, _ := throttleRequest("key", 2, window)
+	if wait <= 0 || wait > window {
+		t.Fatalf("throttleRequest() past the limit returned a wait of %s, want up to %s", wait, window)
+	}
+	time.Sleep(window)
+	if wait, _ := throttleRequest("key", 2, window); wait != 0 {
+		t.Errorf("throttleRequest() once the window slid past the requests returned a wait of %s", wait)
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeAttempts - 1, 0},
+		{freeAttempts, time.Second},
+		{freeAttempts + 1, 2 * time.Second},
+		{freeAttempts + 4, 16 * time.Second},
+		{freeAttempts + 5, maxThrottleDelay},
+		{freeAttempts + 6, maxThrottleDelay},
+		{freeAttempts + 100, maxThrottleDelay},
+	}
+	for _, test := range tests {
+		if delay := throttleDelay(test.failures); delay != test.want {
+			t.Errorf("throttleDelay(%d) returned %s, want %s", test.failures, delay, test.want)
+		}
+	}
+}
+
+func TestStartLoginAttemptLetsOneConcurrentGuessThrough(t *testing.T) {
+	defer useMemoryThrottleStore()()
+	keys := loginThrottleKeys("someone", models.SessionClient{IP: "192.0.2.1"})
+	for i := 0; i < freeAttempts-1; i++ {
+		attempt, err := startLoginAttempt(keys)
+		if err != nil {
+			t.Fatalf("startLoginAttempt() refused free attempt %d: %v", i+1, err)
+		}
+		attempt.failed()
+	}
+
+	allowed := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := startLoginAttempt(keys)
+			allowed <- err == nil
+		}()
+	}
+	count := 0
+	for i := 0; i < 10; i++ {
+		if <-allowed {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("startLoginAttempt() allowed %d of 10 concurrent attempts after %d failures, want 1", count,
+			freeAttempts-1)
+	}
+}
+
+func TestLoginAttempts(t *testing.T) {
+	defer useMemoryThrottleStore()()
+	client := models.SessionClient{IP: "192.0.2.1"}
+	keys := loginThrottleKeys("someone", client)
+	ip, _ := throttleKeyOf(ThrottleIP, client.IP)
+
+	attempt, err := startLoginAttempt(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempt.forget()
+	if failures, _ := throttleStore.Failures(ip.key, time.Time{}); len(failures) != 0 {
+		t.Errorf("forget() left %d failures, want none", len(failures))
+	}
+	for i := 0; i < 2; i++ {
+		if attempt, err = startLoginAttempt(keys); err != nil {
+			t.Fatal(err)
+		}
+		attempt.failed()
+	}
+	if attempt, err = startLoginAttempt(keys); err != nil {
+		t.Fatal(err)
+	}
+	attempt.succeeded()
+	lockout, _ := GetLockout(ThrottleUser, "someone")
+	if lockout.Failures != 0 {
+		t.Errorf("succeeded() left the username with %d failures, want none", lockout.Failures)
+	}
+	if lockout, _ = GetLockout(ThrottleIP, client.IP); lockout.Failures != 2 {
+		t.Errorf("succeeded() left the IP with %d failures, want the 2 failed attempts", lockout.Failures)
+	}
+}
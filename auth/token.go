@@ -1,8 +1,10 @@
 package auth
 
 import (
-	"errors"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"net/url"
 	"time"
 
@@ -11,76 +13,340 @@ import (
 )
 
 const (
-	grantTypePassword = "password"
+	grantTypePassword          = "password"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeIDToken           = "urn:ietf:params:oauth:token-type:id_token"
+	tokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
 )
 
-// TokenClaims is a struct for the JWT claims
+// Descriptions of token errors whose cause is only logged, so no internals reach the client
+const (
+	descInvalidRefreshToken = "Invalid or expired refresh token"
+	descRefreshTokenReused  = "Refresh token was already used, the session has been revoked"
+	descInvalidIDToken      = "Invalid or expired ID token"
+	descSignUpFailed        = "Could not create an account for this identity"
+)
+
+/*
+TokenClaims is a struct for the JWT claims, their subject is the id of the user the token acts on behalf of. Tokens
+of a user login carry the id of their session, tokens of the client_credentials grant carry the client id and their
+scope instead and act on behalf of the client's owner. Personal access tokens aren't JWTs, but are described by the
+same claims
+*/
 type TokenClaims struct {
-	User models.UserResponse `json:"user"`
+	SessionID       string `json:"sid,omitempty"`
+	ClientID        string `json:"client_id,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	PersonalTokenID string `json:"-"`
 	jwt.StandardClaims
 }
 
-var mySigningKey = []byte("AllYourBase") //TODO use real key
+// IsDelegated returns whether the token acts on behalf of a user limited to a scope, instead of being a login
+func (c *TokenClaims) IsDelegated() bool {
+	return c.ClientID != "" || c.PersonalTokenID != ""
+}
+
+/*
+IsRevokedFor returns whether user revoked the token, by revoking the tokens issued until some time or the token
+itself. Personal access tokens are revoked one by one, so revoking every token of the user leaves them valid
+*/
+func (c *TokenClaims) IsRevokedFor(user models.User) bool {
+	if c.PersonalTokenID == "" && user.IsTokenRevoked(time.Unix(c.IssuedAt, 0)) {
+		return true
+	}
+	return models.IsTokenDenied(c.Id)
+}
 
-func getJWT(user models.User) (string, error) {
+// TokenResponse is the RFC 6749 body of a successful token request
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// tokenRecorder keeps the ids of the access tokens it is issued, so they can be denied when it is revoked
+type tokenRecorder interface {
+	AddAccessToken(jti string, expiresAt time.Time) error
+}
 
-	claims := TokenClaims{user.GetResponse(), jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
+func newJTI() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		log.Panic("Could not generate token id ", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func newClaims(user models.User) TokenClaims {
+	now := time.Now()
+	return TokenClaims{StandardClaims: jwt.StandardClaims{
+		Id:        newJTI(),
+		ExpiresAt: now.Add(models.AccessTokenTTL).Unix(),
 		Issuer:    "magicbox.auh",
-		IssuedAt:  time.Now().Unix(),
-		Subject:   user.Username,
+		IssuedAt:  now.Unix(),
+		Subject:   user.GetId().Hex(),
 	}}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	return token.SignedString(mySigningKey)
-}
-
-// GetAuthTokenFromForm returns an auth token for the requesting user and passoword, or an error
-func GetAuthTokenFromForm(form url.Values) (token string, err error) {
-	grantType := form.Get("grant_type")
-	if grantType == grantTypePassword {
-		username, password := form.Get("username"), form.Get("password")
-		user, err := models.GetUserByUsername(username)
-		if err != nil {
-			return token, errors.New("Invalid username or password")
-		}
-		if ok := user.ChallengePassword(password); !ok {
-			return token, errors.New("Invalid username or password")
-		}
+}
 
-		return getJWT(*user)
+// getJWT signs claims, whose jti is recorded in recorder first so the token can be revoked
+func getJWT(claims TokenClaims, recorder tokenRecorder) (TokenResponse, error) {
+	if err := recorder.AddAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return TokenResponse{}, err
+	}
+	accessToken, err := signToken(claims)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(models.AccessTokenTTL / time.Second),
+		Scope:       claims.Scope,
+	}, nil
+}
 
+func getSessionToken(user models.User, session *models.Session, refreshToken string) (TokenResponse, error) {
+	claims := newClaims(user)
+	claims.SessionID = session.GetId().Hex()
+	token, err := getJWT(claims, session)
+	token.RefreshToken = refreshToken
+	return token, err
+}
+
+/*
+suspendedError returns the error suspended users get when asking for tokens. The reason of the suspension is a note
+of the admins, it isn't told to whoever holds the credentials
+*/
+func suspendedError() *TokenError {
+	return newTokenError(errInvalidGrant, "Account is suspended")
+}
+
+// login opens a new session for user and returns its first tokens, unless the user is suspended
+func login(user models.User, client models.SessionClient) (TokenResponse, error) {
+	if user.IsSuspended() {
+		return TokenResponse{}, suspendedError()
 	}
-	return token, errors.New("Unsupported grant type")
+	session, refreshToken, err := models.NewSession(user, client)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return getSessionToken(user, session, refreshToken)
 }
 
 /*
-GetAuthTokenFromGoogleToken returns an auth token from a frontend google auth requests
-If user does not exist in database, it is created
+RefreshAuthToken returns a new access token and a new refresh token for the session of refreshToken, which can't
+be used again
 */
-func GetAuthTokenFromGoogleToken(googleReq GoogleFrontendRequest) (token string, err error) {
-	if err = validateGoogleToken(googleReq.Token); err != nil {
-		return
+func RefreshAuthToken(refreshToken string, client models.SessionClient) (TokenResponse, error) {
+	if refreshToken == "" {
+		return TokenResponse{}, newTokenError(errInvalidRequest, "Parameter refresh_token is required")
+	}
+	session, next, err := models.RefreshSession(refreshToken, client)
+	if err == models.ErrRefreshTokenReused {
+		return TokenResponse{}, newTokenError(errInvalidGrant, descRefreshTokenReused)
+	}
+	if err == models.ErrInvalidRefreshToken {
+		return TokenResponse{}, newTokenError(errInvalidGrant, descInvalidRefreshToken)
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	user, err := models.GetUserByID(session.User.Hex())
+	if err != nil {
+		return TokenResponse{}, newTokenError(errInvalidGrant, descInvalidRefreshToken)
+	}
+	if !user.IsActive() {
+		return TokenResponse{}, newTokenError(errInvalidGrant, "Email address is not verified yet")
+	}
+	if user.IsSuspended() {
+		return TokenResponse{}, suspendedError()
 	}
+	return getSessionToken(user, session, next)
+}
 
-	email := googleReq.Profile.Email
-	req := userRequestFromGoogleProfile(googleReq.Profile)
-	user, err := models.GetUserByEmail(email)
+/*
+GetClientCredentialsToken authenticates an API client and returns an access token acting on behalf of its
+owner, limited to scope. No refresh token is issued, the client asks for a new token when it expires
+*/
+func GetClientCredentialsToken(clientID, clientSecret, scope string) (TokenResponse, error) {
+	if clientID == "" || clientSecret == "" {
+		return TokenResponse{}, newTokenError(errInvalidClient, "Client authentication is required")
+	}
+	client, err := models.AuthenticateAPIClient(clientID, clientSecret)
 	if err != nil {
-		user, err = models.NewUser(req)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		if err = user.Save(); err != nil {
-			fmt.Println(err)
-			return
+		return TokenResponse{}, newHiddenTokenError(errInvalidClient, "Invalid client credentials", err)
+	}
+	granted, err := client.GrantScopes(models.ParseScopes(scope))
+	if err != nil {
+		return TokenResponse{}, newHiddenTokenError(errInvalidScope, "Requested scope exceeds the scopes of the client", err)
+	}
+	owner, err := models.GetUserByID(client.Owner.Hex())
+	if err != nil {
+		return TokenResponse{}, newTokenError(errInvalidClient, "Client owner no longer exists")
+	}
+	if owner.IsSuspended() {
+		return TokenResponse{}, suspendedError()
+	}
+
+	claims := newClaims(owner)
+	claims.ClientID = clientID
+	claims.Scope = models.JoinScopes(granted)
+	return getJWT(claims, client)
+}
+
+// Logout revokes the session of the access token claims belongs to, along with the token itself
+func Logout(claims *TokenClaims) error {
+	current := models.IssuedToken{JTI: claims.Id, ExpiresAt: time.Unix(claims.ExpiresAt, 0)}
+	if err := models.DenyTokens([]models.IssuedToken{current}); err != nil {
+		return err
+	}
+	session, err := models.GetSessionByID(claims.SessionID)
+	if err != nil {
+		return nil
+	}
+	return session.Revoke()
+}
+
+// getPasswordToken returns an auth token for the requesting user and passoword, or an error
+func getPasswordToken(username, password string, client models.SessionClient) (TokenResponse, error) {
+	if username == "" || password == "" {
+		return TokenResponse{}, newTokenError(errInvalidRequest, "Parameters username and password are required")
+	}
+	attempt, err := startLoginAttempt(loginThrottleKeys(username, client))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	user, err := models.GetUserByUsername(username)
+	if err != nil || !user.ChallengePassword(password) {
+		attempt.failed()
+		return TokenResponse{}, newTokenError(errInvalidGrant, "Invalid username or password")
+	}
+	attempt.succeeded()
+	if !user.IsActive() {
+		return TokenResponse{}, newTokenError(errInvalidGrant, "Email address is not verified yet")
+	}
+
+	return completeLogin(*user, client)
+}
+
+/*
+GetAuthTokenFromForm answers a token request of the password, refresh_token, client_credentials or token exchange
+grant. Errors which aren't a *TokenError are server errors
+*/
+func GetAuthTokenFromForm(form url.Values, client models.SessionClient) (TokenResponse, error) {
+	switch grantType := form.Get("grant_type"); grantType {
+	case grantTypePassword:
+		return getPasswordToken(form.Get("username"), form.Get("password"), client)
+	case grantTypeRefreshToken:
+		return RefreshAuthToken(form.Get("refresh_token"), client)
+	case grantTypeClientCredentials:
+		return GetClientCredentialsToken(form.Get("client_id"), form.Get("client_secret"), form.Get("scope"))
+	case grantTypeMFAOTP:
+		return getMFAToken(form.Get("mfa_token"), form.Get("otp"), client)
+	case grantTypeTokenExchange:
+		return getTokenExchangeToken(form.Get("subject_token"), form.Get("subject_token_type"), form.Get("nonce"), client)
+	case "":
+		return TokenResponse{}, newTokenError(errInvalidRequest, "Parameter grant_type is required")
+	default:
+		return TokenResponse{}, newTokenError(errUnsupportedGrantType, fmt.Sprintf("Grant type %q is not supported", grantType))
+	}
+}
+
+/*
+verifyIDToken verifies idToken with provider and then consumes nonce, which is only spent once the token proved to
+be issued for it
+*/
+func verifyIDToken(provider *OIDCProvider, idToken, nonce string) (*IDTokenClaims, error) {
+	claims, err := provider.Verify(idToken, nonce)
+	if err != nil {
+		return nil, newHiddenTokenError(errInvalidGrant, descInvalidIDToken, err)
+	}
+	if err := models.ConsumeLoginNonce(nonce); err != nil {
+		return nil, newHiddenTokenError(errInvalidGrant, "Invalid or already used nonce", err)
+	}
+	return claims, nil
+}
+
+func identityFromClaims(provider *OIDCProvider, claims *IDTokenClaims) models.Identity {
+	return models.Identity{Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+}
+
+/*
+VerifyIdentity returns the identity an ID token of any configured provider was issued for, so it can be linked to
+the requesting user. Errors are domain errors, as it is used outside of the token endpoint
+*/
+func VerifyIdentity(idToken, nonce string) (models.Identity, error) {
+	provider, err := getOIDCProviderForToken(idToken)
+	if err != nil {
+		return models.Identity{}, models.NewFieldError("idToken", "Invalid ID token")
+	}
+	claims, err := verifyIDToken(provider, idToken, nonce)
+	if err != nil {
+		return models.Identity{}, models.NewFieldError("idToken", "%s", err)
+	}
+	return identityFromClaims(provider, claims), nil
+}
+
+/*
+loginWithIDToken logs in the user linked to the subject of an ID token of provider. Unknown subjects get a new
+user, unless their email belongs to an account already, which has to link the identity explicitly
+*/
+func loginWithIDToken(provider *OIDCProvider, idToken, nonce string, client models.SessionClient) (TokenResponse, error) {
+	claims, err := verifyIDToken(provider, idToken, nonce)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	identity := identityFromClaims(provider, claims)
+	if user, err := models.GetUserByIdentity(identity.Provider, identity.Subject); err == nil {
+		return completeLogin(*user, client)
+	}
+
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return TokenResponse{}, newTokenError(errInvalidGrant, "Email address is not verified by "+provider.Name)
+	}
+	if user, err := models.GetUserByEmail(claims.Email); err == nil {
+		// Users created by Google logins before identities existed get theirs linked on their next login
+		if provider.Name != googleProvider || !user.IsLegacyExternalUser() {
+			return TokenResponse{}, newTokenError(errInvalidGrant, fmt.Sprintf(
+				"An account with this email already exists, log in to it and link your %s identity", provider.Name))
 		}
-	} else {
-		if err = user.Update(req); err != nil {
-			fmt.Println(err)
-			return
+		if err := user.LinkIdentity(identity); err != nil {
+			return TokenResponse{}, err
 		}
+		return completeLogin(*user, client)
 	}
 
-	return getJWT(*user)
+	request, err := userRequestFromIDToken(claims)
+	if err != nil {
+		return TokenResponse{}, newHiddenTokenError(errInvalidGrant, descSignUpFailed, err)
+	}
+	user, err := models.NewUser(request)
+	if err != nil {
+		return TokenResponse{}, newHiddenTokenError(errInvalidGrant, descSignUpFailed, err)
+	}
+	identity.Linked = time.Now()
+	user.Identities = []models.Identity{identity}
+	if err := user.Save(); err != nil {
+		return TokenResponse{}, newHiddenTokenError(errInvalidGrant, descSignUpFailed, err)
+	}
+	return completeLogin(*user, client)
+}
+
+// getTokenExchangeToken answers an RFC 8693 token exchange of an ID token of a configured provider
+func getTokenExchangeToken(subjectToken, subjectTokenType, nonce string, client models.SessionClient) (TokenResponse, error) {
+	if subjectToken == "" || subjectTokenType != tokenTypeIDToken {
+		return TokenResponse{}, newTokenError(errInvalidRequest, "Parameter subject_token must be an ID token")
+	}
+	provider, err := getOIDCProviderForToken(subjectToken)
+	if err != nil {
+		return TokenResponse{}, newHiddenTokenError(errInvalidGrant, descInvalidIDToken, err)
+	}
+	token, err := loginWithIDToken(provider, subjectToken, nonce, client)
+	token.IssuedTokenType = tokenTypeAccessToken
+	return token, err
 }
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestIsRevokedForSparesPersonalAccessTokens(t *testing.T) {
+	requireDatabase(t)
+	issuedAt := time.Now().Add(-time.Hour).Unix()
+	user := models.User{}
+	user.SetId(bson.NewObjectId())
+	user.RevokeTokens()
+
+	tests := []struct {
+		name   string
+		claims TokenClaims
+		want   bool
+	}{
+		{name: "login", claims: TokenClaims{SessionID: "session"}, want: true},
+		{name: "client credentials", claims: TokenClaims{ClientID: "client"}, want: true},
+		{name: "personal access token", claims: TokenClaims{PersonalTokenID: "token"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.claims.StandardClaims = jwt.StandardClaims{Id: newJTI(), IssuedAt: issuedAt}
+			if revoked := test.claims.IsRevokedFor(user); revoked != test.want {
+				t.Errorf("IsRevokedFor() of a token issued before the user revoked theirs returned %v, want %v", revoked,
+					test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	verificationPurpose  = "verify-email"
+	verificationTokenTTL = 48 * time.Hour
+	downloadPurpose      = "download-export"
+	resendLimit          = 3
+	resendWindow         = time.Hour
+)
+
+var errInvalidVerificationToken = models.NewFieldError("token", "Invalid or expired verification token")
+
+// signMessage returns the signature of message, purpose keeps tokens of a kind from being used as another
+func signMessage(purpose, message string) string {
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte(purpose + "|" + message))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSignedToken returns a token holding fields, which can't contain "|", and signed for purpose
+func newSignedToken(purpose string, fields ...string) string {
+	message := strings.Join(fields, "|")
+	payload := base64.RawURLEncoding.EncodeToString([]byte(message))
+	return payload + "." + signMessage(purpose, message)
+}
+
+// parseSignedToken returns the n fields of a token issued by newSignedToken for purpose
+func parseSignedToken(purpose, token string, n int) ([]string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+	message := string(decoded)
+	if !hmac.Equal([]byte(parts[1]), []byte(signMessage(purpose, message))) {
+		return nil, false
+	}
+	fields := strings.SplitN(message, "|", n)
+	return fields, len(fields) == n
+}
+
+// isExpired returns whether the unix time field of a signed token is in the past
+func isExpired(field string) bool {
+	expires, err := strconv.ParseInt(field, 10, 64)
+	return err != nil || time.Now().Unix() > expires
+}
+
+/*
+NewEmailVerificationToken returns a signed token proving ownership of the user's current email. It can be used
+once, expires after 48 hours and stops being valid if the email changes or a newer token is issued
+*/
+func NewEmailVerificationToken(user models.User) (string, error) {
+	nonce := newJTI()
+	if err := user.SetVerificationNonce(nonce); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(verificationTokenTTL).Unix(), 10)
+	return newSignedToken(verificationPurpose, user.GetId().Hex(), expires, nonce, user.Email), nil
+}
+
+// VerifyEmail checks a token issued by NewEmailVerificationToken and activates its user
+func VerifyEmail(token string) (*models.User, error) {
+	fields, ok := parseSignedToken(verificationPurpose, token, 4)
+	if !ok || isExpired(fields[1]) || !bson.IsObjectIdHex(fields[0]) {
+		return nil, errInvalidVerificationToken
+	}
+	user, err := models.ActivateVerifiedUser(bson.ObjectIdHex(fields[0]), fields[3], fields[2])
+	if err == mgo.ErrNotFound {
+		return nil, errInvalidVerificationToken
+	}
+	return user, err
+}
+
+/*
+AllowVerificationResend counts a request for another verification email of user and returns whether it is within
+the few allowed every hour, so nobody can flood an inbox
+*/
+func AllowVerificationResend(user models.User) bool {
+	wait, err := throttleRequest("resend:"+user.GetId().Hex(), resendLimit, resendWindow)
+	if err != nil {
+		log.Println("Could not count verification email request", err)
+		return false
+	}
+	return wait == 0
+}
+
+// NewDownloadToken returns a signed token which allows downloading the data export exportID until expires
+func NewDownloadToken(exportID string, expires time.Time) string {
+	return newSignedToken(downloadPurpose, exportID, strconv.FormatInt(expires.Unix(), 10))
+}
+
+// VerifyDownloadToken checks a token issued by NewDownloadToken for exportID
+func VerifyDownloadToken(exportID, token string) bool {
+	fields, ok := parseSignedToken(downloadPurpose, token, 2)
+	return ok && fields[0] == exportID && !isExpired(fields[1])
+}
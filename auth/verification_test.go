@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestVerifyEmailIsSingleUse(t *testing.T) {
+	requireDatabase(t)
+	username := "test" + bson.NewObjectId().Hex()
+	password := "correct horse battery"
+	user, err := models.NewUser(models.UserRequest{
+		Username: username, Password: &password, Email: username + "@example.com", FirstName: "Test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Save(); err != nil {
+		t.Fatal(err)
+	}
+	older, err := NewEmailVerificationToken(*user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := NewEmailVerificationToken(*user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyEmail(older); err != errInvalidVerificationToken {
+		t.Fatalf("VerifyEmail() of a token a newer one replaced returned %v, want errInvalidVerificationToken", err)
+	}
+	verified, err := VerifyEmail(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.GetId() != user.GetId() || !verified.IsActive() || verified.VerificationNonce != "" {
+		t.Errorf("VerifyEmail() returned %+v, want the user activated without a verification nonce", verified)
+	}
+	if _, err := VerifyEmail(token); err != errInvalidVerificationToken {
+		t.Fatalf("VerifyEmail() of a used token returned %v, want errInvalidVerificationToken", err)
+	}
+}
+
+func TestVerifyEmailRefusesForgedTokens(t *testing.T) {
+	id := bson.NewObjectId().Hex()
+	tests := map[string]string{
+		"malformed":     "token",
+		"other purpose": newSignedToken(downloadPurpose, id, "9999999999", "nonce", "someone@example.com"),
+		"expired":       newSignedToken(verificationPurpose, id, "1", "nonce", "someone@example.com"),
+		"not a user id": newSignedToken(verificationPurpose, "someone", "9999999999", "nonce", "someone@example.com"),
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := VerifyEmail(token); err != errInvalidVerificationToken {
+				t.Fatalf("VerifyEmail() returned %v, want errInvalidVerificationToken", err)
+			}
+		})
+	}
+}
+
+func TestAllowVerificationResend(t *testing.T) {
+	defer useMemoryThrottleStore()()
+	user, other := models.User{}, models.User{}
+	user.SetId(bson.NewObjectId())
+	other.SetId(bson.NewObjectId())
+
+	for i := 0; i < resendLimit; i++ {
+		if !AllowVerificationResend(user) {
+			t.Fatalf("AllowVerificationResend() refused request %d, want %d allowed", i+1, resendLimit)
+		}
+	}
+	if AllowVerificationResend(user) {
+		t.Errorf("AllowVerificationResend() allowed request %d, want at most %d", resendLimit+1, resendLimit)
+	}
+	if !AllowVerificationResend(other) {
+		t.Error("AllowVerificationResend() refused another user")
+	}
+}
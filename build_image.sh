@@ -2,6 +2,6 @@ export CGO_ENABLED=0
 export GOOS=linux
 export MAGICBOX_VERSION=$(cat version.txt)
 
-go build -a -installsuffix cgo -o magicbox .
+go build -a -installsuffix cgo -ldflags "-X main.version=$MAGICBOX_VERSION" -o magicbox .
 docker build -t jenarvaezg/magicbox:$MAGICBOX_VERSION .
 
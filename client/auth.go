@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	loginRoute        = "/login"
+	grantTypeMFAOTP   = "urn:magicbox:params:oauth:grant-type:mfa-otp"
+	grantTypePassword = "password"
+)
+
+// ErrNoRefreshToken is returned when refreshing a token which can't be refreshed, like one of an API client
+var ErrNoRefreshToken = errors.New("magicbox: token has no refresh token")
+
+// Token is an access token issued by the API, with the refresh token to renew it once it expires
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// Expiry is when AccessToken expires, computed when the token is received
+	Expiry time.Time `json:"-"`
+}
+
+// expired returns whether t has expired, or is about to
+func (t *Token) expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(tokenRefreshMargin).After(t.Expiry)
+}
+
+// Token returns the token requests are authenticated with, nil before logging in
+func (c *Client) Token() *Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken authenticates the next requests with token, like a personal access token saved elsewhere
+func (c *Client) SetToken(token *Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+/*
+authorization returns the Authorization header of a request, refreshing the token first if it has expired. Refresh
+tokens are single use, so concurrent requests wait for the one refreshing it instead of refreshing it again
+*/
+func (c *Client) authorization(ctx context.Context) (string, error) {
+	token := c.Token()
+	if token == nil {
+		return "", nil
+	}
+	if token.expired() && token.RefreshToken != "" {
+		c.refreshMu.Lock()
+		defer c.refreshMu.Unlock()
+		if token = c.Token(); token != nil && token.expired() {
+			var err error
+			if token, err = c.Refresh(ctx); err != nil {
+				return "", err
+			}
+		}
+	}
+	if token == nil {
+		return "", nil
+	}
+	return "Bearer " + token.AccessToken, nil
+}
+
+// requestToken asks the token endpoint at path for a token with form, which authenticates the next requests
+func (c *Client) requestToken(ctx context.Context, path string, form url.Values) (*Token, error) {
+	token := &Token{}
+	r := request{method: http.MethodPost, path: path, body: form, public: true}
+	if _, err := c.send(ctx, r, token); err != nil {
+		return nil, err
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	c.SetToken(token)
+	return token, nil
+}
+
+/*
+Login logs a user in with their password. Users with two-factor authentication get a *TokenError with the code
+TokenErrorMFARequired, whose MFAToken must be sent to LoginMFA along with a code of their authenticator app
+*/
+func (c *Client) Login(ctx context.Context, username, password string) (*Token, error) {
+	return c.requestToken(ctx, loginRoute, url.Values{
+		"grant_type": {grantTypePassword},
+		"username":   {username},
+		"password":   {password},
+	})
+}
+
+// LoginMFA completes the login of a user with two-factor authentication, code may also be a recovery code
+func (c *Client) LoginMFA(ctx context.Context, mfaToken, code string) (*Token, error) {
+	return c.requestToken(ctx, loginRoute, url.Values{
+		"grant_type": {grantTypeMFAOTP},
+		"mfa_token":  {mfaToken},
+		"otp":        {code},
+	})
+}
+
+// LoginClient logs an API client in with its credentials, its token acts on behalf of its owner with scope
+func (c *Client) LoginClient(ctx context.Context, clientID, clientSecret, scope string) (*Token, error) {
+	return c.requestToken(ctx, loginRoute, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"scope":         {scope},
+	})
+}
+
+// Refresh exchanges the refresh token of the current token for new tokens, it is done on its own when it expires
+func (c *Client) Refresh(ctx context.Context) (*Token, error) {
+	token := c.Token()
+	if token == nil || token.RefreshToken == "" {
+		return nil, ErrNoRefreshToken
+	}
+	return c.requestToken(ctx, loginRoute+"/refresh", url.Values{"refresh_token": {token.RefreshToken}})
+}
+
+// Logout ends the session of the current token, which is forgotten
+func (c *Client) Logout(ctx context.Context) error {
+	if _, err := c.post(ctx, "/logout", nil, nil); err != nil {
+		return err
+	}
+	c.SetToken(nil)
+	return nil
+}
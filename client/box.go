@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+const boxRoute = apiRoute + "/box"
+
+// Statuses of a box, its notes can only be read once it is open
+const (
+	BoxOpen   = "open"
+	BoxClosed = "closed"
+)
+
+// Box is a box as answered by the API
+type Box struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	OpenDate      time.Time `json:"openDate"`
+	NumberOfNotes int       `json:"numberOfNotes"`
+	Registered    bool      `json:"registered"`
+	HasPassphrase bool      `json:"hasPassphrase"`
+	Owner         string    `json:"owner"`
+	ReminderHours []int     `json:"reminderHours"`
+}
+
+// BoxRequest is the body of a request creating a box
+type BoxRequest struct {
+	Name          string    `json:"name"`
+	OpenDate      time.Time `json:"openDate"`
+	Passphrase    *string   `json:"passphrase,omitempty"`
+	ReminderHours []int     `json:"reminderHours,omitempty"`
+}
+
+func boxPath(id string) string {
+	return boxRoute + "/" + escape(id)
+}
+
+// ListBoxes returns an iterator of the boxes the current user is a member of
+func (c *Client) ListBoxes(ctx context.Context) *BoxIterator {
+	return &BoxIterator{pager: c.newPager(ctx, boxRoute, nil)}
+}
+
+// GetBox returns the box with the given id
+func (c *Client) GetBox(ctx context.Context, id string) (*Box, error) {
+	box := &Box{}
+	if err := c.get(ctx, boxPath(id), box); err != nil {
+		return nil, err
+	}
+	return box, nil
+}
+
+// CreateBox creates a box owned by the current user, and returns its id
+func (c *Client) CreateBox(ctx context.Context, request BoxRequest) (string, error) {
+	header, err := c.post(ctx, boxRoute, request, nil)
+	if err != nil {
+		return "", err
+	}
+	return createdID(header)
+}
+
+/*
+PatchBox updates the fields of a box in patch, a nil passphrase removes it and nil reminder hours disable the
+reminders. The open date can't be changed once the box has notes
+*/
+func (c *Client) PatchBox(ctx context.Context, id string, patch Patch) error {
+	return c.patch(ctx, boxPath(id), patch)
+}
+
+// DeleteBox deletes a box along with its notes
+func (c *Client) DeleteBox(ctx context.Context, id string) error {
+	return c.delete(ctx, boxPath(id), nil, nil)
+}
+
+// Register makes the current user a member of a box whose passphrase is passphrase
+func (c *Client) Register(ctx context.Context, boxID, passphrase string) error {
+	body := struct {
+		Passphrase string `json:"passphrase"`
+	}{passphrase}
+	_, err := c.post(ctx, boxPath(boxID)+"/register", body, nil)
+	return err
+}
+
+// Unregister makes the current user leave a box
+func (c *Client) Unregister(ctx context.Context, boxID string) error {
+	return c.delete(ctx, boxPath(boxID)+"/register", nil, nil)
+}
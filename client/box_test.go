@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+// openBox opens a box right away, as the job opening boxes does once their open date passes
+func openBox(t *testing.T, id string) {
+	box, err := models.GetBoxByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	box.OpenDate = time.Now().Add(-time.Second)
+	if err := box.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoxes(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	c, user := newTestClient(t, server, false)
+	openDate := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	id, err := c.CreateBox(ctx, BoxRequest{Name: "Birthday", OpenDate: openDate, ReminderHours: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := c.GetBox(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if box.ID != id || box.Name != "Birthday" || box.Status != BoxClosed || !box.OpenDate.Equal(openDate) {
+		t.Errorf("GetBox() returned %+v, want the box just created", box)
+	}
+	if box.Owner != user.GetId().Hex() || !box.Registered || box.HasPassphrase {
+		t.Errorf("GetBox() returned %+v, want a box owned by its creator without passphrase", box)
+	}
+
+	if err := c.PatchBox(ctx, id, Patch{"name": "Anniversary", "reminderHours": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if box, err = c.GetBox(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if box.Name != "Anniversary" || len(box.ReminderHours) != 0 {
+		t.Errorf("GetBox() returned %+v after patching it, want it renamed and without reminders", box)
+	}
+
+	if err := c.DeleteBox(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBox(ctx, id); !IsNotFound(err) {
+		t.Fatalf("GetBox() of a deleted box returned %v, want a not found *Error", err)
+	}
+}
+
+func TestListBoxes(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	c, _ := newTestClient(t, server, false)
+
+	it := c.ListBoxes(ctx)
+	if it.Next() {
+		t.Fatalf("ListBoxes() of a new user returned %+v", it.Box())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	created := make(map[string]bool)
+	for _, name := range []string{"First", "Second", "Third"} {
+		id, err := c.CreateBox(ctx, BoxRequest{Name: name, OpenDate: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created[id] = true
+	}
+	it = c.ListBoxes(ctx)
+	for it.Next() {
+		if !created[it.Box().ID] {
+			t.Errorf("ListBoxes() returned %+v, which isn't a box of the user or was returned twice", it.Box())
+		}
+		delete(created, it.Box().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) > 0 {
+		t.Errorf("ListBoxes() left out the boxes %v", created)
+	}
+}
+
+func TestNotes(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	owner, _ := newTestClient(t, server, false)
+	member, memberUser := newTestClient(t, server, false)
+	passphrase := "secret"
+	request := BoxRequest{Name: "Notes", OpenDate: time.Now().Add(time.Hour), Passphrase: &passphrase}
+	id, err := owner.CreateBox(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := member.CreateNote(ctx, id, NoteRequest{Title: "Hello"}); !IsKind(err, KindForbidden) {
+		t.Fatalf("CreateNote() of a user who isn't a member returned %v, want a forbidden *Error", err)
+	}
+	if err := member.Register(ctx, id, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := member.CreateNote(ctx, id, NoteRequest{Title: "Hello", Detail: "From a member"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.CreateNote(ctx, id, NoteRequest{Anonymous: true, Title: "Guess", Detail: "Who"}); err != nil {
+		t.Fatal(err)
+	}
+	openBox(t, id)
+
+	notes := make(map[string]Note)
+	it := member.ListNotes(ctx, id)
+	for it.Next() {
+		notes[it.Note().Title] = it.Note()
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes["Hello"].From != memberUser.GetId().Hex() || notes["Guess"].From != "" {
+		t.Errorf("ListNotes() returned %+v, want the note of %s and an anonymous one", notes, memberUser.GetId().Hex())
+	}
+
+	if err := owner.DeleteNotes(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if it = owner.ListNotes(ctx, id); it.Next() {
+		t.Errorf("ListNotes() returned %+v after deleting the notes", it.Note())
+	}
+	if err := member.Unregister(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if it = member.ListNotes(ctx, id); it.Next() || !IsKind(it.Err(), KindForbidden) {
+		t.Fatalf("ListNotes() of a user who left the box returned %v, want a forbidden *Error", it.Err())
+	}
+}
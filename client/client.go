@@ -0,0 +1,278 @@
+/*
+Package client is a Go client of the MagicBox API. It declares its own types instead of importing models, so it
+does not depend on the server and its database. Requests which fail with a network error or an unavailable server are
+retried with exponential backoff, and errors answered by the API are returned as an *Error or a *TokenError.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiRoute             = "/api/v1"
+	jsonContentType      = "application/json"
+	mergePatchType       = "application/merge-patch+json"
+	formContentType      = "application/x-www-form-urlencoded"
+	userAgent            = "magicbox-go-client"
+	tokenRefreshMargin   = 30 * time.Second
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 500 * time.Millisecond
+	defaultMaxRetryDelay = 30 * time.Second
+)
+
+// Client makes requests to a MagicBox server, it is safe for concurrent use
+type Client struct {
+	// BaseURL is the root URL of the server, such as https://magicbox.example.com
+	BaseURL    string
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed request is retried, zero disables retries
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// MaxRetryDelay caps the backoff, requests the server asks to retry later than it fail right away
+	MaxRetryDelay time.Duration
+
+	mu        sync.Mutex
+	token     *Token
+	refreshMu sync.Mutex
+}
+
+// New returns a Client of the server at baseURL, it has to log in or be given a token for most requests
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		HTTPClient:    http.DefaultClient,
+		MaxRetries:    defaultMaxRetries,
+		RetryBackoff:  defaultRetryBackoff,
+		MaxRetryDelay: defaultMaxRetryDelay,
+	}
+}
+
+// request is a request to the API, its body is sent as JSON unless it is url.Values
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        interface{}
+	contentType string
+	// public requests are sent without the access token, like those to the token endpoint
+	public bool
+}
+
+func (r request) encodeBody() ([]byte, string, error) {
+	if form, ok := r.body.(url.Values); ok {
+		return []byte(form.Encode()), formContentType, nil
+	}
+	contentType := r.contentType
+	if contentType == "" {
+		contentType = jsonContentType
+	}
+	if r.body == nil {
+		// The API requires a JSON content type on every POST and PUT, even those without a body
+		return nil, contentType, nil
+	}
+	payload, err := json.Marshal(r.body)
+	return payload, contentType, err
+}
+
+// isTokenRequest tells whether r asks the API for a token
+func (r request) isTokenRequest() bool {
+	return strings.HasPrefix(r.path, loginRoute)
+}
+
+func (c *Client) get(ctx context.Context, path string, result interface{}) error {
+	_, err := c.send(ctx, request{method: http.MethodGet, path: path}, result)
+	return err
+}
+
+func (c *Client) post(ctx context.Context, path string, body, result interface{}) (http.Header, error) {
+	return c.send(ctx, request{method: http.MethodPost, path: path, body: body}, result)
+}
+
+func (c *Client) patch(ctx context.Context, path string, patch Patch) error {
+	_, err := c.send(ctx, request{method: http.MethodPatch, path: path, body: patch, contentType: mergePatchType}, nil)
+	return err
+}
+
+func (c *Client) delete(ctx context.Context, path string, query url.Values, result interface{}) error {
+	_, err := c.send(ctx, request{method: http.MethodDelete, path: path, query: query}, result)
+	return err
+}
+
+/*
+send makes r, retrying it as long as it fails in a way that may be temporary, and decodes the JSON body of the
+response into result. It returns the headers of the response, or the error the API answered with
+*/
+func (c *Client) send(ctx context.Context, r request, result interface{}) (http.Header, error) {
+	payload, contentType, err := r.encodeBody()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, r, payload, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Header, readResponse(resp, result, r.isTokenRequest())
+}
+
+// do makes r with payload until it gets a response which shouldn't be retried, or runs out of retries
+func (c *Client) do(ctx context.Context, r request, payload []byte, contentType string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := c.newRequest(ctx, r, payload, contentType)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if attempt >= c.MaxRetries || !isIdempotent(r.method) {
+				return nil, err
+			}
+			if err := sleep(ctx, c.backoff(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		delay, retry := c.retryDelay(r, resp, attempt)
+		if !retry {
+			return resp, nil
+		}
+		drain(resp.Body)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+newRequest builds an attempt at r. The authorization is taken again for every attempt, as the token may have expired
+or been refreshed while waiting to retry
+*/
+func (c *Client) newRequest(ctx context.Context, r request, payload []byte, contentType string) (*http.Request, error) {
+	target := c.BaseURL + r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+	req, err := http.NewRequest(r.method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	req.Header.Set("User-Agent", userAgent)
+	if payload != nil || r.method == http.MethodPost || r.method == http.MethodPut {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if !r.public {
+		authorization, err := c.authorization(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+	return req, nil
+}
+
+// readResponse decodes the body of resp into result, or the error it carries
+func readResponse(resp *http.Response, result interface{}, tokenRequest bool) error {
+	defer drain(resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp, tokenRequest)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// retryDelay returns how long to wait before retrying a request answered with resp, if it should be retried at all
+func (c *Client) retryDelay(r request, resp *http.Response, attempt int) (time.Duration, bool) {
+	if attempt >= c.MaxRetries {
+		return 0, false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		/*
+			Retrying a throttled login only feeds the throttle, and the server may count a refused POST towards its
+			limits all the same, so only requests which are safe to repeat are retried
+		*/
+		if r.isTokenRequest() || !isIdempotent(r.method) {
+			return 0, false
+		}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if !isIdempotent(r.method) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	delay := c.backoff(attempt)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	}
+	return delay, delay <= c.MaxRetryDelay
+}
+
+// backoff doubles the delay of every attempt, with some jitter so clients which failed together don't retry together
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.RetryBackoff << uint(attempt)
+	if delay <= 0 || delay > c.MaxRetryDelay {
+		delay = c.MaxRetryDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func isIdempotent(method string) bool {
+	return method != http.MethodPost && method != http.MethodPatch
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain reads what is left of body before closing it, so its connection can be reused
+func drain(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
+
+// errNoLocation is returned when the server doesn't tell where a created resource is
+var errNoLocation = errors.New("magicbox: response has no Location header")
+
+// createdID returns the id of the resource created by a request answered with header
+func createdID(header http.Header) (string, error) {
+	location := header.Get("Location")
+	if location == "" {
+		return "", errNoLocation
+	}
+	return location[strings.LastIndex(location, "/")+1:], nil
+}
+
+// Patch is a JSON merge patch, fields set to nil are removed or reset to their default
+type Patch map[string]interface{}
+
+func escape(id string) string {
+	return url.PathEscape(id)
+}
@@ -0,0 +1,352 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/server"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const testPassword = "correct horse battery"
+
+var connectOnce sync.Once
+
+/*
+newTestServer serves the router of the server, through wrap if it isn't nil. The test is skipped when MONGO_URL
+doesn't point to a database
+*/
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	if os.Getenv("MONGO_URL") == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	connectOnce.Do(models.Connect)
+	handler, err := server.NewRouter("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+// newTestUser signs up a user with a unique username and verifies their email
+func newTestUser(t *testing.T, admin bool) *models.User {
+	username := "test" + bson.NewObjectId().Hex()
+	password := testPassword
+	user, err := models.NewUser(models.UserRequest{
+		Username:  username,
+		Password:  &password,
+		Email:     username + "@example.com",
+		FirstName: "Test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if admin {
+		if err := user.SetAdmin(true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// newTestClient returns a client of server logged in as a new user, which retries right away
+func newTestClient(t *testing.T, server *httptest.Server, admin bool) (*Client, *models.User) {
+	user := newTestUser(t, admin)
+	c := New(server.URL)
+	c.RetryBackoff = time.Millisecond
+	if _, err := c.Login(context.Background(), user.Username, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	return c, user
+}
+
+func TestLoginAndRefresh(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	user := newTestUser(t, false)
+	c := New(server.URL)
+
+	token, err := c.Login(ctx, user.Username, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" || token.Expiry.Before(time.Now()) {
+		t.Fatalf("Login() returned %+v, want an access and a refresh token which haven't expired", token)
+	}
+	if c.Token() != token {
+		t.Fatal("Login() didn't authenticate the next requests with its token")
+	}
+
+	refreshed, err := c.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.AccessToken == token.AccessToken || refreshed.RefreshToken == token.RefreshToken {
+		t.Fatal("Refresh() returned the tokens it refreshed")
+	}
+
+	// A token about to expire is refreshed before the request it would authenticate
+	expiring := *refreshed
+	expiring.Expiry = time.Now()
+	c.SetToken(&expiring)
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token().AccessToken == expiring.AccessToken {
+		t.Fatal("GetUser() was sent with an expiring token instead of refreshing it")
+	}
+
+	// Refresh tokens are single use, reusing one revokes the session
+	reused := New(server.URL)
+	reused.SetToken(token)
+	_, err = reused.Refresh(ctx)
+	if tokenErr, ok := err.(*TokenError); !ok || tokenErr.Code != "invalid_grant" {
+		t.Fatalf("Refresh() with a used refresh token returned %v, want an invalid_grant *TokenError", err)
+	}
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); !IsKind(err, KindUnauthorized) {
+		t.Fatalf("GetUser() of a revoked session returned %v, want an unauthorized *Error", err)
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	user := newTestUser(t, false)
+	c := New(server.URL)
+
+	_, err := c.Login(ctx, user.Username, "wrong password")
+	if tokenErr, ok := err.(*TokenError); !ok || tokenErr.Code != "invalid_grant" {
+		t.Fatalf("Login() with a wrong password returned %v, want an invalid_grant *TokenError", err)
+	}
+	if c.Token() != nil {
+		t.Fatal("Login() kept the token of a failed login")
+	}
+	if _, err := c.Refresh(ctx); err != ErrNoRefreshToken {
+		t.Fatalf("Refresh() without a token returned %v, want ErrNoRefreshToken", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	c, user := newTestClient(t, server, false)
+	token := c.Token()
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token() != nil {
+		t.Fatal("Logout() kept the token")
+	}
+	c.SetToken(token)
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); !IsKind(err, KindUnauthorized) {
+		t.Fatalf("GetUser() after logging out returned %v, want an unauthorized *Error", err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	c, _ := newTestClient(t, server, false)
+	passphrase := "secret"
+	request := BoxRequest{Name: "Errors", OpenDate: time.Now().Add(time.Hour), Passphrase: &passphrase}
+	boxID, err := c.CreateBox(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		do   func() error
+		kind string
+	}{
+		{name: "without token", kind: KindUnauthorized, do: func() error {
+			_, err := New(server.URL).GetBox(ctx, boxID)
+			return err
+		}},
+		{name: "box which doesn't exist", kind: KindNotFound, do: func() error {
+			_, err := c.GetBox(ctx, bson.NewObjectId().Hex())
+			return err
+		}},
+		{name: "notes of a closed box", kind: KindForbidden, do: func() error {
+			it := c.ListNotes(ctx, boxID)
+			for it.Next() {
+			}
+			return it.Err()
+		}},
+		{name: "wrong passphrase", kind: KindBadRequest, do: func() error {
+			other, _ := newTestClient(t, server, false)
+			return other.Register(ctx, boxID, "wrong passphrase")
+		}},
+		{name: "invalid box", kind: KindValidation, do: func() error {
+			_, err := c.CreateBox(ctx, BoxRequest{OpenDate: time.Now().Add(time.Hour)})
+			return err
+		}},
+		{name: "patch of an unknown field", kind: KindValidation, do: func() error {
+			return c.PatchBox(ctx, boxID, Patch{"owner": "someone"})
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.do()
+			apiErr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("returned %v, want an *Error", err)
+			}
+			if apiErr.Kind() != test.kind {
+				t.Fatalf("returned an error of kind %q, want %q: %v", apiErr.Kind(), test.kind, err)
+			}
+			if apiErr.RequestID == "" {
+				t.Error("the error has no request id")
+			}
+		})
+	}
+}
+
+func TestValidationErrorFields(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	c, _ := newTestClient(t, server, false)
+
+	_, err := c.CreateBox(context.Background(), BoxRequest{OpenDate: time.Now().Add(time.Hour)})
+	apiErr, ok := err.(*Error)
+	if !ok || len(apiErr.Fields) == 0 {
+		t.Fatalf("CreateBox() without a name returned %v, want an *Error about its fields", err)
+	}
+	if apiErr.Fields[0].Field != "name" {
+		t.Errorf("CreateBox() without a name returned an error about %q, want name", apiErr.Fields[0].Field)
+	}
+}
+
+// flakyServer answers requests with the statuses queued for their method and path before handing them to the API
+type flakyServer struct {
+	next     http.Handler
+	mu       sync.Mutex
+	statuses map[string][]int
+	requests map[string]int
+	// failed is called after answering with a queued status, if it isn't nil
+	failed func()
+}
+
+func newFlakyServer(next http.Handler) *flakyServer {
+	return &flakyServer{next: next, statuses: make(map[string][]int), requests: make(map[string]int)}
+}
+
+// fail queues statuses for the next requests of method to path
+func (f *flakyServer) fail(method, path string, statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses[method+" "+path] = statuses
+}
+
+// sent returns how many requests of method to path were received
+func (f *flakyServer) sent(method, path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[method+" "+path]
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.Path
+	f.mu.Lock()
+	f.requests[key]++
+	statuses := f.statuses[key]
+	if len(statuses) > 0 {
+		f.statuses[key] = statuses[1:]
+	}
+	failed := f.failed
+	f.mu.Unlock()
+
+	if len(statuses) > 0 {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, http.StatusText(statuses[0]), statuses[0])
+		if failed != nil {
+			failed()
+		}
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
+
+func TestRetries(t *testing.T) {
+	var flaky *flakyServer
+	server := newTestServer(t, func(next http.Handler) http.Handler {
+		flaky = newFlakyServer(next)
+		return flaky
+	})
+	defer server.Close()
+	ctx := context.Background()
+	c, user := newTestClient(t, server, false)
+	path := userPath(user.GetId().Hex())
+
+	flaky.fail(http.MethodGet, path, http.StatusServiceUnavailable, http.StatusBadGateway)
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); err != nil {
+		t.Fatalf("GetUser() returned %v, want it to succeed once the server is available", err)
+	}
+	if sent := flaky.sent(http.MethodGet, path); sent != 3 {
+		t.Errorf("GetUser() was sent %d times, want 3", sent)
+	}
+
+	// Requests which may have been done aren't retried unless the server refused them
+	flaky.fail(http.MethodPost, boxRoute, http.StatusServiceUnavailable)
+	_, err := c.CreateBox(ctx, BoxRequest{Name: "Retries", OpenDate: time.Now().Add(time.Hour)})
+	if !IsKind(err, KindInternal) {
+		t.Fatalf("CreateBox() answered with 503 returned %v, want an internal *Error", err)
+	}
+	flaky.fail(http.MethodPost, boxRoute, http.StatusTooManyRequests)
+	_, err = c.CreateBox(ctx, BoxRequest{Name: "Retries", OpenDate: time.Now().Add(time.Hour)})
+	if !IsKind(err, KindTooManyRequests) {
+		t.Fatalf("CreateBox() answered with 429 returned %v, want a too-many-requests *Error", err)
+	}
+	if sent := flaky.sent(http.MethodPost, boxRoute); sent != 2 {
+		t.Errorf("CreateBox() was sent %d times in all, want 2 as neither was retried", sent)
+	}
+	flaky.fail(http.MethodPost, loginRoute, http.StatusTooManyRequests)
+	if _, err := c.Login(ctx, user.Username, testPassword); !IsKind(err, KindTooManyRequests) {
+		t.Fatalf("Login() answered with 429 returned %v, want a too-many-requests *Error", err)
+	}
+	if sent := flaky.sent(http.MethodPost, loginRoute); sent != 1 {
+		t.Errorf("Login() was sent %d times, want 1", sent)
+	}
+	flaky.fail(http.MethodGet, path, http.StatusTooManyRequests)
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); err != nil {
+		t.Fatalf("GetUser() returned %v, want it to be retried after being rate limited", err)
+	}
+	if sent := flaky.sent(http.MethodGet, path); sent != 5 {
+		t.Errorf("GetUser() was sent %d times in all, want 5", sent)
+	}
+
+	statuses := make([]int, c.MaxRetries+1)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	flaky.fail(http.MethodGet, path, statuses...)
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); !IsKind(err, KindInternal) {
+		t.Fatalf("GetUser() returned %v once out of retries, want an internal *Error", err)
+	}
+
+	// Every attempt is authenticated with the token the client has then
+	flaky.mu.Lock()
+	flaky.failed = func() { c.SetToken(&Token{AccessToken: "replaced", TokenType: "Bearer"}) }
+	flaky.mu.Unlock()
+	flaky.fail(http.MethodGet, path, http.StatusServiceUnavailable)
+	if _, err := c.GetUser(ctx, user.GetId().Hex()); !IsKind(err, KindUnauthorized) {
+		t.Fatalf("GetUser() returned %v after the token was replaced, want an unauthorized *Error", err)
+	}
+}
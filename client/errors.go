@@ -0,0 +1,129 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const problemTypeBase = "urn:magicbox:problem:"
+
+// Kinds of the errors the API answers with, the last part of the type of their problem
+const (
+	KindBadRequest           = "bad-request"
+	KindUnauthorized         = "unauthorized"
+	KindForbidden            = "forbidden"
+	KindNotFound             = "not-found"
+	KindConflict             = "conflict"
+	KindValidation           = "validation-error"
+	KindTooLarge             = "payload-too-large"
+	KindUnsupportedMediaType = "unsupported-media-type"
+	KindTooManyRequests      = "too-many-requests"
+	KindInternal             = "internal-error"
+)
+
+var statusKinds = map[int]string{
+	http.StatusBadRequest:            KindBadRequest,
+	http.StatusUnauthorized:          KindUnauthorized,
+	http.StatusForbidden:             KindForbidden,
+	http.StatusNotFound:              KindNotFound,
+	http.StatusConflict:              KindConflict,
+	http.StatusUnprocessableEntity:   KindValidation,
+	http.StatusRequestEntityTooLarge: KindTooLarge,
+	http.StatusUnsupportedMediaType:  KindUnsupportedMediaType,
+	http.StatusTooManyRequests:       KindTooManyRequests,
+}
+
+// FieldError tells why a field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error answered by the API, decoded from its RFC 7807 problem details
+type Error struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	StatusCode int          `json:"status"`
+	Detail     string       `json:"detail"`
+	RequestID  string       `json:"requestId"`
+	Fields     []FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("magicbox: %d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	return message
+}
+
+// Kind returns the kind of e, responses which aren't a problem get the kind of their status
+func (e *Error) Kind() string {
+	if strings.HasPrefix(e.Type, problemTypeBase) {
+		return strings.TrimPrefix(e.Type, problemTypeBase)
+	}
+	if kind, ok := statusKinds[e.StatusCode]; ok {
+		return kind
+	}
+	if e.StatusCode >= http.StatusInternalServerError {
+		return KindInternal
+	}
+	return KindBadRequest
+}
+
+// TokenErrorMFARequired is the code of the error of a login which needs a second factor, see Client.LoginMFA
+const TokenErrorMFARequired = "mfa_required"
+
+// TokenError is an RFC 6749 error answered by the token endpoint
+type TokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	// MFAToken is set when Code is TokenErrorMFARequired
+	MFAToken   string `json:"mfa_token"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description == "" {
+		return "magicbox: " + e.Code
+	}
+	return fmt.Sprintf("magicbox: %s: %s", e.Code, e.Description)
+}
+
+// IsKind returns whether err is an error answered by the API of the given kind
+func IsKind(err error, kind string) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Kind() == kind
+}
+
+// IsNotFound returns whether err was answered because the resource requested doesn't exist
+func IsNotFound(err error) bool {
+	return IsKind(err, KindNotFound)
+}
+
+// decodeError decodes the error of resp, the token endpoint answers a TokenError and the rest a problem
+func decodeError(resp *http.Response, tokenRequest bool) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if tokenRequest {
+		tokenErr := &TokenError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, tokenErr) == nil && tokenErr.Code != "" {
+			return tokenErr
+		}
+	}
+	apiErr := &Error{}
+	if json.Unmarshal(body, apiErr) != nil || apiErr.StatusCode == 0 {
+		// Not a problem, like errors of the router or of a proxy in front of the server
+		apiErr = &Error{Title: http.StatusText(resp.StatusCode), Detail: strings.TrimSpace(string(body))}
+	}
+	apiErr.StatusCode = resp.StatusCode
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return apiErr
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// listResponse is the body of list responses
+type listResponse struct {
+	Results interface{} `json:"results"`
+}
+
+/*
+pager fetches the results of a list lazily and walks them. The API answers every result in a single page, the
+iterators hide it so callers don't change when lists get paginated
+*/
+type pager struct {
+	ctx     context.Context
+	client  *Client
+	path    string
+	query   url.Values
+	fetched bool
+	index   int
+	err     error
+}
+
+func (c *Client) newPager(ctx context.Context, path string, query url.Values) pager {
+	return pager{ctx: ctx, client: c, path: path, query: query}
+}
+
+// next fetches the results into the slice results points to the first time, and moves to the next one of count
+func (p *pager) next(results interface{}, count func() int) bool {
+	if !p.fetched {
+		p.fetched = true
+		r := request{method: http.MethodGet, path: p.path, query: p.query}
+		_, p.err = p.client.send(p.ctx, r, &listResponse{Results: results})
+	}
+	if p.err != nil || p.index >= count() {
+		return false
+	}
+	p.index++
+	return true
+}
+
+// Err returns the error which stopped the iteration, if any
+func (p *pager) Err() error {
+	return p.err
+}
+
+// BoxIterator walks a list of boxes, Next must be called before every Box
+type BoxIterator struct {
+	pager
+	boxes []Box
+}
+
+// Next moves to the next box, it returns false when there are no more or the list could not be fetched
+func (it *BoxIterator) Next() bool {
+	return it.next(&it.boxes, func() int { return len(it.boxes) })
+}
+
+// Box returns the current box
+func (it *BoxIterator) Box() Box {
+	return it.boxes[it.index-1]
+}
+
+// NoteIterator walks a list of notes, Next must be called before every Note
+type NoteIterator struct {
+	pager
+	notes []Note
+}
+
+// Next moves to the next note, it returns false when there are no more or the list could not be fetched
+func (it *NoteIterator) Next() bool {
+	return it.next(&it.notes, func() int { return len(it.notes) })
+}
+
+// Note returns the current note
+func (it *NoteIterator) Note() Note {
+	return it.notes[it.index-1]
+}
+
+// UserIterator walks a list of users, Next must be called before every User
+type UserIterator struct {
+	pager
+	users []User
+}
+
+// Next moves to the next user, it returns false when there are no more or the list could not be fetched
+func (it *UserIterator) Next() bool {
+	return it.next(&it.users, func() int { return len(it.users) })
+}
+
+// User returns the current user
+func (it *UserIterator) User() User {
+	return it.users[it.index-1]
+}
@@ -0,0 +1,37 @@
+package client
+
+import "context"
+
+// Note is a note of a box as answered by the API, From is empty for anonymous notes
+type Note struct {
+	From   string `json:"from,omitempty"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+// NoteRequest is the body of a request leaving a note in a box
+type NoteRequest struct {
+	Anonymous bool   `json:"anonymous"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+}
+
+func notesPath(boxID string) string {
+	return boxPath(boxID) + "/notes"
+}
+
+// ListNotes returns an iterator of the notes of a box, which must be open
+func (c *Client) ListNotes(ctx context.Context, boxID string) *NoteIterator {
+	return &NoteIterator{pager: c.newPager(ctx, notesPath(boxID), nil)}
+}
+
+// CreateNote leaves a note in a box the current user is a member of
+func (c *Client) CreateNote(ctx context.Context, boxID string, request NoteRequest) error {
+	_, err := c.post(ctx, notesPath(boxID), request, nil)
+	return err
+}
+
+// DeleteNotes deletes every note of a box
+func (c *Client) DeleteNotes(ctx context.Context, boxID string) error {
+	return c.delete(ctx, notesPath(boxID), nil, nil)
+}
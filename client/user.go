@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const userRoute = apiRoute + "/user"
+
+// Policies of the notes of a deleted account
+const (
+	NotesAnonymize = "anonymize"
+	NotesDelete    = "delete"
+)
+
+// PrivacySettings tell how a user appears in the directory
+type PrivacySettings struct {
+	Discoverable bool `json:"discoverable"`
+	ShowFullName bool `json:"showFullName"`
+}
+
+// Suspension tells why and until when an account is suspended
+type Suspension struct {
+	Reason string     `json:"reason"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`
+	By     string     `json:"by"`
+}
+
+/*
+User is a user as answered by the API. Users other than the current one are only answered with their public
+profile, which leaves the private fields empty
+*/
+type User struct {
+	ID            string           `json:"id"`
+	Username      string           `json:"username"`
+	Email         string           `json:"email,omitempty"`
+	FirstName     string           `json:"firstName,omitempty"`
+	LastName      string           `json:"lastName,omitempty"`
+	ImageURL      string           `json:"imageUrl"`
+	Status        string           `json:"status,omitempty"`
+	Notifications map[string]bool  `json:"notifications,omitempty"`
+	MFAEnabled    bool             `json:"mfaEnabled"`
+	Admin         bool             `json:"admin"`
+	Privacy       *PrivacySettings `json:"privacy,omitempty"`
+	Suspension    *Suspension      `json:"suspension,omitempty"`
+}
+
+// UserRequest is the body of a request signing up
+type UserRequest struct {
+	Username      string           `json:"username"`
+	Password      string           `json:"password"`
+	Email         string           `json:"email"`
+	FirstName     string           `json:"firstName"`
+	LastName      string           `json:"lastName,omitempty"`
+	ImageURL      string           `json:"imageUrl,omitempty"`
+	Notifications map[string]bool  `json:"notifications,omitempty"`
+	Privacy       *PrivacySettings `json:"privacy,omitempty"`
+}
+
+// AccountDeletion is the scheduled deletion of an account, it can be cancelled until ScheduledFor
+type AccountDeletion struct {
+	ID           string    `json:"id"`
+	Status       string    `json:"status"`
+	Notes        string    `json:"notes"`
+	ScheduledFor time.Time `json:"scheduledFor"`
+	Created      time.Time `json:"created"`
+}
+
+func userPath(id string) string {
+	return userRoute + "/" + escape(id)
+}
+
+// ListUsers returns an iterator of every user to admins, and of the directory to everyone else
+func (c *Client) ListUsers(ctx context.Context) *UserIterator {
+	return &UserIterator{pager: c.newPager(ctx, userRoute, nil)}
+}
+
+// SearchUsers returns an iterator of the users of the directory whose username starts with prefix
+func (c *Client) SearchUsers(ctx context.Context, prefix string) *UserIterator {
+	return &UserIterator{pager: c.newPager(ctx, userRoute+"/search", url.Values{"q": {prefix}})}
+}
+
+// GetUser returns the user with the given id
+func (c *Client) GetUser(ctx context.Context, id string) (*User, error) {
+	user := &User{}
+	if err := c.get(ctx, userPath(id), user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser signs a user up and returns their id, they can't log in until they verify their email address
+func (c *Client) CreateUser(ctx context.Context, signUp UserRequest) (string, error) {
+	header, err := c.send(ctx, request{method: http.MethodPost, path: userRoute, body: signUp, public: true}, nil)
+	if err != nil {
+		return "", err
+	}
+	return createdID(header)
+}
+
+// PatchUser updates the fields of a user in patch, nil notifications or privacy restore their defaults
+func (c *Client) PatchUser(ctx context.Context, id string, patch Patch) error {
+	return c.patch(ctx, userPath(id), patch)
+}
+
+/*
+DeleteUser schedules the deletion of an account, notes tells what happens to the notes the user wrote. By default
+they are anonymized
+*/
+func (c *Client) DeleteUser(ctx context.Context, id, notes string) (*AccountDeletion, error) {
+	deletion := &AccountDeletion{}
+	if err := c.delete(ctx, userPath(id), url.Values{"notes": {notes}}, deletion); err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// CancelUserDeletion cancels the scheduled deletion of an account
+func (c *Client) CancelUserDeletion(ctx context.Context, id string) error {
+	return c.delete(ctx, userPath(id)+"/deletion", nil, nil)
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestUsers(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	c, user := newTestClient(t, server, false)
+	other, _ := newTestClient(t, server, false)
+	id := user.GetId().Hex()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{name: "get the current user", test: func(t *testing.T) {
+			me, err := c.GetUser(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if me.ID != id || me.Username != user.Username || me.Email != user.Email {
+				t.Errorf("GetUser() of the current user returned %+v, want their private profile", me)
+			}
+		}},
+		{name: "get another user", test: func(t *testing.T) {
+			profile, err := other.GetUser(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.Username != user.Username || profile.Email != "" {
+				t.Errorf("GetUser() of another user returned %+v, want their public profile", profile)
+			}
+		}},
+		{name: "patch the current user", test: func(t *testing.T) {
+			if err := c.PatchUser(ctx, id, Patch{"firstName": "Changed", "privacy": Patch{"discoverable": false}}); err != nil {
+				t.Fatal(err)
+			}
+			me, err := c.GetUser(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if me.FirstName != "Changed" || me.Privacy == nil || me.Privacy.Discoverable {
+				t.Errorf("GetUser() returned %+v after patching it, want a new first name and hidden from the directory", me)
+			}
+		}},
+		{name: "patch another user", test: func(t *testing.T) {
+			if err := other.PatchUser(ctx, id, Patch{"firstName": "Hijacked"}); !IsKind(err, KindForbidden) {
+				t.Fatalf("PatchUser() of another user returned %v, want a forbidden *Error", err)
+			}
+		}},
+		{name: "delete the current user", test: func(t *testing.T) {
+			deletion, err := c.DeleteUser(ctx, id, NotesAnonymize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := c.CancelUserDeletion(ctx, id); err != nil {
+					t.Error(err)
+				}
+			}()
+			if deletion.Status != "scheduled" || deletion.Notes != NotesAnonymize {
+				t.Errorf("DeleteUser() returned %+v, want a scheduled deletion anonymizing the notes", deletion)
+			}
+			if _, err := c.DeleteUser(ctx, id, NotesDelete); !IsKind(err, KindConflict) {
+				t.Errorf("DeleteUser() of an account already being deleted returned %v, want a conflict *Error", err)
+			}
+		}},
+		{name: "delete another user", test: func(t *testing.T) {
+			if _, err := other.DeleteUser(ctx, id, NotesDelete); !IsKind(err, KindForbidden) {
+				t.Fatalf("DeleteUser() of another user returned %v, want a forbidden *Error", err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, test.test)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	admin, _ := newTestClient(t, server, true)
+	username := "test" + bson.NewObjectId().Hex()
+	signUp := UserRequest{Username: username, Password: testPassword, Email: username + "@example.com", FirstName: "New"}
+
+	id, err := New(server.URL).CreateUser(ctx, signUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := admin.GetUser(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != username || user.Email != signUp.Email {
+		t.Errorf("GetUser() returned %+v, want the user just signed up", user)
+	}
+	if _, err := New(server.URL).CreateUser(ctx, signUp); !IsKind(err, KindValidation) {
+		t.Fatalf("CreateUser() of a taken username returned %v, want a validation *Error", err)
+	}
+	if _, err := New(server.URL).Login(ctx, username, testPassword); err == nil {
+		t.Fatal("Login() of a user who hasn't verified their email succeeded")
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.Close()
+	ctx := context.Background()
+	c, user := newTestClient(t, server, false)
+	admin, _ := newTestClient(t, server, true)
+	hidden, hiddenUser := newTestClient(t, server, false)
+	if err := hidden.PatchUser(ctx, hiddenUser.GetId().Hex(), Patch{"privacy": Patch{"discoverable": false}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// listed returns the usernames the iterator walks, failing the test if it stops with an error
+	listed := func(it *UserIterator) map[string]bool {
+		usernames := make(map[string]bool)
+		for it.Next() {
+			usernames[it.User().Username] = true
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return usernames
+	}
+
+	if users := listed(c.SearchUsers(ctx, user.Username)); len(users) != 1 || !users[user.Username] {
+		t.Errorf("SearchUsers() of a username returned %v, want only %s", users, user.Username)
+	}
+	if users := listed(c.SearchUsers(ctx, hiddenUser.Username)); len(users) != 0 {
+		t.Errorf("SearchUsers() returned %v, which are hidden from the directory", users)
+	}
+	if users := listed(c.ListUsers(ctx)); users[hiddenUser.Username] {
+		t.Errorf("ListUsers() returned %s, who is hidden from the directory, to a user who isn't an admin",
+			hiddenUser.Username)
+	}
+	if users := listed(admin.ListUsers(ctx)); !users[user.Username] || !users[hiddenUser.Username] {
+		t.Errorf("ListUsers() left out %s or %s for an admin, who get every user", user.Username, hiddenUser.Username)
+	}
+}
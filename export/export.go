@@ -0,0 +1,171 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/jobs"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Types of the jobs of data exports
+const (
+	// BuildJobType is the type of the jobs that build a data export
+	BuildJobType = "user.export"
+	// PruneJobType is the type of the jobs that remove the archives of expired exports
+	PruneJobType = "user.export.prune"
+)
+
+const pruneInterval = time.Hour
+
+// Format and Version identify the layout of the archive, described by its manifest.json
+const (
+	Format  = "magicbox-export"
+	Version = 1
+)
+
+const manifestPath = "manifest.json"
+
+// buildJob is the payload of data export jobs
+type buildJob struct {
+	Export bson.ObjectId `bson:"export"`
+}
+
+// Manifest describes the files of an export archive, so it can be read by machines
+type Manifest struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	User      bson.ObjectId  `json:"user"`
+	Generated time.Time      `json:"generated"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a file of an export archive, every one is a JSON document
+type ManifestFile struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	ContentType string `json:"contentType"`
+	// Records is the length of the file's array, or 1 for a single object
+	Records int `json:"records"`
+}
+
+// profile is the content of profile.json
+type profile struct {
+	models.UserResponse
+	Identities models.IdentityListResponse `json:"identities"`
+	Created    time.Time                   `json:"created"`
+}
+
+// Register makes pool build the requested data exports and remove their archives once they expire
+func Register(pool *jobs.Pool) {
+	pool.Handle(BuildJobType, build)
+	pool.Handle(PruneJobType, prune)
+	pool.Every(PruneJobType, pruneInterval)
+}
+
+// Request returns the pending or ready export of user, or saves a pending one and queues building it if there is none
+func Request(user models.User) (*models.DataExport, error) {
+	export, created, err := models.RequestDataExport(user)
+	if err != nil || !created {
+		return export, err
+	}
+	return export, jobs.Enqueue(BuildJobType, buildJob{Export: export.GetId()})
+}
+
+func build(job *jobs.Job) error {
+	var payload buildJob
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	export, err := models.GetDataExportByID(payload.Export.Hex())
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	user, err := models.GetUserByID(export.User.Hex())
+	if err != nil {
+		export.Fail()
+		return jobs.Permanent(err)
+	}
+	archive, err := Build(user)
+	if err != nil {
+		if job.IsLastAttempt() {
+			export.Fail()
+		}
+		return err
+	}
+	return export.Complete(archive)
+}
+
+func prune(job *jobs.Job) error {
+	return models.PruneExportArchives()
+}
+
+// archiveWriter writes JSON files into a zip, recording them for the manifest
+type archiveWriter struct {
+	zip   *zip.Writer
+	files []ManifestFile
+}
+
+func (w *archiveWriter) writeJSON(path string, v interface{}) error {
+	f, err := w.zip.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (w *archiveWriter) add(path, description string, records int, v interface{}) error {
+	w.files = append(w.files, ManifestFile{
+		Path:        path,
+		Description: description,
+		ContentType: "application/json",
+		Records:     records,
+	})
+	return w.writeJSON(path, v)
+}
+
+/*
+Build returns a zip archive with the personal data MagicBox holds about user: their profile, the boxes they are a
+member of and the notes they wrote in open boxes, described by manifest.json
+*/
+func Build(user models.User) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := &archiveWriter{zip: zip.NewWriter(buf)}
+
+	userProfile := profile{
+		UserResponse: user.GetResponse(),
+		Identities:   user.GetIdentityListResponse(),
+		Created:      user.Created,
+	}
+	if err := w.add("profile.json", "The account of the user", 1, userProfile); err != nil {
+		return nil, err
+	}
+	boxes := models.GetBoxMemberships(user)
+	if err := w.add("boxes.json", "The boxes the user is a member of", len(boxes), boxes); err != nil {
+		return nil, err
+	}
+	notes := models.GetAuthoredNotes(user)
+	if err := w.add("notes.json", "The notes the user signed in boxes that are open, sealed boxes are left out", len(notes), notes); err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{
+		Format:    Format,
+		Version:   Version,
+		User:      user.GetId(),
+		Generated: time.Now().UTC(),
+		Files:     w.files,
+	}
+	if err := w.writeJSON(manifestPath, manifest); err != nil {
+		return nil, err
+	}
+	if err := w.zip.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// ListPersonalAccessTokensHandler handles GET requests for listing the personal access tokens of a user
+func ListPersonalAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "personal access tokens", false)
+	if !ok {
+		return
+	}
+	utils.ResponseJSON(w, models.GetPersonalAccessTokenListResponse(user), true)
+}
+
+// CreatePersonalAccessTokenHandler handles POST requests for minting a personal access token, only returned here
+func CreatePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "personal access tokens", false)
+	if !ok {
+		return
+	}
+	var tokenRequest models.PersonalAccessTokenRequest
+	if err := decodeRequest(r, &tokenRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	accessToken, token := models.NewPersonalAccessToken(tokenRequest, user)
+	if err := accessToken.Save(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	setLocationHeader(w, r, accessToken)
+	utils.ResponseCreatedJSON(w, models.PersonalAccessTokenCreatedResponse{
+		PersonalAccessTokenResponse: accessToken.GetResponse(),
+		Token:                       token,
+	})
+}
+
+// RevokePersonalAccessTokenHandler handles DELETE requests for revoking a personal access token
+func RevokePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "personal access tokens", false)
+	if !ok {
+		return
+	}
+	accessToken, err := models.GetPersonalAccessTokenByID(mux.Vars(r)["tokenID"])
+	if err != nil || !accessToken.IsOwnedBy(user) {
+		utils.ResponseProblem(w, models.NewError(models.KindNotFound, "Personal access token not found"))
+		return
+	}
+	if err := accessToken.Delete(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// LockoutDetailHandler handles GET requests for the failed logins and the lock of a username or an IP
+func LockoutDetailHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	lockout, err := auth.GetLockout(vars["kind"], vars["value"])
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseJSON(w, lockout, false)
+}
+
+// UnlockHandler handles DELETE requests for clearing the failed logins and the lock of a username or an IP
+func UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := auth.Unlock(vars["kind"], vars["value"]); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
+
+// UserRoleHandler handles PUT requests for granting or revoking the admin role of a user
+func UserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := models.GetUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	var roleRequest models.UserRoleRequest
+	if err := decodeRequest(r, &roleRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	// Otherwise the last admin could leave nobody able to grant the role again
+	current := getCurrentUser(r)
+	if !roleRequest.Admin && user.GetId() == current.GetId() {
+		utils.ResponseProblem(w, models.NewError(models.KindConflict, "Admins can't revoke their own role"))
+		return
+	}
+
+	if err := user.SetAdmin(roleRequest.Admin); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseJSON(w, user.GetResponse(), false)
+}
+
+// SuspendUserHandler handles PUT requests for suspending a user, with a reason and an optional expiry
+func SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := models.GetUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	var suspensionRequest models.SuspensionRequest
+	if err := decodeRequest(r, &suspensionRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	current := getCurrentUser(r)
+	if user.GetId() == current.GetId() {
+		utils.ResponseProblem(w, models.NewError(models.KindConflict, "Admins can't suspend themselves"))
+		return
+	}
+
+	if err := user.Suspend(current, suspensionRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseJSON(w, user.GetResponse(), false)
+}
+
+// ReactivateUserHandler handles DELETE requests for lifting the suspension of a user
+func ReactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := models.GetUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	if !user.IsSuspended() {
+		utils.ResponseProblem(w, models.NewError(models.KindConflict, "User is not suspended"))
+		return
+	}
+	if err := user.Reactivate(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseJSON(w, user.GetResponse(), false)
+}
@@ -1,16 +1,16 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/jenarvaezg/magicbox/models"
 	"github.com/jenarvaezg/magicbox/utils"
+	"github.com/jenarvaezg/magicbox/webhooks"
 )
 
 func getBoxRequest(r *http.Request) (models.BoxRequest, error) {
 	var boxRequest models.BoxRequest
-	err := json.NewDecoder(r.Body).Decode(&boxRequest)
+	err := decodeRequest(r, &boxRequest)
 	return boxRequest, err
 }
 
@@ -24,15 +24,16 @@ func ListBoxesHandler(w http.ResponseWriter, r *http.Request) {
 func CreateBoxHandler(w http.ResponseWriter, r *http.Request) {
 	boxRequest, err := getBoxRequest(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
-	box := models.NewBox(boxRequest)
-	box.AddUser(getCurrentUser(r))
+	user := getCurrentUser(r)
+	box := models.NewBox(boxRequest, user)
 	if err := box.Save(); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 	} else {
+		webhooks.Emit(models.EventBoxCreated, *box, &user)
 		setLocationHeader(w, r, box)
 		utils.ResponseCreated(w)
 	}
@@ -49,33 +50,44 @@ func BoxDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	box := getBox(r)
 	user := getCurrentUser(r)
 	if !box.IsUserRegistered(user) {
-		utils.ResponseError(w, "You are not allowed to delete this box", http.StatusForbidden)
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to delete this box"))
 		return
 	}
 	if err := box.Delete(); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
+	webhooks.Emit(models.EventBoxDeleted, *box, &user)
 	utils.ResponseNoContent(w)
 }
 
-// BoxPatchHandler handles PATCH requests for box updating
+// BoxPatchHandler handles PATCH requests for box updating, with a JSON merge patch
 func BoxPatchHandler(w http.ResponseWriter, r *http.Request) {
 	box := getBox(r)
 	user := getCurrentUser(r)
 	if !box.IsUserRegistered(user) {
-		utils.ResponseError(w, "You are not allowed to edit this box", http.StatusForbidden)
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to edit this box"))
 		return
 	}
-	boxRequest, err := getBoxRequest(r)
+	patch, err := decodeMergePatch(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
-	if err := box.Update(boxRequest); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusNotFound)
+	if err := box.Patch(patch, user); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
 	utils.ResponseNoContent(w)
 }
+
+// BoxContributionsHandler handles GET requests for knowing which members have written a note yet
+func BoxContributionsHandler(w http.ResponseWriter, r *http.Request) {
+	box := getBox(r)
+	if !box.IsOwner(getCurrentUser(r)) {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "Only the box owner can see its contributions"))
+		return
+	}
+	utils.ResponseJSON(w, models.GetContributionListResponse(*box), true)
+}
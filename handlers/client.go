@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+func getAPIClientRequest(r *http.Request) (models.APIClientRequest, error) {
+	var clientRequest models.APIClientRequest
+	err := decodeRequest(r, &clientRequest)
+	return clientRequest, err
+}
+
+// getOwnAPIClient returns the API client in the url, writing a 403 and returning nil if the current user does not own it
+func getOwnAPIClient(w http.ResponseWriter, r *http.Request) *models.APIClient {
+	if refuseDelegated(w, r, "manage API clients") {
+		return nil
+	}
+	client := getAPIClient(r)
+	if !client.IsOwnedBy(getCurrentUser(r)) {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to access this API client"))
+		return nil
+	}
+	return client
+}
+
+// ListAPIClientsHandler handles GET requests for listing the current user's API clients
+func ListAPIClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if refuseDelegated(w, r, "manage API clients") {
+		return
+	}
+	utils.ResponseJSON(w, models.GetAPIClientListResponse(getCurrentUser(r)), true)
+}
+
+// CreateAPIClientHandler handles POST requests for API client registration, the secret is only returned here
+func CreateAPIClientHandler(w http.ResponseWriter, r *http.Request) {
+	if refuseDelegated(w, r, "manage API clients") {
+		return
+	}
+	clientRequest, err := getAPIClientRequest(r)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	client, secret := models.NewAPIClient(clientRequest, getCurrentUser(r))
+	if err := client.Save(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	setLocationHeader(w, r, client)
+	utils.ResponseCreatedJSON(w, models.APIClientCreatedResponse{
+		APIClientResponse: client.GetResponse(),
+		Secret:            secret,
+	})
+}
+
+// APIClientDetailHandler handles GET requests for API client detail
+func APIClientDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if client := getOwnAPIClient(w, r); client != nil {
+		utils.ResponseJSON(w, client.GetResponse(), false)
+	}
+}
+
+// APIClientDeleteHandler handles DELETE requests for API client deletion, its tokens stop working at once
+func APIClientDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	client := getOwnAPIClient(w, r)
+	if client == nil {
+		return
+	}
+	if err := client.Delete(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+const (
+	// maxRequestBody is the size in bytes of the largest JSON body a request may have
+	maxRequestBody        = 64 << 10
+	mergePatchContentType = "application/merge-patch+json"
+)
+
+// requestValidator is implemented by requests which check their own fields, reporting every invalid one at once
+type requestValidator interface {
+	Validate() error
+}
+
+/*
+decodeRequest decodes the JSON body of r into the struct v points to. Bodies too large, which aren't a JSON object or
+which have fields v doesn't are refused, and requests which are a requestValidator are validated
+*/
+func decodeRequest(r *http.Request, v interface{}) error {
+	body, err := readRequestBody(r)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return models.NewError(models.KindBadRequest, "Request body must be a JSON object")
+	}
+	if errs := getUnknownFields(fields, v); len(errs) > 0 {
+		return models.NewValidationError(errs)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return models.NewFieldError(typeErr.Field, "Field %s can't be a %s", typeErr.Field, typeErr.Value)
+		}
+		return models.NewError(models.KindBadRequest, "Request body is not valid JSON")
+	}
+	if validator, ok := v.(requestValidator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// readRequestBody reads the body of r, which can't be larger than maxRequestBody
+func readRequestBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestBody+1))
+	if err != nil {
+		return nil, models.NewError(models.KindBadRequest, "Request body could not be read")
+	}
+	if len(body) > maxRequestBody {
+		return nil, models.NewError(models.KindTooLarge, "Request body can't be larger than %d bytes", maxRequestBody)
+	}
+	return body, nil
+}
+
+/*
+decodeMergePatch decodes the body of r as an RFC 7396 merge patch. It is sent as application/merge-patch+json,
+though application/json is accepted too, as PATCH requests always had merge semantics
+*/
+func decodeMergePatch(r *http.Request) (models.MergePatch, error) {
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if contentType != mergePatchContentType && contentType != "application/json" {
+		return nil, models.NewError(models.KindUnsupportedMediaType, "Expected content-type to be %s", mergePatchContentType)
+	}
+	body, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var patch models.MergePatch
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return nil, models.NewError(models.KindBadRequest, "Request body must be a JSON object")
+	}
+	return patch, nil
+}
+
+/*
+getUnknownFields returns an error for every field of a request body the struct v points to doesn't have. The fields
+of the structs v embeds are its own, as encoding/json decodes them
+*/
+func getUnknownFields(fields map[string]json.RawMessage, v interface{}) []models.FieldError {
+	known := make(map[string]bool)
+	addKnownFields(known, reflect.TypeOf(v).Elem())
+
+	names := make([]string, 0)
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	errs := make([]models.FieldError, len(names))
+	for i, name := range names {
+		errs[i] = models.FieldError{Field: name, Message: "Unknown field " + name}
+	}
+	return errs
+}
+
+// addKnownFields adds to known the lowercase json names of the fields of the struct type t and of those it embeds
+func addKnownFields(known map[string]bool, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			addKnownFields(known, fieldType)
+			continue
+		}
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		// Like encoding/json, field names are matched case insensitively
+		known[strings.ToLower(name)] = true
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type testEmbeddedRequest struct {
+	Name string `json:"name"`
+}
+
+type testRequest struct {
+	testEmbeddedRequest
+	*testOptionalRequest
+	Email    string `json:"email,omitempty"`
+	Internal string `json:"-"`
+	Untagged string
+	ignored  string
+}
+
+type testOptionalRequest struct {
+	Note string `json:"note"`
+}
+
+func TestGetUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "known", body: `{"name": "", "email": "", "note": "", "Untagged": ""}`},
+		{name: "case insensitive", body: `{"Name": "", "EMAIL": "", "untagged": ""}`},
+		{name: "unknown", body: `{"name": "", "zeta": 1, "alpha": 2}`, want: []string{"alpha", "zeta"}},
+		{name: "ignored", body: `{"Internal": "", "ignored": "", "-": ""}`, want: []string{"-", "Internal", "ignored"}},
+		{name: "embedded struct", body: `{"testEmbeddedRequest": {}}`, want: []string{"testEmbeddedRequest"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(test.body), &fields); err != nil {
+				t.Fatal(err)
+			}
+			errs := getUnknownFields(fields, &testRequest{})
+			names := make([]string, len(errs))
+			for i, fieldErr := range errs {
+				names[i] = fieldErr.Field
+			}
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("getUnknownFields() returned %v, want %v", names, test.want)
+			}
+		})
+	}
+}
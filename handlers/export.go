@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/export"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// getExportResponse returns the response of dataExport, with a signed link to download it
+func getExportResponse(dataExport *models.DataExport) models.DataExportResponse {
+	id := dataExport.GetId().Hex()
+	token := auth.NewDownloadToken(id, dataExport.ExpiresAt)
+	return dataExport.GetResponse(fmt.Sprintf("/export/%s?token=%s", id, url.QueryEscape(token)))
+}
+
+/*
+RequestExportHandler handles POST requests for exporting the personal data of a user, which is built in the
+background. Users with an export pending or ready get that one instead of a new one
+*/
+func RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "data exports", false)
+	if !ok {
+		return
+	}
+	dataExport, err := export.Request(user)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", r.URL.Path, dataExport.GetId().Hex()))
+	utils.ResponseAcceptedJSON(w, getExportResponse(dataExport))
+}
+
+// ExportDetailHandler handles GET requests for the state of a data export, which holds the download link once ready
+func ExportDetailHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "data exports", false)
+	if !ok {
+		return
+	}
+	dataExport, err := models.GetDataExportByID(mux.Vars(r)["exportID"])
+	if err != nil || !dataExport.IsOwnedBy(user) {
+		utils.ResponseProblem(w, models.NewError(models.KindNotFound, "Export not found"))
+		return
+	}
+	utils.ResponseJSON(w, getExportResponse(&dataExport), false)
+}
+
+// DownloadExportHandler handles GET requests for the archive of a data export, authorized by the token of its link
+func DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["exportID"]
+	if !auth.VerifyDownloadToken(id, r.URL.Query().Get("token")) {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "Invalid or expired download link"))
+		return
+	}
+	dataExport, err := models.GetDataExportByID(id)
+	if err != nil || dataExport.Status != models.ExportReady {
+		utils.ResponseProblem(w, models.NewError(models.KindNotFound, "Export not found"))
+		return
+	}
+	// Links are valid until the export expires, even if the account was deleted in between
+	if _, err := models.GetUserByID(dataExport.User.Hex()); err != nil {
+		utils.ResponseProblem(w, models.NewError(models.KindNotFound, "Export not found"))
+		return
+	}
+	archive, err := dataExport.OpenArchive()
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	defer archive.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(archive.Size(), 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="magicbox-export-%s.zip"`, id))
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := io.Copy(w, archive); err != nil {
+		log.Println("Could not send export archive", err)
+	}
+}
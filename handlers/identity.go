@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// IdentityLinkRequest is a struct that resembles a request performed by users to link an external identity
+type IdentityLinkRequest struct {
+	IDToken string `json:"idToken"`
+	Nonce   string `json:"nonce"`
+}
+
+// ListIdentitiesHandler handles GET requests for listing the identities linked to a user
+func ListIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "identities", false)
+	if !ok {
+		return
+	}
+	utils.ResponseJSON(w, user.GetIdentityListResponse(), true)
+}
+
+// LinkIdentityHandler handles POST requests for linking the identity of an ID token to a user
+func LinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "identities", false)
+	if !ok {
+		return
+	}
+	var linkRequest IdentityLinkRequest
+	if err := decodeRequest(r, &linkRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	identity, err := auth.VerifyIdentity(linkRequest.IDToken, linkRequest.Nonce)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	if err := user.LinkIdentity(identity); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseCreatedJSON(w, user.GetIdentityListResponse())
+}
+
+// UnlinkIdentityHandler handles DELETE requests for unlinking the identity of a provider from a user
+func UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "identities", false)
+	if !ok {
+		return
+	}
+	if err := user.UnlinkIdentity(mux.Vars(r)["provider"]); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
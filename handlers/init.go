@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-bongo/bongo"
 	"github.com/gorilla/mux"
+	"github.com/jenarvaezg/magicbox/auth"
 	"github.com/jenarvaezg/magicbox/models"
 	"github.com/jenarvaezg/magicbox/utils"
 )
@@ -13,14 +14,16 @@ import (
 // RequireJSONFunc is a MatcherFunc for gorilla mux, which specifies that a method is accesed with json
 func RequireJSONFunc(r *http.Request, rm *mux.RouteMatch) bool {
 	if r.Method == "POST" && r.Header.Get("content-type") != "application/json" {
-		rm.Handler = badRequestHandler()
+		rm.Handler = notJSONHandler()
 	}
 	return true
 }
 
-func badRequestHandler() http.Handler {
+var errNotJSON = models.NewError(models.KindUnsupportedMediaType, "Expected content-type to be application/json")
+
+func notJSONHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		utils.ResponseError(w, "Expected content-type to be application/json", http.StatusBadRequest)
+		utils.ResponseProblem(w, errNotJSON)
 	})
 }
 
@@ -36,13 +39,57 @@ func getUser(r *http.Request) models.User {
 	return ctx.Value(utils.ContextKeyUser).(models.User)
 }
 
+func getWebhook(r *http.Request) *models.Webhook {
+	ctx := r.Context()
+	webhook := ctx.Value(utils.ContextKeyWebhook).(models.Webhook)
+	return &webhook
+}
+
+func getAPIClient(r *http.Request) *models.APIClient {
+	ctx := r.Context()
+	client := ctx.Value(utils.ContextKeyAPIClient).(models.APIClient)
+	return &client
+}
+
 func getCurrentUser(r *http.Request) models.User {
 	ctx := r.Context()
 	return ctx.Value(utils.ContextKeyCurrentUser).(models.User)
 }
 
+func getTokenClaims(r *http.Request) *auth.TokenClaims {
+	ctx := r.Context()
+	return ctx.Value(utils.ContextKeyTokenClaims).(*auth.TokenClaims)
+}
+
+/*
+refuseDelegated writes a 403 and returns true if r was made with a delegated token, as what it asks for needs a
+first-party session. action completes the message of the error
+*/
+func refuseDelegated(w http.ResponseWriter, r *http.Request, action string) bool {
+	if getTokenClaims(r).IsDelegated() {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "Delegated tokens can't %s", action))
+		return true
+	}
+	return false
+}
+
+/*
+requireSelf returns the user of the url if they are the requesting user, or any user for admins when allowAdmins is
+set. Otherwise, or if r was made with a delegated token, it writes a 403 telling the user can only manage their own
+things and returns false
+*/
+func requireSelf(w http.ResponseWriter, r *http.Request, things string, allowAdmins bool) (models.User, bool) {
+	user, current, delegated := getUser(r), getCurrentUser(r), getTokenClaims(r).IsDelegated()
+	allowed := user.GetId() == current.GetId() || (allowAdmins && current.CanManage(user, delegated))
+	if delegated || !allowed {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You can only manage your own %s", things))
+		return user, false
+	}
+	return user, true
+}
+
 func setLocationHeader(w http.ResponseWriter, r *http.Request, document bongo.Document) {
 	url, _ := mux.CurrentRoute(r).URL()
 	id := document.GetId().Hex()
-	w.Header().Set("Locaton", fmt.Sprintf("%s/%s", url.RequestURI(), id))
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", url.RequestURI(), id))
 }
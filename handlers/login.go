@@ -4,37 +4,82 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/models"
 	"github.com/jenarvaezg/magicbox/utils"
 )
 
+var errTooManyNonces = models.NewError(models.KindTooManyRequests, "Too many nonces requested, try again later")
+
+// getSessionClient describes the client of r, behind trusted proxies its address is read from X-Forwarded-For
+func getSessionClient(r *http.Request) models.SessionClient {
+	return models.SessionClient{UserAgent: r.UserAgent(), IP: clientIP(r, trustedProxies)}
+}
+
+// writeToken answers a successful token request, which must never be cached
+func writeToken(w http.ResponseWriter, token auth.TokenResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	utils.ResponseJSON(w, token, false)
+}
+
+// retryAfterSeconds formats d for a Retry-After header, rounding up so clients don't retry too early
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// writeTokenError answers a failed token request with an RFC 6749 error
+func writeTokenError(w http.ResponseWriter, r *http.Request, err error) {
+	tokenErr := auth.AsTokenError(err)
+	if tokenErr.Status() == http.StatusInternalServerError {
+		log.Println(err)
+	}
+	if _, _, ok := r.BasicAuth(); ok && tokenErr.IsInvalidClient() {
+		w.Header().Set("WWW-Authenticate", `Basic realm="magicbox"`)
+	}
+	if tokenErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(tokenErr.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(tokenErr.Status())
+	json.NewEncoder(w).Encode(tokenErr)
+}
+
 func loginWithOwnUser(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	token, err := auth.GetAuthTokenFromForm(r.Form)
+	// Clients may authenticate with HTTP Basic instead of the form
+	if id, secret, ok := r.BasicAuth(); ok {
+		r.Form.Set("client_id", id)
+		r.Form.Set("client_secret", secret)
+	}
+	token, err := auth.GetAuthTokenFromForm(r.Form, getSessionClient(r))
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		writeTokenError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(token))
+	writeToken(w, token)
 }
 
 func loginWithGoogle(w http.ResponseWriter, r *http.Request) {
 	var req auth.GoogleFrontendRequest
 
+	// Not decoded strictly, the frontend sends the whole response of the Google sign-in library
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		writeTokenError(w, r, auth.InvalidRequest("Request body is not valid JSON"))
 		return
 	}
-	token, err := auth.GetAuthTokenFromGoogleToken(req)
+	token, err := auth.GetAuthTokenFromGoogleToken(req, getSessionClient(r))
 	if err != nil {
 		log.Println(err)
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		writeTokenError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(token))
+	writeToken(w, token)
 }
 
 // LoginRequestHandler handles request for token issuing
@@ -45,3 +90,53 @@ func LoginRequestHandler(w http.ResponseWriter, r *http.Request) {
 		loginWithOwnUser(w, r)
 	}
 }
+
+// RefreshTokenHandler handles POST requests exchanging a refresh token for new tokens
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	token, err := auth.RefreshAuthToken(r.Form.Get("refresh_token"), getSessionClient(r))
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	writeToken(w, token)
+}
+
+// LogoutHandler handles POST requests for ending the session of the requesting token
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := auth.Logout(getTokenClaims(r)); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
+
+/*
+LoginNonceHandler handles POST requests for a single use nonce, to be put in the ID token of a provider login.
+Anybody can ask for one, so each client IP can only ask for a few at a time
+*/
+func LoginNonceHandler(w http.ResponseWriter, r *http.Request) {
+	wait, err := auth.ThrottleLoginNonce(getSessionClient(r).IP)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(wait))
+		utils.ResponseProblem(w, errTooManyNonces)
+		return
+	}
+	nonce, err := models.NewLoginNonce()
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	utils.ResponseJSON(w, map[string]string{"nonce": nonce}, false)
+}
+
+// JWKSHandler publishes the public keys auth tokens are signed with, so other services can verify them
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.ResponseJSON(w, auth.GetJWKS(), false)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// MFADisableRequest is a struct that resembles a request performed by users to disable two-factor authentication
+type MFADisableRequest struct {
+	Password string `json:"password"`
+	IDToken  string `json:"idToken"`
+	Nonce    string `json:"nonce"`
+	Code     string `json:"code"`
+}
+
+// StartTOTPHandler handles POST requests for starting the enrollment of an authenticator app
+func StartTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "two-factor authentication", false)
+	if !ok {
+		return
+	}
+	enrollment, err := user.StartTOTPEnrollment()
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseCreatedJSON(w, enrollment)
+}
+
+// ConfirmTOTPHandler handles POST requests for enabling two-factor authentication with a first code
+func ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "two-factor authentication", false)
+	if !ok {
+		return
+	}
+	var codeRequest models.MFACodeRequest
+	if err := decodeRequest(r, &codeRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	codes, err := user.ConfirmTOTP(codeRequest.Code)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseJSON(w, codes, true)
+}
+
+// DisableTOTPHandler handles POST requests for disabling two-factor authentication, which requires logging in again
+func DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "two-factor authentication", false)
+	if !ok {
+		return
+	}
+	var disableRequest MFADisableRequest
+	if err := decodeRequest(r, &disableRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	if err := auth.Reauthenticate(&user, getSessionClient(r), disableRequest.Password, disableRequest.IDToken,
+		disableRequest.Nonce, disableRequest.Code); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	if err := user.DisableTOTP(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
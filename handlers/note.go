@@ -1,17 +1,17 @@
 package handlers
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 
 	"github.com/jenarvaezg/magicbox/models"
 	"github.com/jenarvaezg/magicbox/utils"
+	"github.com/jenarvaezg/magicbox/webhooks"
 )
 
 func getNoteRequest(r *http.Request) (models.NoteRequest, error) {
 	var noteRequest models.NoteRequest
-	err := json.NewDecoder(r.Body).Decode(&noteRequest)
+	err := decodeRequest(r, &noteRequest)
 	return noteRequest, err
 }
 
@@ -21,13 +21,13 @@ func ListNotesHandler(w http.ResponseWriter, r *http.Request) {
 	user := getCurrentUser(r)
 
 	if !box.IsUserRegistered(user) {
-		utils.ResponseError(w, "You are not allowed to get notes from this box", http.StatusForbidden)
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to get notes from this box"))
 		return
 	}
 
 	notes, err := models.GetNoteListResponse(box)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusConflict)
+		utils.ResponseProblem(w, err)
 	} else {
 		utils.ResponseJSON(w, notes, true)
 	}
@@ -38,27 +38,28 @@ func InsertNoteHandler(w http.ResponseWriter, r *http.Request) {
 	box := getBox(r)
 	user := getCurrentUser(r)
 	if !box.IsUserRegistered(user) {
-		utils.ResponseError(w, "You are not allowed to insert notes into this box", http.StatusForbidden)
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to insert notes into this box"))
 		return
 	}
 
 	noteRequest, err := getNoteRequest(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
 	note := models.NewNote(noteRequest, user)
 
 	if err := note.Validate(); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
-	if err := box.AddNote(*note); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+	if err := box.AddNote(*note, user); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
 	log.Println(box)
+	webhooks.EmitNoteAdded(*box, *note, user)
 	utils.ResponseCreated(w)
 }
 
@@ -67,7 +68,7 @@ func DeleteNotesHandler(w http.ResponseWriter, r *http.Request) {
 	box := getBox(r)
 	user := getCurrentUser(r)
 	if !box.IsUserRegistered(user) {
-		utils.ResponseError(w, "You are not allowed to delete notes from this box", http.StatusForbidden)
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to delete notes from this box"))
 		return
 	}
 
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/notify"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+/*
+RequestPasswordResetHandler handles POST requests for mailing a password reset link. It answers the same
+whether the email is registered or not, so it can't be used to find out registered emails
+*/
+func RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var resetRequest models.PasswordResetRequest
+	if err := decodeRequest(r, &resetRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	if err := models.CheckPasswordResetRate(resetRequest.Email); err != nil {
+		if err == models.ErrTooManyResetRequests {
+			utils.ResponseProblem(w, err)
+			return
+		}
+		log.Println("Could not check password reset rate", err)
+	} else if user, err := models.GetUserByEmail(resetRequest.Email); err == nil {
+		token, err := models.NewPasswordReset(*user)
+		if err != nil {
+			log.Println("Could not create password reset", err)
+		} else {
+			notify.PasswordReset(*user, token)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmPasswordResetHandler handles POST requests for setting a new password with a mailed reset token
+func ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var confirmRequest models.PasswordResetConfirmRequest
+	if err := decodeRequest(r, &confirmRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	if _, err := models.ResetPassword(confirmRequest.Token, confirmRequest.Password); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// trustedProxies are the networks of the proxies in front of the server, whose X-Forwarded-For headers are believed
+var trustedProxies = loadTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+// loadTrustedProxies parses a comma separated list of addresses and CIDR networks
+func loadTrustedProxies(config string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("TRUSTED_PROXIES entry %q is not an address or a network", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isTrustedProxy(ip net.IP, proxies []*net.IPNet) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+clientIP returns the address of the client of r. X-Forwarded-For is only read when the request comes from a trusted
+proxy, and walked from the right, as every proxy appends the address it got the request from, up to the first
+hop which isn't a trusted proxy. Anything left of it could have been sent by the client itself
+*/
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil || !isTrustedProxy(ip, proxies) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop, proxies) {
+			break
+		}
+	}
+	return ip.String()
+}
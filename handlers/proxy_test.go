@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies := loadTrustedProxies("10.0.0.0/8, 192.0.2.1, 2001:db8::1")
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct", remote: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "direct with forged header", remote: "203.0.113.7:4000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.2:4000", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "trusted proxy without header", remote: "10.0.0.2:4000", want: "10.0.0.2"},
+		{name: "address forged before the proxy", remote: "10.0.0.2:4000", forwarded: []string{"198.51.100.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "chain of trusted proxies", remote: "192.0.2.1:4000", forwarded: []string{"198.51.100.1, 203.0.113.7, 10.1.2.3"}, want: "203.0.113.7"},
+		{name: "several headers", remote: "10.0.0.2:4000", forwarded: []string{"198.51.100.1", "203.0.113.7, 10.1.2.3"}, want: "203.0.113.7"},
+		{name: "malformed hop", remote: "10.0.0.2:4000", forwarded: []string{"203.0.113.7, garbage, 10.1.2.3"}, want: "10.1.2.3"},
+		{name: "every hop trusted", remote: "10.0.0.2:4000", forwarded: []string{"10.1.2.3, 10.3.2.1"}, want: "10.1.2.3"},
+		{name: "ipv6 proxy", remote: "[2001:db8::1]:4000", forwarded: []string{"2001:db8::7"}, want: "2001:db8::7"},
+		{name: "untrusted ipv6", remote: "[2001:db8::2]:4000", forwarded: []string{"203.0.113.7"}, want: "2001:db8::2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/login", nil)
+			r.RemoteAddr = test.remote
+			for _, forwarded := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", forwarded)
+			}
+			if got := clientIP(r, proxies); got != test.want {
+				t.Errorf("clientIP() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutTrustedProxies(t *testing.T) {
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "127.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := clientIP(r, loadTrustedProxies("")); got != "127.0.0.1" {
+		t.Errorf("clientIP() = %q, want the remote address", got)
+	}
+}
@@ -1,16 +1,16 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/jenarvaezg/magicbox/models"
 	"github.com/jenarvaezg/magicbox/utils"
+	"github.com/jenarvaezg/magicbox/webhooks"
 )
 
 func getRegisterRequest(r *http.Request) (models.BoxRegisterRequest, error) {
 	var registerRequest models.BoxRegisterRequest
-	err := json.NewDecoder(r.Body).Decode(&registerRequest)
+	err := decodeRequest(r, &registerRequest)
 	return registerRequest, err
 }
 
@@ -19,19 +19,21 @@ func RegisterInBoxHandler(w http.ResponseWriter, r *http.Request) {
 	box := getBox(r)
 	registerRequest, err := getRegisterRequest(r) //boxRequest, err := getBoxRequest(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
+	user := getCurrentUser(r)
 	if !box.ChallengePassword(registerRequest.Passphrase) {
-		utils.ResponseError(w, "Provided passphrase is not valid for this box", http.StatusBadRequest)
+		utils.ResponseProblem(w, models.NewError(models.KindBadRequest, "Provided passphrase is not valid for this box"))
 		return
 	}
-	if err := box.AddUser(getCurrentUser(r)); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusConflict)
+	if err := box.AddUser(user); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
 	box.Save()
+	webhooks.Emit(models.EventMemberJoined, *box, &user)
 	w.WriteHeader(http.StatusOK)
 
 }
@@ -39,13 +41,18 @@ func RegisterInBoxHandler(w http.ResponseWriter, r *http.Request) {
 // RemoveFromBoxHandler handles DELETE requests for user deletion from a box
 func RemoveFromBoxHandler(w http.ResponseWriter, r *http.Request) {
 	box := getBox(r)
+	user := getCurrentUser(r)
 
-	if err := box.RemoveUser(getCurrentUser(r)); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+	if err := box.RemoveUser(user); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
 	if err := box.Save(); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
+		return
+	}
+	if err := models.DeleteBoxWebhooks(*box, user); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// ListSessionsHandler handles GET requests for listing the active sessions of a user
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "sessions", true)
+	if !ok {
+		return
+	}
+	utils.ResponseJSON(w, models.GetSessionListResponse(user, getTokenClaims(r).SessionID), true)
+}
+
+// RevokeSessionsHandler handles DELETE requests for logging a user out everywhere
+func RevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "sessions", true)
+	if !ok {
+		return
+	}
+	if err := models.RevokeUserSessions(user); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
+
+// RevokeSessionHandler handles DELETE requests for logging a single session out
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSelf(w, r, "sessions", true)
+	if !ok {
+		return
+	}
+	session, err := models.GetSessionByID(mux.Vars(r)["sessionID"])
+	if err != nil || !session.IsOwnedBy(user) {
+		utils.ResponseProblem(w, models.NewError(models.KindNotFound, "Session not found"))
+		return
+	}
+	if err := session.Revoke(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
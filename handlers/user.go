@@ -1,42 +1,61 @@
 package handlers
 
 import (
-	"encoding/json"
+	"log"
 	"net/http"
+	"strings"
 
+	"github.com/jenarvaezg/magicbox/auth"
 	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/notify"
 	"github.com/jenarvaezg/magicbox/utils"
 )
 
 func getUserRequest(r *http.Request) (models.UserRequest, error) {
 	var userRequest models.UserRequest
-	err := json.NewDecoder(r.Body).Decode(&userRequest)
+	err := decodeRequest(r, &userRequest)
 	return userRequest, err
 }
 
-// ListUsersHandler handles GET requests for listing users in database
+// ListUsersHandler handles GET requests for listing users, admins get every user and others the directory
 func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
-	//TODO filtering
-	utils.ResponseJSON(w, models.GetUserListResponse(), true)
+	current := getCurrentUser(r)
+	if current.Admin && !getTokenClaims(r).IsDelegated() {
+		utils.ResponseJSON(w, models.GetUserListResponse(), true)
+		return
+	}
+	utils.ResponseJSON(w, models.GetUserDirectoryResponse(current, ""), true)
+}
 
+// SearchUsersHandler handles GET requests for finding the users the requesting user can see by username prefix
+func SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("q")
+	if strings.TrimSpace(prefix) == "" {
+		utils.ResponseProblem(w, models.NewError(models.KindBadRequest, "Query parameter q is required"))
+		return
+	}
+	utils.ResponseJSON(w, models.GetUserDirectoryResponse(getCurrentUser(r), prefix), true)
 }
 
 // CreateUserHandler handles POST requests for user creation
 func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	userRequest, err := getUserRequest(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
 	user, err := models.NewUser(userRequest)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 		return
 	}
 	if err := user.Save(); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
 	} else {
+		if !user.IsActive() {
+			sendEmailVerification(*user)
+		}
 		setLocationHeader(w, r, user)
 		utils.ResponseCreated(w)
 	}
@@ -45,32 +64,137 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 // UserDetailHandler handles GET requests for user detail
 func UserDetailHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUser(r)
-	utils.ResponseJSON(w, user.GetResponse(), false)
+	utils.ResponseJSON(w, user.GetResponseFor(getCurrentUser(r), getTokenClaims(r).IsDelegated()), false)
 
 }
 
-// UserDeleteHandler handles GET requests for user detail
+/*
+UserDeleteHandler handles DELETE requests for user deletion, which is scheduled after a grace period. The notes
+query parameter tells whether the notes of the user are anonymized, the default, or deleted
+*/
 func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if refuseDelegated(w, r, "delete accounts") {
+		return
+	}
 	user := getUser(r)
-	if err := user.Delete(); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+	request := models.AccountDeletionRequest{Notes: models.NotePolicy(r.URL.Query().Get("notes"))}
+	deletion, err := models.ScheduleAccountDeletion(user, request)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseAcceptedJSON(w, deletion.GetResponse())
+}
+
+// getManagedUser returns the user of the url if the requesting user can manage them, otherwise it writes a 403
+func getManagedUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, current := getUser(r), getCurrentUser(r)
+	if !current.CanManage(user, getTokenClaims(r).IsDelegated()) {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You can only manage your own user"))
+		return user, false
+	}
+	return user, true
+}
+
+// UserDeletionHandler handles GET requests for the scheduled deletion of a user
+func UserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getManagedUser(w, r)
+	if !ok {
+		return
+	}
+	deletion, err := models.GetActiveAccountDeletion(user)
+	if err != nil {
+		utils.ResponseProblem(w, models.NewError(models.KindConflict, "No account deletion is scheduled"))
+		return
+	}
+	utils.ResponseJSON(w, deletion.GetResponse(), false)
+}
+
+// CancelUserDeletionHandler handles DELETE requests for cancelling the scheduled deletion of a user
+func CancelUserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUser(r)
+	if err := models.CancelAccountDeletion(user); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
 	utils.ResponseNoContent(w)
 }
 
-// UserPatchHandler handles PATCH requests for user updating
+// patchesCredentials returns whether patch changes the password or the email address, where password resets go
+func patchesCredentials(patch models.MergePatch) bool {
+	_, password := patch["password"]
+	_, email := patch["email"]
+	return password || email
+}
+
+// UserPatchHandler handles PATCH requests for user updating, with a JSON merge patch
 func UserPatchHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUser(r)
-	userRequest, err := getUserRequest(r)
+	user, current := getUser(r), getCurrentUser(r)
+	patch, err := decodeMergePatch(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, err)
+		return
+	}
+	if patchesCredentials(patch) && refuseDelegated(w, r, "change the password or the email address of an account") {
 		return
 	}
 
-	if err := user.Update(userRequest); err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+	// Admins changing the password of someone else close every session of that user
+	keepSession := ""
+	if user.GetId() == current.GetId() {
+		keepSession = getTokenClaims(r).SessionID
+	}
+	previousEmail := user.Email
+	if err := user.Patch(patch, keepSession); err != nil {
+		utils.ResponseProblem(w, err)
 		return
 	}
+	if user.Email != previousEmail && !user.IsActive() {
+		sendEmailVerification(user)
+	}
 	utils.ResponseNoContent(w)
 }
+
+// VerifyUserHandler handles POST requests for activating an user with the token sent to their email
+func VerifyUserHandler(w http.ResponseWriter, r *http.Request) {
+	var verificationRequest models.UserVerificationRequest
+	if err := decodeRequest(r, &verificationRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	if _, err := auth.VerifyEmail(verificationRequest.Token); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
+
+// sendEmailVerification emails user a new link to verify their address
+func sendEmailVerification(user models.User) {
+	token, err := auth.NewEmailVerificationToken(user)
+	if err != nil {
+		log.Println("Could not issue email verification token", err)
+		return
+	}
+	notify.EmailVerification(user, token)
+}
+
+/*
+ResendVerificationHandler handles POST requests for sending again the verification email. It answers the same
+whether the email belongs to an inactive user or not, and whether the user asked too often or not, so it can't be
+used to find out registered emails
+*/
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var resendRequest models.UserResendVerificationRequest
+	if err := decodeRequest(r, &resendRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	if user, err := models.GetUserByEmail(resendRequest.Email); err == nil && !user.IsActive() &&
+		auth.AllowVerificationResend(*user) {
+		sendEmailVerification(*user)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/utils"
+	"github.com/jenarvaezg/magicbox/webhooks"
+)
+
+const deliveryLogSize = 50
+
+func getWebhookRequest(r *http.Request) (models.WebhookRequest, error) {
+	var webhookRequest models.WebhookRequest
+	err := decodeRequest(r, &webhookRequest)
+	return webhookRequest, err
+}
+
+// getOwnWebhook returns the webhook in the url, writing a 403 and returning nil if the current user does not own it
+func getOwnWebhook(w http.ResponseWriter, r *http.Request) *models.Webhook {
+	webhook := getWebhook(r)
+	if !webhook.IsOwnedBy(getCurrentUser(r)) {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You are not allowed to access this webhook"))
+		return nil
+	}
+	return webhook
+}
+
+// ListWebhooksHandler handles GET requests for listing the current user's webhooks
+func ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	utils.ResponseJSON(w, models.GetWebhookListResponse(getCurrentUser(r)), true)
+}
+
+// CreateWebhookHandler handles POST requests for webhook creation, the signing secret is only returned here
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhookRequest, err := getWebhookRequest(r)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	webhook := models.NewWebhook(webhookRequest, getCurrentUser(r))
+	if err := webhook.Save(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	setLocationHeader(w, r, webhook)
+	utils.ResponseCreatedJSON(w, models.WebhookCreatedResponse{
+		WebhookResponse: webhook.GetResponse(),
+		Secret:          webhook.Secret,
+	})
+}
+
+// WebhookDetailHandler handles GET requests for webhook detail
+func WebhookDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if webhook := getOwnWebhook(w, r); webhook != nil {
+		utils.ResponseJSON(w, webhook.GetResponse(), false)
+	}
+}
+
+// WebhookPatchHandler handles PATCH requests for webhook updating
+func WebhookPatchHandler(w http.ResponseWriter, r *http.Request) {
+	webhook := getOwnWebhook(w, r)
+	if webhook == nil {
+		return
+	}
+	webhookRequest, err := getWebhookRequest(r)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	if err := webhook.Update(webhookRequest); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
+
+// WebhookDeleteHandler handles DELETE requests for webhook deletion
+func WebhookDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	webhook := getOwnWebhook(w, r)
+	if webhook == nil {
+		return
+	}
+	if err := webhook.Delete(); err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseNoContent(w)
+}
+
+// WebhookTestHandler handles POST requests for sending a ping delivery to a webhook
+func WebhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	webhook := getOwnWebhook(w, r)
+	if webhook == nil {
+		return
+	}
+	delivery, err := webhooks.DefaultDispatcher.SendTest(*webhook)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+	utils.ResponseJSON(w, delivery.GetResponse(), false)
+}
+
+// WebhookDeliveriesHandler handles GET requests for a webhook's delivery log
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if webhook := getOwnWebhook(w, r); webhook != nil {
+		utils.ResponseJSON(w, models.GetWebhookDeliveryListResponse(*webhook, deliveryLogSize), true)
+	}
+}
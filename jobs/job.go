@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Status is a string that determines the state of a job
+type Status string
+
+// Possible states of a job. Dead jobs ran out of attempts or failed permanently and are never retried
+const (
+	StatusPending = Status("pending")
+	StatusRunning = Status("running")
+	StatusDone    = Status("done")
+	StatusDead    = Status("dead")
+)
+
+const defaultMaxAttempts = 5
+
+// bsonDocumentKind is the kind byte of an embedded BSON document
+const bsonDocumentKind = 0x03
+
+// Job is a unit of background work of a given type, its payload is a BSON document
+type Job struct {
+	ID          bson.ObjectId `bson:"_id" json:"id"`
+	Type        string        `bson:"type" json:"type"`
+	Key         string        `bson:"key,omitempty" json:"key,omitempty"`
+	Payload     bson.Raw      `bson:"payload" json:"-"`
+	Status      Status        `bson:"status" json:"status"`
+	Attempts    int           `bson:"attempts" json:"attempts"`
+	MaxAttempts int           `bson:"maxAttempts" json:"maxAttempts"`
+	RunAt       time.Time     `bson:"runAt" json:"runAt"`
+	LockedBy    string        `bson:"lockedBy,omitempty" json:"-"`
+	LockedUntil time.Time     `bson:"lockedUntil,omitempty" json:"-"`
+	LastError   string        `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	Created     time.Time     `bson:"created" json:"created"`
+	Updated     time.Time     `bson:"updated" json:"updated"`
+	// Sensitive jobs have their payload removed once they finish, for payloads holding secrets like mailed tokens
+	Sensitive bool `bson:"sensitive,omitempty" json:"sensitive,omitempty"`
+}
+
+// New returns a pending job of type jobType, due right away, with payload serialized as its payload
+func New(jobType string, payload interface{}) (*Job, error) {
+	data, err := bson.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Job{
+		ID:          bson.NewObjectId(),
+		Type:        jobType,
+		Payload:     bson.Raw{Kind: bsonDocumentKind, Data: data},
+		Status:      StatusPending,
+		MaxAttempts: defaultMaxAttempts,
+		RunAt:       now,
+		Created:     now,
+		Updated:     now,
+	}, nil
+}
+
+// emptyPayload replaces the payload of sensitive jobs that finished
+var emptyPayload = bson.Raw{Kind: bsonDocumentKind, Data: []byte{5, 0, 0, 0, 0}}
+
+// Decode unmarshals the job payload into v
+func (j *Job) Decode(v interface{}) error {
+	return j.Payload.Unmarshal(v)
+}
+
+// IsLastAttempt returns whether a failure of the running attempt would kill the job
+func (j *Job) IsLastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// permanentError wraps errors that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps err so the job that returned it goes straight to the dead state instead of being retried
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
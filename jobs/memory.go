@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps jobs in memory, it is meant for tests and single process development
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	keys map[string]bool
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[string]*Job),
+		keys: make(map[string]bool),
+	}
+}
+
+// Enqueue saves a copy of job
+func (s *MemoryStore) Enqueue(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job.Key != "" {
+		if s.keys[job.Key] {
+			return nil
+		}
+		s.keys[job.Key] = true
+	}
+	stored := *job
+	s.jobs[job.ID.Hex()] = &stored
+	return nil
+}
+
+func isDue(job *Job, types []string, now time.Time) bool {
+	if !containsType(types, job.Type) {
+		return false
+	}
+	switch job.Status {
+	case StatusPending:
+		return !job.RunAt.After(now)
+	case StatusRunning:
+		return !job.LockedUntil.After(now)
+	}
+	return false
+}
+
+func containsType(types []string, jobType string) bool {
+	for _, t := range types {
+		if t == jobType {
+			return true
+		}
+	}
+	return false
+}
+
+// Claim locks the oldest due job of one of types
+func (s *MemoryStore) Claim(types []string, worker string, visibility time.Duration) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var next *Job
+	for _, job := range s.jobs {
+		if isDue(job, types, now) && (next == nil || job.RunAt.Before(next.RunAt)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	next.Status = StatusRunning
+	next.LockedBy = worker
+	next.LockedUntil = now.Add(visibility)
+	next.Attempts++
+	next.Updated = now
+	claimed := *next
+	return &claimed, nil
+}
+
+func (s *MemoryStore) locked(job *Job) (*Job, error) {
+	stored, ok := s.jobs[job.ID.Hex()]
+	if !ok || stored.Status != StatusRunning || stored.LockedBy != job.LockedBy {
+		return nil, ErrLockLost
+	}
+	return stored, nil
+}
+
+func (s *MemoryStore) release(job *Job, status Status, runAt time.Time, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.locked(job)
+	if err != nil {
+		return err
+	}
+	stored.Status = status
+	stored.RunAt = runAt
+	stored.LockedBy = ""
+	stored.LockedUntil = time.Time{}
+	stored.Updated = time.Now()
+	if cause != nil {
+		stored.LastError = cause.Error()
+	}
+	if stored.Sensitive && (status == StatusDone || status == StatusDead) {
+		stored.Payload = emptyPayload
+	}
+	*job = *stored
+	return nil
+}
+
+// Complete marks a claimed job as done
+func (s *MemoryStore) Complete(job *Job) error {
+	return s.release(job, StatusDone, job.RunAt, nil)
+}
+
+// Retry releases a claimed job so it runs again at runAt
+func (s *MemoryStore) Retry(job *Job, runAt time.Time, cause error) error {
+	return s.release(job, StatusPending, runAt, cause)
+}
+
+// Bury moves a claimed job to the dead state
+func (s *MemoryStore) Bury(job *Job, cause error) error {
+	return s.release(job, StatusDead, job.RunAt, cause)
+}
+
+// Get returns a copy of a job by id
+func (s *MemoryStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.jobs[id]
+	if !ok {
+		return nil, errors.New("Job not found")
+	}
+	job := *stored
+	return &job, nil
+}
+
+// Prune deletes the jobs left in status, done or dead, since before
+func (s *MemoryStore) Prune(status Status, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.Status == status && job.Updated.Before(before) {
+			delete(s.jobs, id)
+			delete(s.keys, job.Key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const testJobType = "test.job"
+
+func newTestJob(t *testing.T, jobType string, runAt time.Time) *Job {
+	job, err := New(jobType, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.RunAt = runAt
+	return job
+}
+
+func enqueue(t *testing.T, store Store, jobs ...*Job) {
+	for _, job := range jobs {
+		if err := store.Enqueue(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func claim(t *testing.T, store Store, worker string, visibility time.Duration) *Job {
+	job, err := store.Claim([]string{testJobType}, worker, visibility)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestMemoryStoreClaim(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	older := newTestJob(t, testJobType, now.Add(-time.Minute))
+	newer := newTestJob(t, testJobType, now.Add(-time.Second))
+	enqueue(t, store, newer, older,
+		newTestJob(t, testJobType, now.Add(time.Hour)),
+		newTestJob(t, "other.job", now.Add(-time.Hour)),
+	)
+
+	for _, want := range []*Job{older, newer} {
+		job := claim(t, store, "worker", time.Minute)
+		if job == nil || job.ID != want.ID {
+			t.Fatalf("Claim() returned %v, want the oldest due job %s", job, want.ID.Hex())
+		}
+		if job.Status != StatusRunning || job.LockedBy != "worker" || job.Attempts != 1 {
+			t.Errorf("Claim() returned %+v, want it running, locked by the worker, on its first attempt", job)
+		}
+	}
+	if job := claim(t, store, "worker", time.Minute); job != nil {
+		t.Fatalf("Claim() returned %+v, which isn't due or is of another type", job)
+	}
+}
+
+func TestMemoryStoreEnqueueKey(t *testing.T) {
+	store := NewMemoryStore()
+	first, second := newTestJob(t, testJobType, time.Now()), newTestJob(t, testJobType, time.Now())
+	first.Key, second.Key = "key", "key"
+	enqueue(t, store, first, second)
+
+	if _, err := store.Get(second.ID.Hex()); err == nil {
+		t.Error("Enqueue() saved a job whose key was taken")
+	}
+}
+
+func TestMemoryStoreReclaimAfterVisibilityTimeout(t *testing.T) {
+	store := NewMemoryStore()
+	enqueue(t, store, newTestJob(t, testJobType, time.Now()))
+	lost := claim(t, store, "crashed", time.Millisecond)
+	if job := claim(t, store, "worker", time.Minute); job != nil {
+		t.Fatal("Claim() returned a job whose visibility timeout hasn't expired")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	job := claim(t, store, "worker", time.Minute)
+	if job == nil || job.ID != lost.ID || job.Attempts != 2 {
+		t.Fatalf("Claim() returned %+v, want the job whose visibility timeout expired on its second attempt", job)
+	}
+	if err := store.Complete(lost); err != ErrLockLost {
+		t.Errorf("Complete() by the worker which lost the job returned %v, want ErrLockLost", err)
+	}
+	if err := store.Complete(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingPool returns a pool whose handler of test jobs fails with err
+func failingPool(store Store, err error) *Pool {
+	pool := NewPool(store, 1)
+	pool.BaseBackoff, pool.MaxBackoff = time.Minute, time.Hour
+	pool.Handle(testJobType, func(*Job) error {
+		return err
+	})
+	return pool
+}
+
+func TestPoolRetriesWithBackoff(t *testing.T) {
+	store := NewMemoryStore()
+	pool := failingPool(store, errors.New("temporary failure"))
+	job := newTestJob(t, testJobType, time.Now())
+	job.MaxAttempts = 3
+	enqueue(t, store, job)
+
+	for attempt, backoff := range []time.Duration{time.Minute, 2 * time.Minute} {
+		claimed := claim(t, store, "worker", time.Minute)
+		if claimed == nil {
+			t.Fatalf("Claim() returned no job for attempt %d", attempt+1)
+		}
+		before := time.Now()
+		pool.process(claimed)
+
+		stored, err := store.Get(job.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != StatusPending || stored.LastError != "temporary failure" {
+			t.Fatalf("Job is %s with the error %q after failing, want it pending with the error", stored.Status,
+				stored.LastError)
+		}
+		if stored.RunAt.Before(before.Add(backoff)) || stored.RunAt.After(time.Now().Add(backoff)) {
+			t.Errorf("Job runs again at %s after attempt %d, want %s later", stored.RunAt, attempt+1, backoff)
+		}
+		if claim(t, store, "worker", time.Minute) != nil {
+			t.Fatal("Claim() returned a job before its backoff")
+		}
+		// Makes the job due again without waiting for its backoff
+		store.jobs[job.ID.Hex()].RunAt = time.Now()
+	}
+
+	pool.process(claim(t, store, "worker", time.Minute))
+	if stored, _ := store.Get(job.ID.Hex()); stored.Status != StatusDead || stored.Attempts != 3 {
+		t.Errorf("Job is %s after %d attempts, want it dead after 3", stored.Status, stored.Attempts)
+	}
+}
+
+func TestPoolBuriesPermanentFailures(t *testing.T) {
+	store := NewMemoryStore()
+	pool := failingPool(store, Permanent(errors.New("bad payload")))
+	job := newTestJob(t, testJobType, time.Now())
+	enqueue(t, store, job)
+
+	pool.process(claim(t, store, "worker", time.Minute))
+	if stored, _ := store.Get(job.ID.Hex()); stored.Status != StatusDead || stored.Attempts != 1 {
+		t.Errorf("Job is %s after %d attempts, want it dead after the first one", stored.Status, stored.Attempts)
+	}
+}
+
+func TestPoolRecoversPanics(t *testing.T) {
+	store := NewMemoryStore()
+	pool := NewPool(store, 1)
+	pool.Handle(testJobType, func(*Job) error {
+		panic("bad job")
+	})
+	job := newTestJob(t, testJobType, time.Now())
+	enqueue(t, store, job)
+
+	pool.process(claim(t, store, "worker", time.Minute))
+	if stored, _ := store.Get(job.ID.Hex()); stored.Status != StatusPending || stored.LastError == "" {
+		t.Errorf("Job is %s with the error %q after panicking, want it retried", stored.Status, stored.LastError)
+	}
+}
+
+func TestPoolBackoff(t *testing.T) {
+	pool := NewPool(NewMemoryStore(), 1)
+	pool.BaseBackoff, pool.MaxBackoff = time.Second, 10*time.Second
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second,
+		5: 10 * time.Second, 30: 10 * time.Second} {
+		if backoff := pool.Backoff(attempts); backoff != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempts, backoff, want)
+		}
+	}
+}
+
+func TestSensitivePayloadIsRemoved(t *testing.T) {
+	store := NewMemoryStore()
+	job, err := New(testJobType, struct {
+		Token string `bson:"token"`
+	}{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Sensitive = true
+	enqueue(t, store, job)
+
+	if err := store.Complete(claim(t, store, "worker", time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.Get(job.ID.Hex())
+	var payload map[string]interface{}
+	if err := stored.Decode(&payload); err != nil || len(payload) != 0 {
+		t.Errorf("Done sensitive job kept the payload %v", payload)
+	}
+}
@@ -0,0 +1,145 @@
+package jobs
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoStore is a Store backed by a mongo collection, safe to share between server replicas
+type MongoStore struct {
+	session    *mgo.Session
+	database   string
+	collection string
+}
+
+// NewMongoStore returns a MongoStore using collection, creating the indexes it needs
+func NewMongoStore(collection *mgo.Collection) (*MongoStore, error) {
+	s := &MongoStore{
+		session:    collection.Database.Session,
+		database:   collection.Database.Name,
+		collection: collection.Name,
+	}
+	c, closer := s.c()
+	defer closer()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"key"}, Unique: true, Sparse: true}); err != nil {
+		return nil, err
+	}
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"status", "type", "runAt"}}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// c returns the collection on a copy of the session, per mgo's recommendation, and a func to close it
+func (s *MongoStore) c() (*mgo.Collection, func()) {
+	sess := s.session.Copy()
+	return sess.DB(s.database).C(s.collection), sess.Close
+}
+
+// Enqueue inserts job, ignoring duplicated keys
+func (s *MongoStore) Enqueue(job *Job) error {
+	c, closer := s.c()
+	defer closer()
+	err := c.Insert(job)
+	if err != nil && job.Key != "" && mgo.IsDup(err) {
+		return nil
+	}
+	return err
+}
+
+// Claim atomically locks the oldest due job of one of types using findAndModify
+func (s *MongoStore) Claim(types []string, worker string, visibility time.Duration) (*Job, error) {
+	c, closer := s.c()
+	defer closer()
+	now := time.Now()
+	query := bson.M{
+		"type": bson.M{"$in": types},
+		"$or": []bson.M{
+			{"status": StatusPending, "runAt": bson.M{"$lte": now}},
+			{"status": StatusRunning, "lockedUntil": bson.M{"$lte": now}},
+		},
+	}
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"status": StatusRunning, "lockedBy": worker, "lockedUntil": now.Add(visibility), "updated": now},
+			"$inc": bson.M{"attempts": 1},
+		},
+		ReturnNew: true,
+	}
+	job := &Job{}
+	_, err := c.Find(query).Sort("runAt").Apply(change, job)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// release updates a job only if worker still holds its lock
+func (s *MongoStore) release(job *Job, update bson.M) error {
+	c, closer := s.c()
+	defer closer()
+	update["updated"] = time.Now()
+	selector := bson.M{"_id": job.ID, "status": StatusRunning, "lockedBy": job.LockedBy}
+	err := c.Update(selector, bson.M{
+		"$set":   update,
+		"$unset": bson.M{"lockedBy": "", "lockedUntil": ""},
+	})
+	if err == mgo.ErrNotFound {
+		return ErrLockLost
+	}
+	return err
+}
+
+// finish returns the update of a job that finished with status
+func finish(job *Job, status Status) bson.M {
+	update := bson.M{"status": status}
+	if job.Sensitive {
+		update["payload"] = emptyPayload
+	}
+	return update
+}
+
+// Complete marks a claimed job as done
+func (s *MongoStore) Complete(job *Job) error {
+	return s.release(job, finish(job, StatusDone))
+}
+
+// Retry releases a claimed job so it runs again at runAt
+func (s *MongoStore) Retry(job *Job, runAt time.Time, cause error) error {
+	return s.release(job, bson.M{"status": StatusPending, "runAt": runAt, "lastError": cause.Error()})
+}
+
+// Bury moves a claimed job to the dead state
+func (s *MongoStore) Bury(job *Job, cause error) error {
+	update := finish(job, StatusDead)
+	update["lastError"] = cause.Error()
+	return s.release(job, update)
+}
+
+// Get returns a job by id
+func (s *MongoStore) Get(id string) (*Job, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errors.New("Job not found")
+	}
+	c, closer := s.c()
+	defer closer()
+	job := &Job{}
+	if err := c.FindId(bson.ObjectIdHex(id)).One(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Prune deletes the jobs left in status, done or dead, since before
+func (s *MongoStore) Prune(status Status, before time.Time) error {
+	c, closer := s.c()
+	defer closer()
+	_, err := c.RemoveAll(bson.M{"status": status, "updated": bson.M{"$lt": before}})
+	return err
+}
@@ -0,0 +1,207 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Handler runs a job. Returning an error retries the job with backoff, unless it was wrapped with Permanent
+type Handler func(job *Job) error
+
+type schedule struct {
+	jobType  string
+	interval time.Duration
+}
+
+// Pool is a set of workers that claim jobs from a Store and run them with the registered handlers
+type Pool struct {
+	Store        Store
+	Workers      int
+	PollInterval time.Duration
+	// Visibility is how long a claimed job stays hidden from other workers, it must exceed the longest job run
+	Visibility  time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Retention is how long done jobs are kept before being pruned
+	Retention time.Duration
+	// DeadRetention is how long dead jobs are kept before being pruned, longer so failures can be looked into
+	DeadRetention time.Duration
+
+	handlers  map[string]Handler
+	schedules []schedule
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewPool returns a Pool of workers claiming jobs from store
+func NewPool(store Store, workers int) *Pool {
+	return &Pool{
+		Store:         store,
+		Workers:       workers,
+		PollInterval:  time.Second,
+		Visibility:    5 * time.Minute,
+		BaseBackoff:   30 * time.Second,
+		MaxBackoff:    6 * time.Hour,
+		Retention:     24 * time.Hour,
+		DeadRetention: 7 * 24 * time.Hour,
+		handlers:      make(map[string]Handler),
+	}
+}
+
+// Handle registers handler for jobs of type jobType, it must be called before Start
+func (p *Pool) Handle(jobType string, handler Handler) {
+	p.handlers[jobType] = handler
+}
+
+/*
+Every enqueues a payload-less job of type jobType once per interval. Each period has its own job key, so
+when several replicas run the same schedule the job is still enqueued only once
+*/
+func (p *Pool) Every(jobType string, interval time.Duration) {
+	p.schedules = append(p.schedules, schedule{jobType, interval})
+}
+
+// Backoff returns how long to wait before retrying a job that has failed attempts times
+func (p *Pool) Backoff(attempts int) time.Duration {
+	backoff := p.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+func (p *Pool) types() []string {
+	types := make([]string, 0, len(p.handlers))
+	for jobType := range p.handlers {
+		types = append(types, jobType)
+	}
+	return types
+}
+
+// run calls handler, turning a panic into an error so a bad job can't take the worker down
+func run(handler Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Job panicked: %v", r)
+		}
+	}()
+	return handler(job)
+}
+
+func (p *Pool) process(job *Job) {
+	err := run(p.handlers[job.Type], job)
+	switch {
+	case err == nil:
+		err = p.Store.Complete(job)
+	case isPermanent(err) || job.IsLastAttempt():
+		log.Printf("Job %s %s is dead: %s", job.Type, job.ID.Hex(), err)
+		err = p.Store.Bury(job, err)
+	default:
+		err = p.Store.Retry(job, time.Now().Add(p.Backoff(job.Attempts)), err)
+	}
+	if err != nil {
+		log.Printf("Could not record result of job %s %s: %s", job.Type, job.ID.Hex(), err)
+	}
+}
+
+func (p *Pool) work(worker string, types []string) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			job, err := p.Store.Claim(types, worker, p.Visibility)
+			if err != nil {
+				log.Println("Could not claim job", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			p.process(job)
+			select {
+			case <-p.stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) enqueueScheduled(s schedule, now time.Time) {
+	job, _ := New(s.jobType, struct{}{})
+	period := now.Truncate(s.interval)
+	job.Key = fmt.Sprintf("%s@%d", s.jobType, period.Unix())
+	job.RunAt = period
+	if err := p.Store.Enqueue(job); err != nil {
+		log.Println("Could not enqueue scheduled job", s.jobType, err)
+	}
+}
+
+func (p *Pool) schedule(s schedule) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		p.enqueueScheduled(s, time.Now())
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) prune() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := p.Store.Prune(StatusDone, now.Add(-p.Retention)); err != nil {
+				log.Println("Could not prune done jobs", err)
+			}
+			if err := p.Store.Prune(StatusDead, now.Add(-p.DeadRetention)); err != nil {
+				log.Println("Could not prune dead jobs", err)
+			}
+		}
+	}
+}
+
+// Start launches the workers, the schedules and the pruning of done and dead jobs
+func (p *Pool) Start() {
+	p.stop = make(chan struct{})
+	host, _ := os.Hostname()
+	types := p.types()
+	for i := 0; i < p.Workers; i++ {
+		p.wg.Add(1)
+		go p.work(fmt.Sprintf("%s-%d-%d", host, os.Getpid(), i), types)
+	}
+	for _, s := range p.schedules {
+		p.wg.Add(1)
+		go p.schedule(s)
+	}
+	p.wg.Add(1)
+	go p.prune()
+}
+
+// Stop signals every goroutine of the pool to exit and waits for the running jobs to finish
+func (p *Pool) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
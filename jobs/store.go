@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLockLost is returned when a worker reports on a job whose visibility timeout expired and was claimed again
+var ErrLockLost = errors.New("Job lock was lost")
+
+/*
+Store persists jobs. Implementations must make Claim atomic, so a job is only handed to one worker at a time
+even when several server replicas share the store
+*/
+type Store interface {
+	// Enqueue saves a new job. If the job has a Key and a job with the same key was already enqueued, it's a no-op
+	Enqueue(job *Job) error
+	// Claim locks the next due job of one of types for worker during visibility and returns it, or nil if none is due.
+	// Running jobs whose visibility timeout expired are due again
+	Claim(types []string, worker string, visibility time.Duration) (*Job, error)
+	// Complete marks a claimed job as done
+	Complete(job *Job) error
+	// Retry releases a claimed job so it runs again at runAt
+	Retry(job *Job, runAt time.Time, cause error) error
+	// Bury moves a claimed job to the dead state
+	Bury(job *Job, cause error) error
+	// Get returns a job by id
+	Get(id string) (*Job, error)
+	// Prune deletes the jobs left in status, done or dead, since before
+	Prune(status Status, before time.Time) error
+}
+
+// DefaultStore is the store used by Enqueue, it is set up at server start
+var DefaultStore Store = NewMemoryStore()
+
+// Enqueue creates a job of type jobType with payload and saves it into DefaultStore
+func Enqueue(jobType string, payload interface{}) error {
+	job, err := New(jobType, payload)
+	if err != nil {
+		return err
+	}
+	return DefaultStore.Enqueue(job)
+}
+
+// EnqueueSensitive is like Enqueue, but the payload is removed from the store as soon as the job finishes
+func EnqueueSensitive(jobType string, payload interface{}) error {
+	job, err := New(jobType, payload)
+	if err != nil {
+		return err
+	}
+	job.Sensitive = true
+	return DefaultStore.Enqueue(job)
+}
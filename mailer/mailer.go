@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"log"
+	"os"
+)
+
+// Message is an email with a plain text and an HTML alternative
+type Message struct {
+	To      string `bson:"to"`
+	Subject string `bson:"subject"`
+	Text    string `bson:"text"`
+	HTML    string `bson:"html"`
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(message Message) error
+}
+
+const defaultOutboxDir = "outbox"
+
+// DefaultMailer is the mailer used by the server
+var DefaultMailer = FromEnv()
+
+/*
+FromEnv returns an SMTPMailer if SMTP_HOST is set, otherwise an OutboxMailer writing into MAIL_OUTBOX
+(or ./outbox) so development setups don't need a mail server
+*/
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "MagicBox <no-reply@magicbox.local>"
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "25"
+		}
+		log.Println("Sending mail through SMTP server", host)
+		return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	}
+	dir := os.Getenv("MAIL_OUTBOX")
+	if dir == "" {
+		dir = defaultOutboxDir
+	}
+	log.Println("Writing mail to outbox", dir)
+	return NewOutboxMailer(dir, from)
+}
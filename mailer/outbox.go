@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OutboxMailer writes every message as an .eml file into a directory instead of sending it
+type OutboxMailer struct {
+	Dir  string
+	From string
+}
+
+// NewOutboxMailer returns an OutboxMailer writing into dir
+func NewOutboxMailer(dir, from string) *OutboxMailer {
+	return &OutboxMailer{Dir: dir, From: from}
+}
+
+// Send writes message into the outbox directory
+func (m *OutboxMailer) Send(message Message) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), newBoundary()[:8])
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), render(m.From, message), 0644)
+}
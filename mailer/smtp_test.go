@@ -0,0 +1,151 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// envelope is what a stand-in SMTP server received in one session
+type envelope struct {
+	from string
+	to   []string
+	data []byte
+}
+
+/*
+serveSMTP accepts one session on a loopback listener and speaks enough SMTP for net/smtp to deliver a message,
+answering RCPT with rcptReply. It returns the address of the listener and a channel which gets the envelope once
+the session ends
+*/
+func serveSMTP(t *testing.T, rcptReply string) (string, <-chan envelope) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan envelope, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c := textproto.NewConn(conn)
+		defer c.Close()
+		var e envelope
+		defer func() { received <- e }()
+
+		c.PrintfLine("220 localhost stand-in")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch {
+			case verb == "EHLO" || verb == "HELO":
+				c.PrintfLine("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				e.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				c.PrintfLine("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				e.to = append(e.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				c.PrintfLine("%s", rcptReply)
+			case verb == "DATA":
+				c.PrintfLine("354 Go ahead")
+				if e.data, err = c.ReadDotBytes(); err != nil {
+					return
+				}
+				c.PrintfLine("250 OK")
+			case verb == "QUIT":
+				c.PrintfLine("221 Bye")
+				return
+			default:
+				c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func newTestSMTPMailer(t *testing.T, addr string) *SMTPMailer {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSMTPMailer(host, port, "", "", "MagicBox <no-reply@magicbox.local>")
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, received := serveSMTP(t, "250 OK")
+	message := Message{
+		To:      "someone@example.com",
+		Subject: `"Cápsula" is open!`,
+		Text:    "The box has just opened, a line long enough to be wrapped by quoted-printable encoding at 76 characters",
+		HTML:    "<p>The box has just <b>opened</b></p>",
+	}
+
+	if err := newTestSMTPMailer(t, addr).Send(message); err != nil {
+		t.Fatal(err)
+	}
+	e := <-received
+	if e.from != "no-reply@magicbox.local" || len(e.to) != 1 || e.to[0] != message.To {
+		t.Errorf("Send() used the envelope from %q to %v, want from no-reply@magicbox.local to %s", e.from, e.to,
+			message.To)
+	}
+
+	email, err := mail.ReadMessage(strings.NewReader(string(e.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject"))
+	if err != nil || subject != message.Subject {
+		t.Errorf("Send() sent the subject %q, want %q", subject, message.Subject)
+	}
+	if to := email.Header.Get("To"); to != message.To {
+		t.Errorf("Send() sent the message to %q, want %q", to, message.To)
+	}
+	mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Send() sent a message of type %q, want multipart/alternative", mediaType)
+	}
+
+	parts := multipart.NewReader(email.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain", message.Text},
+		{"text/html", message.HTML},
+	} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType := part.Header.Get("Content-Type"); !strings.HasPrefix(contentType, want.contentType) {
+			t.Errorf("Send() sent a part of type %q, want %s", contentType, want.contentType)
+		}
+		if string(body) != want.body {
+			t.Errorf("Send() sent the %s part %q, want %q", want.contentType, body, want.body)
+		}
+	}
+}
+
+func TestSMTPMailerSendRefused(t *testing.T) {
+	addr, received := serveSMTP(t, "550 No such user")
+
+	err := newTestSMTPMailer(t, addr).Send(Message{To: "nobody@example.com", Subject: "Hi", Text: "Hi"})
+	if err == nil {
+		t.Fatal("Send() to a recipient the server refused returned no error")
+	}
+	if e := <-received; e.data != nil {
+		t.Errorf("Send() sent the message %q after the recipient was refused", e.data)
+	}
+}
@@ -0,0 +1,135 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// Names of the available templates
+const (
+	TemplateBoxOpened        = "box-opened"
+	TemplateInvitation       = "invitation"
+	TemplateDeadlineReminder = "deadline-reminder"
+	TemplateVerifyEmail      = "verify-email"
+	TemplatePasswordReset    = "password-reset"
+)
+
+// template holds the three renderings of an email, subject and text are plain text while html is escaped
+type template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+const htmlLayout = `{{define "layout"}}<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #333;">
+{{template "content" .}}
+<p style="color: #999; font-size: small;">You can choose which emails MagicBox sends you in your profile settings.</p>
+</body>
+</html>{{end}}`
+
+var templates = map[string]template{
+	TemplateBoxOpened: newTemplate(
+		`"{{.Box}}" is open!`,
+		`Hi {{.Name}},
+
+The box "{{.Box}}" has just opened. Go read what everyone wrote:
+
+{{.URL}}
+`,
+		`<p>Hi {{.Name}},</p>
+<p>The box <strong>{{.Box}}</strong> has just opened. Go read what everyone wrote!</p>
+<p><a href="{{.URL}}">Open the box</a></p>`,
+	),
+	TemplateInvitation: newTemplate(
+		`{{.Inviter}} invited you to "{{.Box}}"`,
+		`Hi {{.Name}},
+
+{{.Inviter}} invited you to the box "{{.Box}}", which opens on {{.OpenDate}}.
+Join it and leave your notes before then:
+
+{{.URL}}
+`,
+		`<p>Hi {{.Name}},</p>
+<p>{{.Inviter}} invited you to the box <strong>{{.Box}}</strong>, which opens on {{.OpenDate}}.
+Join it and leave your notes before then.</p>
+<p><a href="{{.URL}}">See the invitation</a></p>`,
+	),
+	TemplateDeadlineReminder: newTemplate(
+		`"{{.Box}}" is waiting for your note`,
+		`Hi {{.Name}},
+
+You haven't written anything in the box "{{.Box}}" yet. It opens on {{.OpenDate}},
+after that no more notes can be added.
+
+{{.URL}}
+`,
+		`<p>Hi {{.Name}},</p>
+<p>You haven't written anything in the box <strong>{{.Box}}</strong> yet. It opens on {{.OpenDate}},
+after that no more notes can be added.</p>
+<p><a href="{{.URL}}">Write a note</a></p>`,
+	),
+	TemplateVerifyEmail: newTemplate(
+		`Verify your MagicBox email`,
+		`Hi {{.Name}},
+
+Please confirm this is your email address by following this link in the next 48 hours:
+
+{{.URL}}
+
+If you didn't sign up for MagicBox, ignore this email.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>Please confirm this is your email address. The link is valid for 48 hours.</p>
+<p><a href="{{.URL}}">Verify my email</a></p>
+<p>If you didn't sign up for MagicBox, ignore this email.</p>`,
+	),
+	TemplatePasswordReset: newTemplate(
+		`Reset your MagicBox password`,
+		`Hi {{.Name}},
+
+Somebody asked to reset the password of your MagicBox account. If it was you, follow this link
+in the next hour to choose a new one:
+
+{{.URL}}
+
+If it wasn't you, ignore this email, your password won't change.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>Somebody asked to reset the password of your MagicBox account. If it was you, choose a new one
+in the next hour.</p>
+<p><a href="{{.URL}}">Reset my password</a></p>
+<p>If it wasn't you, ignore this email, your password won't change.</p>`,
+	),
+}
+
+func newTemplate(subject, text, html string) template {
+	layout := htmltemplate.Must(htmltemplate.New("layout").Parse(htmlLayout))
+	return template{
+		subject: texttemplate.Must(texttemplate.New("subject").Parse(subject)),
+		text:    texttemplate.Must(texttemplate.New("text").Parse(text)),
+		html:    htmltemplate.Must(layout.New("content").Parse(html)),
+	}
+}
+
+// Render returns a message to to built from template name and data
+func Render(name, to string, data interface{}) (Message, error) {
+	t, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("Unknown mail template %q", name)
+	}
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
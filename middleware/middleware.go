@@ -2,17 +2,22 @@ package middleware
 
 import (
 	"context"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"regexp"
 	"strings"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"github.com/jenarvaezg/magicbox/auth"
 	"github.com/jenarvaezg/magicbox/models"
 	"github.com/jenarvaezg/magicbox/utils"
 )
 
+// RequestIDMiddleware is a middleware that gives every request an id, which error responses and logs refer to
+type RequestIDMiddleware struct {
+}
+
 // RequireJSONMiddleware is a struct that has a ServeHTTP method
 type RequireJSONMiddleware struct {
 }
@@ -25,10 +30,31 @@ type RequireBoxMiddleware struct {
 type RequireUserMiddleware struct {
 }
 
+// RequireWebhookMiddleware is a middleware that ensures a url's id parameter is a valid ID related to a Webhook document
+type RequireWebhookMiddleware struct {
+}
+
+// RequireAPIClientMiddleware is a middleware that ensures a url's id parameter is a valid ID related to an APIClient document
+type RequireAPIClientMiddleware struct {
+}
+
+// RequireUserOwnerMiddleware is a middleware that ensures a user in the url is only modified by themselves or an admin
+type RequireUserOwnerMiddleware struct {
+}
+
+// RequireAdminMiddleware is a middleware that ensures the requesting user is an admin
+type RequireAdminMiddleware struct {
+}
+
 //UserFromJWTMiddleware is a middleware that varifies a JWT in the Authorization header and sets the user in the conext
 type UserFromJWTMiddleware struct {
 }
 
+// NewRequestIDMiddleware returns a RequestIDMiddleware
+func NewRequestIDMiddleware() *RequestIDMiddleware {
+	return &RequestIDMiddleware{}
+}
+
 // NewRequireJSONMiddleware returns a RequireJSONMiddleware
 func NewRequireJSONMiddleware() *RequireJSONMiddleware {
 	return &RequireJSONMiddleware{}
@@ -44,11 +70,48 @@ func NewRequireUserMiddleware() *RequireUserMiddleware {
 	return &RequireUserMiddleware{}
 }
 
+// NewRequireWebhookMiddleware returns a RequireWebhookMiddleware
+func NewRequireWebhookMiddleware() *RequireWebhookMiddleware {
+	return &RequireWebhookMiddleware{}
+}
+
+// NewRequireAPIClientMiddleware returns a RequireAPIClientMiddleware
+func NewRequireAPIClientMiddleware() *RequireAPIClientMiddleware {
+	return &RequireAPIClientMiddleware{}
+}
+
+// NewRequireUserOwnerMiddleware returns a RequireUserOwnerMiddleware
+func NewRequireUserOwnerMiddleware() *RequireUserOwnerMiddleware {
+	return &RequireUserOwnerMiddleware{}
+}
+
+// NewRequireAdminMiddleware returns a RequireAdminMiddleware
+func NewRequireAdminMiddleware() *RequireAdminMiddleware {
+	return &RequireAdminMiddleware{}
+}
+
 // NewUserFromJWTMiddleware returns a RequireUserMiddleware
 func NewUserFromJWTMiddleware() *UserFromJWTMiddleware {
 	return &UserFromJWTMiddleware{}
 }
 
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9\-_.]{1,64}$`)
+
+/*
+RequestIDMiddleware's handler, which keeps the id a proxy in front of us set in the X-Request-ID header or else
+generates one, and sets it in the response headers and the context
+*/
+func (l *RequestIDMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(utils.RequestIDHeader)
+	if !requestIDRegexp.MatchString(id) {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	w.Header().Set(utils.RequestIDHeader, id)
+	next(w, r.WithContext(context.WithValue(r.Context(), utils.ContextKeyRequestID, id)))
+}
+
 /*
 RequireJSONMiddleware's handler, which asserts that POST and PUT methods include content-type header
 and is set to application/json
@@ -58,7 +121,7 @@ func (l *RequireJSONMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request
 		return method == "POST" || method == "PUT"
 	}
 	if methodNeedsJSON(r.Method) && r.Header.Get("content-type") != "application/json" {
-		utils.ResponseError(w, "Expected content-type to be application/json", http.StatusBadRequest)
+		utils.ResponseProblem(w, errNotJSON)
 	} else {
 		next(w, r)
 	}
@@ -77,7 +140,7 @@ document in the database
 func (l *RequireBoxMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	box, err := getBox(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusNotFound)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
@@ -99,7 +162,7 @@ document in the database
 func (l *RequireUserMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	user, err := getUser(r)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusNotFound)
+		utils.ResponseProblem(w, err)
 		return
 	}
 
@@ -108,59 +171,179 @@ func (l *RequireUserMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request
 	next(w, r)
 }
 
+func getWebhook(r *http.Request) (models.Webhook, error) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	return models.GetWebhookByID(id)
+}
+
+/*
+RequireWebhookMiddleware's handler, which asserts that url's id parameter is a valid ID and is related to a Webhook
+document in the database
+*/
+func (l *RequireWebhookMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	webhook, err := getWebhook(r)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), utils.ContextKeyWebhook, webhook))
+
+	next(w, r)
+}
+
+func getAPIClient(r *http.Request) (models.APIClient, error) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	return models.GetAPIClientByID(id)
+}
+
+/*
+RequireAPIClientMiddleware's handler, which asserts that url's id parameter is a valid ID and is related to an
+APIClient document in the database
+*/
+func (l *RequireAPIClientMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	client, err := getAPIClient(r)
+	if err != nil {
+		utils.ResponseProblem(w, err)
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), utils.ContextKeyAPIClient, client))
+
+	next(w, r)
+}
+
+// isReadOnly returns whether r only reads, as opposed to modifying what it is sent to
+func isReadOnly(r *http.Request) bool {
+	return r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+}
+
+/*
+RequireUserOwnerMiddleware's handler, which asserts that requests modifying the user of the url, or anything
+under it, are sent by that same user or by an admin. It must run after RequireUserMiddleware and
+UserFromJWTMiddleware
+*/
+func (l *RequireUserOwnerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if isReadOnly(r) {
+		next(w, r)
+		return
+	}
+	user := r.Context().Value(utils.ContextKeyUser).(models.User)
+	current := r.Context().Value(utils.ContextKeyCurrentUser).(models.User)
+	claims := r.Context().Value(utils.ContextKeyTokenClaims).(*auth.TokenClaims)
+	if !current.CanManage(user, claims.IsDelegated()) {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "You can only modify your own user"))
+		return
+	}
+	next(w, r)
+}
+
+/*
+RequireAdminMiddleware's handler, which asserts that the user of the requesting token is an admin. Delegated tokens
+are refused even if their owner is one, it must run after UserFromJWTMiddleware
+*/
+func (l *RequireAdminMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	user := r.Context().Value(utils.ContextKeyCurrentUser).(models.User)
+	claims := r.Context().Value(utils.ContextKeyTokenClaims).(*auth.TokenClaims)
+	if !user.Admin || claims.IsDelegated() {
+		utils.ResponseProblem(w, models.NewError(models.KindForbidden, "Only admins can do this"))
+		return
+	}
+	next(w, r)
+}
+
+/*
+Errors answered to requests whose token can't be used. They don't tell why a token is invalid, the reasons given
+by the JWT parser or the database are of no use to clients and only help whoever forges tokens
+*/
+var (
+	errMissingToken     = models.NewError(models.KindUnauthorized, "Missing Authorization header")
+	errMalformedHeader  = models.NewError(models.KindUnauthorized, "Authorization header format must be Bearer {token}")
+	errInvalidToken     = models.NewError(models.KindUnauthorized, "Invalid or expired token")
+	errUnknownTokenUser = models.NewError(models.KindUnauthorized, "The user of the token no longer exists")
+	errRevokedToken     = models.NewError(models.KindUnauthorized, "Token has been revoked")
+	errSuspended        = models.NewError(models.KindForbidden, "Account is suspended")
+)
+
+var errNotJSON = models.NewError(models.KindUnsupportedMediaType, "Expected content-type to be application/json")
+
 func extractJWTFromHeader(authHeader string) (string, error) {
 	if authHeader == "" {
-		return "", errors.New("Missing Authorization header") // No error, just no token
+		return "", errMissingToken
 	}
 
 	authHeaderParts := strings.Split(authHeader, " ")
 	if len(authHeaderParts) != 2 || strings.ToLower(authHeaderParts[0]) != "bearer" {
-		return "", errors.New("Authorization header format must be Bearer {token}")
+		return "", errMalformedHeader
 	}
 
 	return authHeaderParts[1], nil
 }
 
-func getTokenClaims(tokenString string) (*auth.TokenClaims, error) {
-	keyFunc := func(t *jwt.Token) (interface{}, error) {
-		return []byte("AllYourBase"), nil
-	}
-
-	claims := &auth.TokenClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
-	claims, _ = token.Claims.(*auth.TokenClaims)
-	return claims, err
+// publicRequests are the POST routes that can be used without being logged in
+var publicRequests = map[string]bool{
+	"/api/v1/user":                        true,
+	"/api/v1/user/verify":                 true,
+	"/api/v1/user/verify/resend":          true,
+	"/api/v1/user/password/reset":         true,
+	"/api/v1/user/password/reset/confirm": true,
 }
 
-func isCreateUserRequest(r *http.Request) bool {
-	return r.Method == "POST" && r.URL.RequestURI() == "/api/v1/user"
+func isPublicRequest(r *http.Request) bool {
+	return r.Method == "POST" && publicRequests[r.URL.RequestURI()]
 }
 
 /*
 UserFromJWTMiddleware's handler, extracts JWT from auth header, validates JWT and inserts user in the request context
 */
 func (l *UserFromJWTMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	if isCreateUserRequest(r) {
+	if isPublicRequest(r) {
 		next(w, r)
 		return
 	}
 	token, err := extractJWTFromHeader(r.Header.Get("Authorization"))
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusUnauthorized)
+		utils.ResponseProblem(w, err)
 		return
 	}
-	claims, err := getTokenClaims(token)
+	claims, err := auth.ParseToken(token)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusForbidden)
+		utils.ResponseProblem(w, errInvalidToken)
 		return
 	}
 
-	user, err := models.GetUserByID(claims.User.ID.Hex())
+	user, err := models.GetUserByID(claims.Subject)
 	if err != nil {
-		utils.ResponseError(w, err.Error(), http.StatusBadRequest)
+		utils.ResponseProblem(w, errUnknownTokenUser)
+		return
+	}
+	if claims.IsRevokedFor(user) {
+		utils.ResponseProblem(w, errRevokedToken)
+		return
+	}
+	if user.IsSuspended() {
+		utils.ResponseProblem(w, errSuspended)
 		return
 	}
 
-	r = r.WithContext(context.WithValue(r.Context(), utils.ContextKeyCurrentUser, user))
+	ctx := context.WithValue(r.Context(), utils.ContextKeyCurrentUser, user)
+	r = r.WithContext(context.WithValue(ctx, utils.ContextKeyTokenClaims, claims))
 	next(w, r)
 }
+
+/*
+RequireScope wraps the handler of a route, refusing delegated tokens which lack scope. Routes which aren't wrapped
+must refuse delegated tokens on their own
+*/
+func RequireScope(scope models.Scope, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(utils.ContextKeyTokenClaims).(*auth.TokenClaims)
+		if ok && claims.IsDelegated() && !models.HasScope(claims.Scope, scope) {
+			utils.ResponseProblem(w, models.NewError(models.KindForbidden, "Token lacks the %s scope", scope))
+			return
+		}
+		handler(w, r)
+	}
+}
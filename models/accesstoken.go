@@ -0,0 +1,195 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var personalAccessTokenCollection *bongo.Collection
+
+const (
+	// PersonalAccessTokenPrefix starts every personal access token, telling them apart from JWTs
+	PersonalAccessTokenPrefix = "mbp_"
+	defaultAccessTokenTTL     = 30 * 24 * time.Hour
+	maxAccessTokenTTL         = 365 * 24 * time.Hour
+	// lastUsedResolution is how stale the last use of a token can get, to avoid a write on every request
+	lastUsedResolution = time.Minute
+)
+
+var errInvalidPersonalAccessToken = errors.New("Invalid or expired personal access token")
+
+/*
+PersonalAccessToken is a document which holds a long lived token a user minted for scripts and integrations, it
+acts on behalf of the user limited to its scopes. Only the hash of the token is stored
+*/
+type PersonalAccessToken struct {
+	bongo.DocumentBase `bson:",inline"`
+	User               bson.ObjectId `bson:"user"`
+	Name               string        `bson:"name"`
+	TokenHash          string        `bson:"tokenHash"`
+	Scopes             []Scope       `bson:"scopes"`
+	ExpiresAt          time.Time     `bson:"expiresAt"`
+	LastUsed           time.Time     `bson:"lastUsed"`
+}
+
+// PersonalAccessTokenRequest is a struct that resembles a request performed by users to mint a personal access token
+type PersonalAccessTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// PersonalAccessTokenResponse is a struct that resembles a response for personal access token detail and listing
+type PersonalAccessTokenResponse struct {
+	ID        bson.ObjectId `json:"id"`
+	Name      string        `json:"name"`
+	Scopes    []Scope       `json:"scopes"`
+	Created   time.Time     `json:"created"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	LastUsed  *time.Time    `json:"lastUsed"`
+}
+
+// PersonalAccessTokenCreatedResponse is returned only once, when the token is minted, as it holds the token
+type PersonalAccessTokenCreatedResponse struct {
+	PersonalAccessTokenResponse
+	Token string `json:"token"`
+}
+
+// PersonalAccessTokenListResponse is a list of PersonalAccessTokenResponse
+type PersonalAccessTokenListResponse []PersonalAccessTokenResponse
+
+// setupPersonalAccessTokenIndexes lets tokens be found by hash and mongo remove them once they expire
+func setupPersonalAccessTokenIndexes() {
+	c := personalAccessTokenCollection.Collection()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"tokenHash"}, Unique: true}); err != nil {
+		log.Println("Could not create personal access token index", err)
+	}
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"expiresAt"}, ExpireAfter: time.Second}); err != nil {
+		log.Println("Could not create personal access token TTL index", err)
+	}
+}
+
+// NewPersonalAccessToken returns a new PersonalAccessToken of user along with the plain token
+func NewPersonalAccessToken(request PersonalAccessTokenRequest, user User) (*PersonalAccessToken, string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic("Could not generate personal access token ", err)
+	}
+	token := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(defaultAccessTokenTTL)
+	if request.ExpiresAt != nil {
+		expiresAt = *request.ExpiresAt
+	}
+	return &PersonalAccessToken{
+		User:      user.GetId(),
+		Name:      request.Name,
+		TokenHash: hashToken(token),
+		Scopes:    request.Scopes,
+		ExpiresAt: expiresAt,
+	}, token
+}
+
+func (t *PersonalAccessToken) validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return NewFieldError("name", "Field name is required")
+	}
+	now := time.Now()
+	if !t.ExpiresAt.After(now) {
+		return NewFieldError("expiresAt", "Field expiresAt must be in the future")
+	}
+	if t.ExpiresAt.After(now.Add(maxAccessTokenTTL)) {
+		return NewFieldError("expiresAt", "Personal access tokens can't last more than a year")
+	}
+	return validateScopes(t.Scopes)
+}
+
+// Save saves a PersonalAccessToken instance into database
+func (t *PersonalAccessToken) Save() error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	return personalAccessTokenCollection.Save(t)
+}
+
+// Delete deletes a PersonalAccessToken instance from database, which revokes it right away
+func (t *PersonalAccessToken) Delete() error {
+	return personalAccessTokenCollection.DeleteDocument(t)
+}
+
+// IsOwnedBy returns whether the token was minted by user
+func (t *PersonalAccessToken) IsOwnedBy(user User) bool {
+	return t.User == user.GetId()
+}
+
+// GetResponse returns a PersonalAccessTokenResponse
+func (t *PersonalAccessToken) GetResponse() PersonalAccessTokenResponse {
+	response := PersonalAccessTokenResponse{
+		ID:        t.GetId(),
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		Created:   t.Created,
+		ExpiresAt: t.ExpiresAt,
+	}
+	if !t.LastUsed.IsZero() {
+		response.LastUsed = &t.LastUsed
+	}
+	return response
+}
+
+// GetPersonalAccessTokenByID returns a personal access token searching by id
+func GetPersonalAccessTokenByID(id string) (token PersonalAccessToken, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return token, newNotFoundError("Personal access token")
+	}
+
+	err = personalAccessTokenCollection.FindById(bson.ObjectIdHex(id), &token)
+	if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+		err = newNotFoundError("Personal access token")
+	}
+	return
+}
+
+// AuthenticatePersonalAccessToken returns the unexpired personal access token whose plain value is token
+func AuthenticatePersonalAccessToken(token string) (*PersonalAccessToken, error) {
+	accessToken := &PersonalAccessToken{}
+	now := time.Now()
+	query := bson.M{"tokenHash": hashToken(token), "expiresAt": bson.M{"$gt": now}}
+	if err := personalAccessTokenCollection.Collection().Find(query).One(accessToken); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+	if now.Sub(accessToken.LastUsed) > lastUsedResolution {
+		accessToken.LastUsed = now
+		personalAccessTokenCollection.Collection().UpdateId(accessToken.GetId(), bson.M{"$set": bson.M{"lastUsed": now}})
+	}
+	return accessToken, nil
+}
+
+// GetPersonalAccessTokenListResponse returns the unexpired personal access tokens of user, newest first
+func GetPersonalAccessTokenListResponse(user User) PersonalAccessTokenListResponse {
+	results := personalAccessTokenCollection.Find(bson.M{"user": user.GetId(), "expiresAt": bson.M{"$gt": time.Now()}})
+	results.Query.Sort("-_created")
+
+	responses := make(PersonalAccessTokenListResponse, 0)
+	token := PersonalAccessToken{}
+	for results.Next(&token) {
+		responses = append(responses, token.GetResponse())
+	}
+	return responses
+}
+
+// deleteUserPersonalAccessTokens revokes every personal access token of user
+func deleteUserPersonalAccessTokens(user User) error {
+	_, err := personalAccessTokenCollection.Collection().RemoveAll(bson.M{"user": user.GetId()})
+	return err
+}
@@ -1,13 +1,13 @@
 package models
 
 import (
-	"encoding/base64"
-	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -21,15 +21,35 @@ const (
 	boxStatusClosed = BoxStatus("closed")
 )
 
+// defaultReminderHours is the reminder schedule of boxes created without one
+var defaultReminderHours = []int{24}
+
+const maxReminderHours = 24 * 365
+
+// maxOpenDateYears is how far in the future boxes can be set to open
+const maxOpenDateYears = 10
+
+// BoxReminder is an embedded document which holds a reminder for the members that have not written a note yet
+type BoxReminder struct {
+	HoursBefore int       `bson:"hoursBefore"`
+	At          time.Time `bson:"at"`
+	Sent        bool      `bson:"sent"`
+}
+
 // Box is a document which holds information about a box
 type Box struct {
 	bongo.DocumentBase `bson:",inline"`
 	Name               string          `bson:"name"`
 	Notes              []Note          `bson:"notes"`
 	Users              []bson.ObjectId `bson:"users"`
+	Owner              bson.ObjectId   `bson:"owner,omitempty"`
 	Status             BoxStatus       `bson:"status"`
 	OpenDate           time.Time       `bson:"openDate"`
 	Passphrase         string          `bson:"passphrase"`
+	OpenAnnounced      bool            `bson:"openAnnounced"`
+	Reminders          []BoxReminder   `bson:"reminders"`
+	// Contributors are the members who have written a note, anonymous notes themselves don't tell who wrote them
+	Contributors []bson.ObjectId `bson:"contributors"`
 }
 
 //BoxResponse is a struct that resembles a response for box detail and listing
@@ -41,18 +61,50 @@ type BoxResponse struct {
 	ID            bson.ObjectId `json:"id"`
 	Registered    bool          `json:"registered"`
 	HasPassphrase bool          `json:"hasPassphrase"`
+	Owner         bson.ObjectId `json:"owner"`
+	ReminderHours []int         `json:"reminderHours"`
 }
 
 // BoxRequest is a struct that resembles a request performed by users to edit or create a box instance
 type BoxRequest struct {
-	Name       string    `json:"name"`
-	OpenDate   time.Time `json:"openDate"`
-	Passphrase *string   `json:"passphrase,omitempty"`
+	Name          string    `json:"name" validate:"required,max=100"`
+	OpenDate      time.Time `json:"openDate" validate:"required,future"`
+	Passphrase    *string   `json:"passphrase,omitempty" validate:"max=128"`
+	ReminderHours []int     `json:"reminderHours,omitempty" validate:"max=10"` // hours before opening to remind members without notes
 }
 
 // BoxRegisterRequest is a struct that resembles a request performed by users to register into a box
 type BoxRegisterRequest struct {
-	Passphrase string `json:"passphrase"`
+	Passphrase string `json:"passphrase" validate:"max=128"`
+}
+
+// Validate returns a validation error about every invalid field of the request, if any
+func (r *BoxRequest) Validate() error {
+	errs, err := validateFields(r)
+	if err != nil {
+		return err
+	}
+	if r.OpenDate.After(time.Now().AddDate(maxOpenDateYears, 0, 0)) {
+		errs = append(errs, FieldError{Field: "openDate",
+			Message: fmt.Sprintf("Field openDate can't be more than %d years away", maxOpenDateYears)})
+	}
+	for _, hours := range r.ReminderHours {
+		if hours < 1 || hours > maxReminderHours {
+			errs = append(errs, FieldError{Field: "reminderHours",
+				Message: fmt.Sprintf("Reminders must be between 1 and %d hours before the open date", maxReminderHours)})
+			break
+		}
+	}
+	return NewValidationError(errs)
+}
+
+// Validate returns a validation error about every invalid field of the request, if any
+func (r *BoxRegisterRequest) Validate() error {
+	errs, err := validateFields(r)
+	if err != nil {
+		return err
+	}
+	return NewValidationError(errs)
 }
 
 // BoxList is a list of Box Documents
@@ -61,25 +113,36 @@ type BoxList []Box
 // BoxListResponse is a list of BoxResponse
 type BoxListResponse []BoxResponse
 
-// NewBox returns a pointer to a new instance of Box
-func NewBox(request BoxRequest) *Box {
-	box := &Box{Status: boxStatusClosed}
+// NewBox returns a pointer to a new instance of Box, owned by and with owner as its first member
+func NewBox(request BoxRequest, owner User) *Box {
+	box := &Box{Status: boxStatusClosed, Owner: owner.GetId()}
 	box.Notes = make(Notes, 0)
+	box.Users = []bson.ObjectId{owner.GetId()}
 	box.Name = request.Name
 	box.OpenDate = request.OpenDate
 	if request.Passphrase != nil {
 		box.setPassphrase(*request.Passphrase)
 	}
+	if request.ReminderHours != nil {
+		box.setReminders(request.ReminderHours)
+	} else {
+		box.setReminders(defaultReminderHours)
+	}
 	return box
 }
 
 func (b *Box) String() string {
-	return fmt.Sprintf("Box name: %q notes %s, opens at %s", b.Name, b.Notes, b.OpenDate)
+	return fmt.Sprintf("Box name: %q notes %d, opens at %s", b.Name, len(b.Notes), b.OpenDate)
 }
 
 func (b *Box) validate() error {
 	if b.Name == "" {
-		return errors.New("No name provided")
+		return NewFieldError("name", "Field name is required")
+	}
+	for _, reminder := range b.Reminders {
+		if reminder.HoursBefore < 1 || reminder.HoursBefore > maxReminderHours {
+			return NewFieldError("reminderHours", "Reminders must be between 1 and %d hours before the open date", maxReminderHours)
+		}
 	}
 
 	return nil
@@ -106,29 +169,99 @@ func (b *Box) Delete() error {
 	return boxCollection.DeleteDocument(b)
 }
 
+// boxPatchFields are the fields of a box a merge patch can change, and whether it can remove them
+var boxPatchFields = map[string]bool{"name": false, "openDate": false, "passphrase": true, "reminderHours": true}
+
+/*
+IsSealed returns whether the open date of the box can no longer change, because it is open or members have written
+notes expecting it to open on that date
+*/
+func (b *Box) IsSealed() bool {
+	return b.Status == boxStatusOpen || len(b.Notes) > 0
+}
+
+/*
+Patch applies a merge patch of user to the box. Removing the passphrase lets anyone register, and removing the
+reminders leaves the box without any. The open date can't change once the box is sealed, and only the owner can
+change the reminders
+*/
+func (b *Box) Patch(patch MergePatch, user User) error {
+	if err := patch.checkFields(boxPatchFields); err != nil {
+		return err
+	}
+	if patch.Has("openDate") && b.IsSealed() {
+		return &Error{Kind: KindForbidden, Message: "The open date of a sealed box can't change",
+			Fields: []FieldError{{Field: "openDate", Message: "Field openDate can't be modified once the box is sealed"}}}
+	}
+	if patch.Has("reminderHours") && !b.IsOwner(user) {
+		return &Error{Kind: KindForbidden, Message: "Only the box owner can change its reminders",
+			Fields: []FieldError{{Field: "reminderHours", Message: "Only the box owner can change its reminders"}}}
+	}
+
+	current := BoxRequest{Name: b.Name, OpenDate: b.OpenDate, ReminderHours: b.getReminderHours()}
+	var request BoxRequest
+	if err := patch.apply(current, &request); err != nil {
+		return err
+	}
+	if patch.Removes("passphrase") {
+		request.Passphrase = new(string)
+	}
+	if patch.Removes("reminderHours") {
+		request.ReminderHours = []int{}
+	}
+	return b.Update(request)
+}
+
 // Update updates a box instance from database
 func (b *Box) Update(request BoxRequest) error {
 	b.Name = request.Name
+	openDateChanged := !b.OpenDate.Equal(request.OpenDate)
 	b.OpenDate = request.OpenDate
 	if request.Passphrase != nil {
 		b.setPassphrase(*request.Passphrase)
 	}
+	if request.ReminderHours != nil {
+		b.setReminders(request.ReminderHours)
+	} else if openDateChanged {
+		b.setReminders(b.getReminderHours())
+	}
 	return b.Save()
 }
 
-// AddNote adds a note to a box
-func (b *Box) AddNote(note Note) error {
+// AddNote adds a note written by user to a box
+func (b *Box) AddNote(note Note, user User) error {
 	if b.Status == boxStatusOpen {
-		return errors.New("Only closed boxes can get new notes")
+		return NewError(KindConflict, "Only closed boxes can get new notes")
 	}
 	b.Notes = append(b.Notes, note)
+	b.addContributor(user.GetId())
 	return b.Save()
 }
 
+func (b *Box) addContributor(id bson.ObjectId) {
+	for _, contributor := range b.Contributors {
+		if contributor == id {
+			return
+		}
+	}
+	b.Contributors = append(b.Contributors, id)
+}
+
+// removeContributor forgets that id has written a note in the box
+func (b *Box) removeContributor(id bson.ObjectId) {
+	contributors := make([]bson.ObjectId, 0, len(b.Contributors))
+	for _, contributor := range b.Contributors {
+		if contributor != id {
+			contributors = append(contributors, contributor)
+		}
+	}
+	b.Contributors = contributors
+}
+
 // GetNotes returns a list of notes from a Box instance
 func (b *Box) GetNotes() (Notes, error) {
 	if b.Status != boxStatusOpen {
-		return Notes{}, errors.New("Can't get notes from a closed box")
+		return Notes{}, NewError(KindForbidden, "Notes can't be read until the box opens")
 	}
 	return b.Notes, nil
 }
@@ -136,6 +269,7 @@ func (b *Box) GetNotes() (Notes, error) {
 // DeleteNotes deletes all the notes inside a box
 func (b *Box) DeleteNotes() {
 	b.Notes = make(Notes, 0)
+	b.Contributors = nil
 	b.Save()
 }
 
@@ -149,14 +283,83 @@ func (b *Box) GetResponse(user User) BoxResponse {
 		ID:            b.GetId(),
 		Registered:    b.IsUserRegistered(user),
 		HasPassphrase: b.Passphrase != "",
+		Owner:         b.GetOwner(),
+		ReminderHours: b.getReminderHours(),
 	}
 	return response
 }
 
+// GetOwner returns the id of the box owner. Boxes created before ownership existed belong to their first member
+func (b *Box) GetOwner() bson.ObjectId {
+	if b.Owner == "" && len(b.Users) > 0 {
+		return b.Users[0]
+	}
+	return b.Owner
+}
+
+// IsOwner returns whether user owns the box
+func (b *Box) IsOwner(user User) bool {
+	return b.GetOwner() == user.GetId()
+}
+
+/*
+setReminders schedules a reminder hoursBefore the open date for every value of hours. Reminders whose time
+has already passed are marked as sent, so creating a box close to its open date doesn't send them all at once
+*/
+func (b *Box) setReminders(hours []int) {
+	now := time.Now()
+	reminders := make([]BoxReminder, 0, len(hours))
+	seen := make(map[int]bool)
+	for _, h := range hours {
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		at := b.OpenDate.Add(-time.Duration(h) * time.Hour)
+		reminders = append(reminders, BoxReminder{HoursBefore: h, At: at, Sent: !at.After(now)})
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].At.Before(reminders[j].At) })
+	b.Reminders = reminders
+}
+
+func (b *Box) getReminderHours() []int {
+	hours := make([]int, len(b.Reminders))
+	for i, reminder := range b.Reminders {
+		hours[i] = reminder.HoursBefore
+	}
+	return hours
+}
+
+// GetContributors returns which of the box members have written at least one note
+func (b *Box) GetContributors() map[bson.ObjectId]bool {
+	contributors := make(map[bson.ObjectId]bool, len(b.Users))
+	for _, user := range b.Users {
+		contributors[user] = false
+	}
+	for _, contributor := range b.Contributors {
+		if _, ok := contributors[contributor]; ok {
+			contributors[contributor] = true
+		}
+	}
+	return contributors
+}
+
+// GetPendingContributors returns the box members that have not written any note yet
+func (b *Box) GetPendingContributors() []bson.ObjectId {
+	contributors := b.GetContributors()
+	pending := make([]bson.ObjectId, 0)
+	for _, user := range b.Users {
+		if !contributors[user] {
+			pending = append(pending, user)
+		}
+	}
+	return pending
+}
+
 // AddUser adds a user to the box, if user is already in box, returns an error if user already in box
 func (b *Box) AddUser(user User) error {
 	if b.IsUserRegistered(user) {
-		return errors.New("User is already registered in this box")
+		return NewError(KindConflict, "User is already registered in this box")
 	}
 	b.Users = append(b.Users, user.GetId())
 	return nil
@@ -170,7 +373,7 @@ func (b *Box) RemoveUser(user User) error {
 			return nil
 		}
 	}
-	return errors.New("User not registered in this box")
+	return NewError(KindConflict, "User not registered in this box")
 }
 
 // IsUserRegistered returns whether and user is registered in the box
@@ -183,30 +386,40 @@ func (b *Box) IsUserRegistered(user User) bool {
 	return false
 }
 
-// ChallengePassword returns whether a provided passphrase equals the box's passphrase
+/*
+ChallengePassword returns whether a provided passphrase equals the box's passphrase. A legacy hash is
+replaced in memory when it matches, so it is upgraded the next time the box is saved
+*/
 func (b *Box) ChallengePassword(passphrase string) bool {
 	if b.Passphrase == "" {
 		return true
 	}
-	ciphered := getPBKDF2([]byte(passphrase))
-	return base64.StdEncoding.EncodeToString(ciphered) == b.Passphrase
+	ok, needsRehash := verifyPassword(passphrase, b.Passphrase)
+	if ok && needsRehash {
+		b.setPassphrase(passphrase)
+	}
+	return ok
 }
 
+// setPassphrase sets the passphrase of the box, an empty one removes it
 func (b *Box) setPassphrase(passphrase string) {
-	dk := getPBKDF2([]byte(passphrase))
-	b.Passphrase = base64.StdEncoding.EncodeToString(dk)
+	if passphrase == "" {
+		b.Passphrase = ""
+		return
+	}
+	b.Passphrase = hashPassword(passphrase)
 }
 
 // GetBoxByID returns a box searching by id
 func GetBoxByID(id string) (box Box, err error) {
 	if !bson.IsObjectIdHex(id) {
-		return box, fmt.Errorf("%s is not a valid id}", id)
+		return box, newNotFoundError("Box")
 	}
 
 	err = boxCollection.FindById(bson.ObjectIdHex(id), &box)
 	if err != nil {
-		if dnfError, ok := err.(*bongo.DocumentNotFoundError); ok {
-			return box, dnfError
+		if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+			return box, newNotFoundError("Box")
 		}
 		log.Panic("WTF", err.Error())
 	}
@@ -230,6 +443,136 @@ func ListBoxes() (boxes BoxList) {
 	return
 }
 
+/*
+backfillOpenAnnounced sets openAnnounced on the boxes saved before it existed. Those which already opened are
+marked as announced, otherwise every box that ever opened would be announced again on the first run
+*/
+func backfillOpenAnnounced() {
+	c := boxCollection.Collection()
+	missing := bson.M{"$exists": false}
+	now := time.Now()
+	if _, err := c.UpdateAll(
+		bson.M{"openAnnounced": missing, "openDate": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"openAnnounced": true}},
+	); err != nil {
+		log.Println("Could not backfill announced boxes", err)
+	}
+	if _, err := c.UpdateAll(
+		bson.M{"openAnnounced": missing, "openDate": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"openAnnounced": false}},
+	); err != nil {
+		log.Println("Could not backfill boxes to announce", err)
+	}
+}
+
+/*
+backfillContributors moves the authors that notes used to keep, even anonymous ones, and the signers of older notes
+into the contributors of their boxes, then drops the authors so no anonymous note tells who wrote it
+*/
+func backfillContributors() {
+	c := boxCollection.Collection()
+	var boxes []struct {
+		ID    bson.ObjectId `bson:"_id"`
+		Notes []bson.M      `bson:"notes"`
+	}
+	query := bson.M{"contributors": bson.M{"$exists": false}, "notes.0": bson.M{"$exists": true}}
+	if err := c.Find(query).Select(bson.M{"notes": 1}).All(&boxes); err != nil {
+		log.Println("Could not backfill the contributors of boxes", err)
+		return
+	}
+	for _, box := range boxes {
+		contributors := make([]bson.ObjectId, 0)
+		for _, note := range box.Notes {
+			for _, key := range []string{"author", "from"} {
+				if id, ok := note[key].(bson.ObjectId); ok {
+					contributors = append(contributors, id)
+				}
+			}
+			delete(note, "author")
+		}
+		update := bson.M{
+			"$set":      bson.M{"notes": box.Notes},
+			"$addToSet": bson.M{"contributors": bson.M{"$each": contributors}},
+		}
+		if err := c.UpdateId(box.ID, update); err != nil {
+			log.Println("Could not backfill the contributors of box", box.ID.Hex(), err)
+		}
+	}
+}
+
+/*
+ClaimOpenedBox atomically opens and marks as announced a box whose open date has passed, so its opening
+is only announced once no matter how many server replicas are running. It returns nil if there is none
+*/
+func ClaimOpenedBox() (*Box, error) {
+	box := &Box{}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": boxStatusOpen, "openAnnounced": true}},
+		ReturnNew: true,
+	}
+	query := bson.M{"openDate": bson.M{"$lte": time.Now()}, "openAnnounced": false}
+	_, err := boxCollection.Collection().Find(query).Apply(change, box)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	box.SetIsNew(false)
+	return box, nil
+}
+
+/*
+ClaimDueReminder atomically marks as sent a due reminder of a closed box and returns the box, so each
+reminder is only sent once no matter how many server replicas are running. It returns nil if there is none
+*/
+func ClaimDueReminder() (*Box, error) {
+	box := &Box{}
+	now := time.Now()
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"reminders.$.sent": true}},
+		ReturnNew: true,
+	}
+	query := bson.M{
+		"status":    boxStatusClosed,
+		"openDate":  bson.M{"$gt": now},
+		"reminders": bson.M{"$elemMatch": bson.M{"sent": false, "at": bson.M{"$lte": now}}},
+	}
+	_, err := boxCollection.Collection().Find(query).Apply(change, box)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	box.SetIsNew(false)
+	return box, nil
+}
+
+// ContributionResponse tells whether a box member has written a note, never which one
+type ContributionResponse struct {
+	User        bson.ObjectId `json:"user"`
+	Username    string        `json:"username"`
+	Contributed bool          `json:"contributed"`
+}
+
+// ContributionListResponse is a list of ContributionResponse
+type ContributionListResponse []ContributionResponse
+
+// GetContributionListResponse returns for every member of box whether they have contributed yet
+func GetContributionListResponse(box Box) ContributionListResponse {
+	contributors := box.GetContributors()
+	responses := make(ContributionListResponse, 0, len(box.Users))
+	for _, id := range box.Users {
+		response := ContributionResponse{User: id, Contributed: contributors[id]}
+		if user, err := GetUserByID(id.Hex()); err == nil {
+			response.Username = user.Username
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
 //GetBoxListResponse returns a BoxListResponse which represent a the boxes in the database
 func GetBoxListResponse(user User) BoxListResponse {
 	boxes := ListBoxes()
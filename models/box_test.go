@@ -0,0 +1,76 @@
+package models
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newTestUser() User {
+	user := User{}
+	user.SetId(bson.NewObjectId())
+	return user
+}
+
+func TestAnonymousNotesDontTellTheirAuthor(t *testing.T) {
+	author, member := newTestUser(), newTestUser()
+	box := Box{Users: []bson.ObjectId{author.GetId(), member.GetId()}}
+	signed := NewNote(NoteRequest{Title: "Signed"}, author)
+	anonymous := NewNote(NoteRequest{Title: "Anonymous", Anonymous: true}, author)
+	box.Notes = Notes{*signed, *anonymous}
+	box.addContributor(author.GetId())
+	box.addContributor(author.GetId())
+
+	if anonymous.From != nil {
+		t.Errorf("NewNote() of an anonymous request returned a note from %s", anonymous.From.Hex())
+	}
+	if signed.From == nil || *signed.From != author.GetId() {
+		t.Errorf("NewNote() returned a note from %v, want %s", signed.From, author.GetId().Hex())
+	}
+	if len(box.Contributors) != 1 {
+		t.Errorf("addContributor() twice left the contributors %v, want the author once", box.Contributors)
+	}
+	contributors := box.GetContributors()
+	if !contributors[author.GetId()] || contributors[member.GetId()] {
+		t.Errorf("GetContributors() returned %v, want only the author to have contributed", contributors)
+	}
+	if pending := box.GetPendingContributors(); len(pending) != 1 || pending[0] != member.GetId() {
+		t.Errorf("GetPendingContributors() returned %v, want only %s", pending, member.GetId().Hex())
+	}
+}
+
+func TestRemoveAuthor(t *testing.T) {
+	author, other := newTestUser(), newTestUser()
+	tests := []struct {
+		policy NotePolicy
+		titles []string
+	}{
+		{policy: NotesAnonymize, titles: []string{"Signed", "Anonymous", "Other"}},
+		{policy: NotesDelete, titles: []string{"Anonymous", "Other"}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			box := Box{Contributors: []bson.ObjectId{author.GetId(), other.GetId()}, Notes: Notes{
+				*NewNote(NoteRequest{Title: "Signed"}, author),
+				*NewNote(NoteRequest{Title: "Anonymous", Anonymous: true}, author),
+				*NewNote(NoteRequest{Title: "Other"}, other),
+			}}
+
+			box.removeAuthor(author.GetId(), test.policy)
+			if len(box.Notes) != len(test.titles) {
+				t.Fatalf("removeAuthor() left the notes %+v, want %v", box.Notes, test.titles)
+			}
+			for i, note := range box.Notes {
+				if note.Title != test.titles[i] {
+					t.Errorf("removeAuthor() left the note %q, want %q", note.Title, test.titles[i])
+				}
+				if note.From != nil && *note.From == author.GetId() {
+					t.Errorf("removeAuthor() left the note %q signed by the author", note.Title)
+				}
+			}
+			if len(box.Contributors) != 1 || box.Contributors[0] != other.GetId() {
+				t.Errorf("removeAuthor() left the contributors %v, want only %s", box.Contributors, other.GetId().Hex())
+			}
+		})
+	}
+}
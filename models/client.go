@@ -0,0 +1,179 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var apiClientCollection *bongo.Collection
+
+var errInvalidAPIClient = errors.New("Invalid client credentials")
+
+/*
+APIClient is a document which holds the credentials of a service acting on behalf of its owner through the
+client_credentials grant. Only the hash of the secret is stored
+*/
+type APIClient struct {
+	bongo.DocumentBase `bson:",inline"`
+	Owner              bson.ObjectId `bson:"owner"`
+	Name               string        `bson:"name"`
+	SecretHash         string        `bson:"secretHash"`
+	Scopes             []Scope       `bson:"scopes"`
+	AccessTokens       []IssuedToken `bson:"accessTokens"`
+}
+
+// APIClientRequest is a struct that resembles a request performed by users to register an API client
+type APIClientRequest struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// APIClientResponse is a struct that resembles a response for API client detail and listing
+type APIClientResponse struct {
+	ID      bson.ObjectId `json:"id"`
+	Name    string        `json:"name"`
+	Scopes  []Scope       `json:"scopes"`
+	Created time.Time     `json:"created"`
+}
+
+// APIClientCreatedResponse is returned only once, when the client is registered, as it holds the secret
+type APIClientCreatedResponse struct {
+	APIClientResponse
+	Secret string `json:"secret"`
+}
+
+// APIClientListResponse is a list of APIClientResponse
+type APIClientListResponse []APIClientResponse
+
+// NewAPIClient returns a new APIClient owned by user along with its plain secret
+func NewAPIClient(request APIClientRequest, user User) (*APIClient, string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic("Could not generate client secret ", err)
+	}
+	secret := hex.EncodeToString(b)
+	client := &APIClient{
+		Owner:        user.GetId(),
+		Name:         request.Name,
+		SecretHash:   hashToken(secret),
+		Scopes:       request.Scopes,
+		AccessTokens: make([]IssuedToken, 0),
+	}
+	return client, secret
+}
+
+func (c *APIClient) validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return NewFieldError("name", "Field name is required")
+	}
+	return validateScopes(c.Scopes)
+}
+
+// Save saves an APIClient instance into database
+func (c *APIClient) Save() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return apiClientCollection.Save(c)
+}
+
+// Delete deletes an APIClient instance from database, denying the tokens issued to it
+func (c *APIClient) Delete() error {
+	current, err := GetAPIClientByID(c.GetId().Hex())
+	if err != nil {
+		return err
+	}
+	if err := DenyTokens(current.AccessTokens); err != nil {
+		return err
+	}
+	return apiClientCollection.DeleteDocument(c)
+}
+
+// IsOwnedBy returns whether the client was registered by user
+func (c *APIClient) IsOwnedBy(user User) bool {
+	return c.Owner == user.GetId()
+}
+
+// GrantScopes returns the scopes a token of the client gets when asked for requested, all of them if empty
+func (c *APIClient) GrantScopes(requested []Scope) ([]Scope, error) {
+	if len(requested) == 0 {
+		return c.Scopes, nil
+	}
+	for _, scope := range requested {
+		granted := false
+		for _, s := range c.Scopes {
+			granted = granted || s == scope
+		}
+		if !granted {
+			return nil, ErrInvalidScope
+		}
+	}
+	return requested, nil
+}
+
+// AddAccessToken records an access token issued to the client, so it can be denied if the client is deleted
+func (c *APIClient) AddAccessToken(jti string, expiresAt time.Time) error {
+	token := IssuedToken{JTI: jti, ExpiresAt: expiresAt}
+	return apiClientCollection.Collection().UpdateId(c.GetId(), bson.M{
+		"$push": bson.M{"accessTokens": token},
+	})
+}
+
+// GetResponse returns an APIClientResponse
+func (c *APIClient) GetResponse() APIClientResponse {
+	return APIClientResponse{
+		ID:      c.GetId(),
+		Name:    c.Name,
+		Scopes:  c.Scopes,
+		Created: c.Created,
+	}
+}
+
+// GetAPIClientByID returns an API client searching by id
+func GetAPIClientByID(id string) (client APIClient, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return client, newNotFoundError("API client")
+	}
+
+	err = apiClientCollection.FindById(bson.ObjectIdHex(id), &client)
+	if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+		err = newNotFoundError("API client")
+	}
+	return
+}
+
+// AuthenticateAPIClient returns the client identified by id if secret is its secret
+func AuthenticateAPIClient(id, secret string) (*APIClient, error) {
+	client, err := GetAPIClientByID(id)
+	if err != nil {
+		return nil, errInvalidAPIClient
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, errInvalidAPIClient
+	}
+	// Expired tokens don't need to be denied anymore
+	apiClientCollection.Collection().UpdateId(client.GetId(), bson.M{
+		"$pull": bson.M{"accessTokens": bson.M{"expiresAt": bson.M{"$lt": time.Now()}}},
+	})
+	return &client, nil
+}
+
+// GetAPIClientListResponse returns the API clients registered by user
+func GetAPIClientListResponse(user User) APIClientListResponse {
+	results := apiClientCollection.Find(bson.M{"owner": user.GetId()})
+
+	responses := make(APIClientListResponse, 0)
+	client := APIClient{}
+	for results.Next(&client) {
+		responses = append(responses, client.GetResponse())
+	}
+	return responses
+}
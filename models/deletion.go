@@ -0,0 +1,350 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var accountDeletionCollection *bongo.Collection
+
+const (
+	accountDeletionGrace = 14 * 24 * time.Hour
+	// accountDeletionLease is how long a worker owns a deletion, after it another one resumes it
+	accountDeletionLease = 10 * time.Minute
+)
+
+// NotePolicy tells what happens to the notes signed by a deleted user, anonymous ones are left as they are
+type NotePolicy string
+
+// Note policies of an account deletion
+const (
+	NotesAnonymize = NotePolicy("anonymize")
+	NotesDelete    = NotePolicy("delete")
+)
+
+type deletionStatus string
+
+const (
+	deletionScheduled = deletionStatus("scheduled")
+	deletionRunning   = deletionStatus("running")
+	deletionDone      = deletionStatus("done")
+	deletionCancelled = deletionStatus("cancelled")
+)
+
+// Steps of a deletion, run in order. Each one can run again safely, so a deletion interrupted midway is resumed
+const (
+	deletionStepCredentials = "credentials"
+	deletionStepBoxes       = "boxes"
+	deletionStepUser        = "user"
+)
+
+var deletionSteps = []string{deletionStepCredentials, deletionStepBoxes, deletionStepUser}
+
+// Errors returned when scheduling or cancelling an account deletion
+var (
+	ErrDeletionScheduled   = NewError(KindConflict, "Account deletion is already scheduled")
+	ErrNoDeletionScheduled = NewError(KindConflict, "There is no account deletion to cancel")
+)
+
+/*
+AccountDeletion is a document which holds the deletion of an account, run by a background worker once its grace
+period is over unless the user cancels it. It is kept once done, as a record of the deletion
+*/
+type AccountDeletion struct {
+	bongo.DocumentBase `bson:",inline"`
+	User               bson.ObjectId  `bson:"user"`
+	Notes              NotePolicy     `bson:"notes"`
+	ScheduledFor       time.Time      `bson:"scheduledFor"`
+	Status             deletionStatus `bson:"status"`
+	CompletedSteps     []string       `bson:"completedSteps"`
+	LeaseUntil         time.Time      `bson:"leaseUntil"`
+	// ActiveKey is only set while the deletion may still run, so a user has at most one
+	ActiveKey string `bson:"activeKey,omitempty"`
+}
+
+// AccountDeletionRequest is a struct that resembles a request performed by users to delete their account
+type AccountDeletionRequest struct {
+	Notes NotePolicy `json:"notes"`
+}
+
+// AccountDeletionResponse is a struct that resembles a response for account deletion detail
+type AccountDeletionResponse struct {
+	ID           bson.ObjectId  `json:"id"`
+	Status       deletionStatus `json:"status"`
+	Notes        NotePolicy     `json:"notes"`
+	ScheduledFor time.Time      `json:"scheduledFor"`
+	Created      time.Time      `json:"created"`
+}
+
+// setupAccountDeletionIndexes makes sure a user has a single active deletion
+func setupAccountDeletionIndexes() {
+	c := accountDeletionCollection.Collection()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"activeKey"}, Unique: true, Sparse: true}); err != nil {
+		log.Println("Could not create account deletion index", err)
+	}
+}
+
+// ScheduleAccountDeletion schedules the deletion of user once the grace period is over
+func ScheduleAccountDeletion(user User, request AccountDeletionRequest) (*AccountDeletion, error) {
+	switch request.Notes {
+	case "":
+		request.Notes = NotesAnonymize
+	case NotesAnonymize, NotesDelete:
+	default:
+		return nil, NewFieldError("notes", "Field notes must be %q or %q", NotesAnonymize, NotesDelete)
+	}
+	deletion := &AccountDeletion{
+		User:           user.GetId(),
+		Notes:          request.Notes,
+		ScheduledFor:   time.Now().Add(accountDeletionGrace),
+		Status:         deletionScheduled,
+		CompletedSteps: make([]string, 0),
+		ActiveKey:      user.GetId().Hex(),
+	}
+	if err := accountDeletionCollection.Save(deletion); err != nil {
+		if mgo.IsDup(err) {
+			return nil, ErrDeletionScheduled
+		}
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// GetActiveAccountDeletion returns the deletion of user which is scheduled or running
+func GetActiveAccountDeletion(user User) (*AccountDeletion, error) {
+	deletion := &AccountDeletion{}
+	if err := accountDeletionCollection.Collection().Find(bson.M{"activeKey": user.GetId().Hex()}).One(deletion); err != nil {
+		return nil, err
+	}
+	deletion.SetIsNew(false)
+	return deletion, nil
+}
+
+// CancelAccountDeletion cancels the scheduled deletion of user, once it started running it can't be cancelled
+func CancelAccountDeletion(user User) error {
+	err := accountDeletionCollection.Collection().Update(
+		bson.M{"activeKey": user.GetId().Hex(), "status": deletionScheduled},
+		bson.M{"$set": bson.M{"status": deletionCancelled, "_modified": time.Now()}, "$unset": bson.M{"activeKey": ""}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrNoDeletionScheduled
+	}
+	return err
+}
+
+// GetResponse returns an AccountDeletionResponse
+func (d *AccountDeletion) GetResponse() AccountDeletionResponse {
+	return AccountDeletionResponse{
+		ID:           d.GetId(),
+		Status:       d.Status,
+		Notes:        d.Notes,
+		ScheduledFor: d.ScheduledFor,
+		Created:      d.Created,
+	}
+}
+
+/*
+ClaimDueAccountDeletion atomically leases a deletion whose grace period is over, or whose worker stopped before
+finishing it, so it is only run by one worker at a time. It returns nil if there is none
+*/
+func ClaimDueAccountDeletion() (*AccountDeletion, error) {
+	deletion := &AccountDeletion{}
+	now := time.Now()
+	query := bson.M{"$or": []bson.M{
+		{"status": deletionScheduled, "scheduledFor": bson.M{"$lte": now}},
+		{"status": deletionRunning, "leaseUntil": bson.M{"$lte": now}},
+	}}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": deletionRunning, "leaseUntil": now.Add(accountDeletionLease)}},
+		ReturnNew: true,
+	}
+	_, err := accountDeletionCollection.Collection().Find(query).Apply(change, deletion)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	deletion.SetIsNew(false)
+	return deletion, nil
+}
+
+func (d *AccountDeletion) isCompleted(step string) bool {
+	for _, completed := range d.CompletedSteps {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Run runs the steps of the deletion that were not completed yet, recording each one as it finishes. boxDeleted is
+called with every box deleted because the user was its last member, as it was before leaving it
+*/
+func (d *AccountDeletion) Run(boxDeleted func(Box)) error {
+	c := accountDeletionCollection.Collection()
+	for _, step := range deletionSteps {
+		if d.isCompleted(step) {
+			continue
+		}
+		if err := d.renewLease(); err != nil {
+			return err
+		}
+		if err := d.runStep(step, boxDeleted); err != nil {
+			return fmt.Errorf("Account deletion %s failed at step %s: %s", d.GetId().Hex(), step, err)
+		}
+		if err := c.UpdateId(d.GetId(), bson.M{"$addToSet": bson.M{"completedSteps": step}}); err != nil {
+			return err
+		}
+		d.CompletedSteps = append(d.CompletedSteps, step)
+	}
+	d.Status = deletionDone
+	return c.UpdateId(d.GetId(), bson.M{
+		"$set":   bson.M{"status": deletionDone, "_modified": time.Now()},
+		"$unset": bson.M{"activeKey": ""},
+	})
+}
+
+// renewLease keeps the deletion leased to the worker running it for another accountDeletionLease
+func (d *AccountDeletion) renewLease() error {
+	d.LeaseUntil = time.Now().Add(accountDeletionLease)
+	return accountDeletionCollection.Collection().UpdateId(d.GetId(), bson.M{"$set": bson.M{"leaseUntil": d.LeaseUntil}})
+}
+
+func (d *AccountDeletion) runStep(step string, boxDeleted func(Box)) error {
+	switch step {
+	case deletionStepCredentials:
+		return deleteUserCredentials(d.User)
+	case deletionStepBoxes:
+		return d.removeUserFromBoxes(boxDeleted)
+	case deletionStepUser:
+		return deleteUserDocuments(d.User)
+	}
+	return fmt.Errorf("Unknown step %q", step)
+}
+
+// deleteUserCredentials makes every token of the user stop working and removes what acts on their behalf
+func deleteUserCredentials(id bson.ObjectId) error {
+	now := time.Now()
+	if err := userCollection.Collection().UpdateId(id, bson.M{"$set": bson.M{"tokensValidAfter": now}}); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	user := User{}
+	user.SetId(id)
+	if err := RevokeUserSessions(user); err != nil {
+		return err
+	}
+	if err := deleteUserPersonalAccessTokens(user); err != nil {
+		return err
+	}
+	results := apiClientCollection.Find(bson.M{"owner": id})
+	client := APIClient{}
+	for results.Next(&client) {
+		if err := client.Delete(); err != nil {
+			return err
+		}
+	}
+	for _, webhook := range findWebhooks(bson.M{"owner": id}) {
+		if err := webhook.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+removeUserFromBoxes takes the user out of every box. Boxes they owned go to the member who joined first after
+them, or are deleted if nobody else is left and passed to boxDeleted, and the notes they signed are anonymized or
+deleted as told by the policy of the deletion. The boxes are looked up before changing any, as changing them while
+iterating could skip some, and the lease is renewed for each one so a user of many boxes keeps their worker
+*/
+func (d *AccountDeletion) removeUserFromBoxes(boxDeleted func(Box)) error {
+	id := d.User
+	query := bson.M{"$or": []bson.M{{"users": id}, {"owner": id}, {"contributors": id}, {"notes.from": id}}}
+	var boxIDs []bson.ObjectId
+	if err := boxCollection.Collection().Find(query).Distinct("_id", &boxIDs); err != nil {
+		return err
+	}
+	for _, boxID := range boxIDs {
+		if err := d.renewLease(); err != nil {
+			return err
+		}
+		box := Box{}
+		if err := boxCollection.Collection().FindId(boxID).One(&box); err == mgo.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		box.SetIsNew(false)
+		if err := removeUserFromBox(&box, id, d.Notes, boxDeleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUserFromBox takes the user id out of box, as removeUserFromBoxes does for each of theirs
+func removeUserFromBox(box *Box, id bson.ObjectId, policy NotePolicy, boxDeleted func(Box)) error {
+	owned := box.GetOwner() == id
+	users := make([]bson.ObjectId, 0, len(box.Users))
+	for _, user := range box.Users {
+		if user != id {
+			users = append(users, user)
+		}
+	}
+	if owned && len(users) == 0 {
+		if err := box.Delete(); err != nil {
+			return err
+		}
+		boxDeleted(*box)
+		return nil
+	}
+	box.Users = users
+	if owned {
+		box.Owner = users[0]
+	}
+	box.removeAuthor(id, policy)
+	// Saved without validation, a box that became invalid must still lose the user
+	return boxCollection.Save(box)
+}
+
+/*
+removeAuthor anonymizes or deletes the notes signed by id and forgets they contributed. Anonymous notes don't tell
+who wrote them, so they stay
+*/
+func (b *Box) removeAuthor(id bson.ObjectId, policy NotePolicy) {
+	b.removeContributor(id)
+	notes := make(Notes, 0, len(b.Notes))
+	for _, note := range b.Notes {
+		if note.From == nil || *note.From != id {
+			notes = append(notes, note)
+			continue
+		}
+		if policy == NotesDelete {
+			continue
+		}
+		note.From = nil
+		notes = append(notes, note)
+	}
+	b.Notes = notes
+}
+
+// deleteUserDocuments removes the user and what is left that only makes sense with them
+func deleteUserDocuments(id bson.ObjectId) error {
+	if _, err := passwordResetCollection.Collection().RemoveAll(bson.M{"user": id}); err != nil {
+		return err
+	}
+	if err := deleteDataExports(bson.M{"user": id}); err != nil {
+		return err
+	}
+	if err := userCollection.Collection().RemoveId(id); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
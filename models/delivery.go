@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var webhookDeliveryCollection *bongo.Collection
+
+// DeliveryStatus is a string that determines the state of a webhook delivery
+type DeliveryStatus string
+
+// Possible states of a webhook delivery
+const (
+	DeliveryPending   = DeliveryStatus("pending")
+	DeliverySucceeded = DeliveryStatus("succeeded")
+	DeliveryFailed    = DeliveryStatus("failed")
+)
+
+// DeliveryAttempt is an embedded document which logs a single try to deliver a webhook
+type DeliveryAttempt struct {
+	At         time.Time     `bson:"at" json:"at"`
+	StatusCode int           `bson:"statusCode" json:"statusCode,omitempty"`
+	Error      string        `bson:"error,omitempty" json:"error,omitempty"`
+	Duration   time.Duration `bson:"duration" json:"duration"`
+}
+
+// WebhookDelivery is a document which holds a webhook payload waiting to be delivered and its delivery log
+type WebhookDelivery struct {
+	bongo.DocumentBase `bson:",inline"`
+	Webhook            bson.ObjectId `bson:"webhook"`
+	// Box is the box the event happened in, the test deliveries have none
+	Box      *bson.ObjectId    `bson:"box,omitempty"`
+	Event    WebhookEvent      `bson:"event"`
+	Payload  []byte            `bson:"payload"`
+	Status   DeliveryStatus    `bson:"status"`
+	Attempts []DeliveryAttempt `bson:"attempts"`
+}
+
+// WebhookDeliveryResponse is a struct that resembles a response for delivery log listing
+type WebhookDeliveryResponse struct {
+	ID       bson.ObjectId     `json:"id"`
+	Event    WebhookEvent      `json:"event"`
+	Status   DeliveryStatus    `json:"status"`
+	Created  time.Time         `json:"created"`
+	Attempts []DeliveryAttempt `json:"attempts"`
+}
+
+// WebhookDeliveryListResponse is a list of WebhookDeliveryResponse
+type WebhookDeliveryListResponse []WebhookDeliveryResponse
+
+// NewWebhookDelivery returns a pending delivery of payload for webhook
+func NewWebhookDelivery(webhook Webhook, event WebhookEvent, payload []byte) *WebhookDelivery {
+	return &WebhookDelivery{
+		Webhook:  webhook.GetId(),
+		Event:    event,
+		Payload:  payload,
+		Status:   DeliveryPending,
+		Attempts: make([]DeliveryAttempt, 0),
+	}
+}
+
+// Save saves a WebhookDelivery instance into database
+func (d *WebhookDelivery) Save() error {
+	return webhookDeliveryCollection.Save(d)
+}
+
+// GetResponse returns a WebhookDeliveryResponse
+func (d *WebhookDelivery) GetResponse() WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		ID:       d.GetId(),
+		Event:    d.Event,
+		Status:   d.Status,
+		Created:  d.Created,
+		Attempts: d.Attempts,
+	}
+	return response
+}
+
+// GetWebhookDeliveryByID returns a webhook delivery searching by id
+func GetWebhookDeliveryByID(id string) (delivery WebhookDelivery, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return delivery, newNotFoundError("Delivery")
+	}
+
+	err = webhookDeliveryCollection.FindById(bson.ObjectIdHex(id), &delivery)
+	if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+		err = newNotFoundError("Delivery")
+	}
+	return
+}
+
+// GetWebhookDeliveryListResponse returns the last deliveries of a webhook, newest first
+func GetWebhookDeliveryListResponse(webhook Webhook, limit int) WebhookDeliveryListResponse {
+	results := webhookDeliveryCollection.Find(bson.M{"webhook": webhook.GetId()})
+	results.Query.Sort("-_created").Limit(limit)
+
+	responses := make(WebhookDeliveryListResponse, 0)
+	delivery := WebhookDelivery{}
+	for results.Next(&delivery) {
+		responses = append(responses, delivery.GetResponse())
+	}
+	return responses
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"log"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var deniedTokenCollection *bongo.Collection
+
+// deniedToken is a document which holds the jti of a revoked access token until the token expires
+type deniedToken struct {
+	bongo.DocumentBase `bson:",inline"`
+	JTI                string    `bson:"jti"`
+	ExpiresAt          time.Time `bson:"expiresAt"`
+}
+
+// setupDeniedTokenIndexes lets mongo remove denied tokens once they expire on their own
+func setupDeniedTokenIndexes() {
+	c := deniedTokenCollection.Collection()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"jti"}}); err != nil {
+		log.Println("Could not create denied token index", err)
+	}
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"expiresAt"}, ExpireAfter: time.Second}); err != nil {
+		log.Println("Could not create denied token TTL index", err)
+	}
+}
+
+// DenyTokens adds the access tokens which haven't expired yet to the denylist
+func DenyTokens(tokens []IssuedToken) error {
+	now := time.Now()
+	for _, token := range tokens {
+		if token.ExpiresAt.Before(now) {
+			continue
+		}
+		if err := deniedTokenCollection.Save(&deniedToken{JTI: token.JTI, ExpiresAt: token.ExpiresAt}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsTokenDenied returns whether the access token identified by jti was revoked
+func IsTokenDenied(jti string) bool {
+	count, err := deniedTokenCollection.Collection().Find(bson.M{"jti": jti}).Count()
+	if err != nil {
+		log.Println("Could not check token denylist", err)
+		// Failing closed, a token that can't be checked is refused
+		return true
+	}
+	return count > 0
+}
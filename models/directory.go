@@ -0,0 +1,100 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	directoryLimit = 50
+	searchLimit    = 20
+)
+
+/*
+PrivacySettings tell how a user is shown to others. Users who aren't discoverable are only found by the members of
+the boxes they share, and their full name can be hidden from everyone but themselves and admins
+*/
+type PrivacySettings struct {
+	Discoverable bool `json:"discoverable"`
+	ShowFullName bool `json:"showFullName"`
+}
+
+// PublicUserResponse is the projection of a user everyone can see, the private one is UserResponse
+type PublicUserResponse struct {
+	ID        bson.ObjectId `json:"id"`
+	Username  string        `json:"username"`
+	FirstName string        `json:"firstName,omitempty"`
+	LastName  string        `json:"lastName,omitempty"`
+	ImageURL  string        `json:"imageUrl"`
+}
+
+// PublicUserListResponse is a list of PublicUserResponse
+type PublicUserListResponse []PublicUserResponse
+
+// getPrivacySettings returns the settings of the user, which are stored inverted so users default to public
+func (u *User) getPrivacySettings() PrivacySettings {
+	return PrivacySettings{Discoverable: !u.HiddenFromDirectory, ShowFullName: !u.HideFullName}
+}
+
+func (u *User) setPrivacySettings(settings *PrivacySettings) {
+	if settings != nil {
+		u.HiddenFromDirectory, u.HideFullName = !settings.Discoverable, !settings.ShowFullName
+	}
+}
+
+// GetPublicResponse returns a PublicUserResponse
+func (u *User) GetPublicResponse() PublicUserResponse {
+	response := PublicUserResponse{ID: u.GetId(), Username: u.Username, ImageURL: u.ImageURL}
+	if !u.HideFullName {
+		response.FirstName, response.LastName = u.FirstName, u.LastName
+	}
+	return response
+}
+
+// GetResponseFor returns the private UserResponse if viewer can manage the user, otherwise a PublicUserResponse
+func (u *User) GetResponseFor(viewer User, delegated bool) interface{} {
+	if viewer.CanManage(*u, delegated) {
+		return u.GetResponse()
+	}
+	return u.GetPublicResponse()
+}
+
+// getBoxMates returns the ids of the users who share a box with user
+func getBoxMates(user User) []bson.ObjectId {
+	mates := make([]bson.ObjectId, 0)
+	results := boxCollection.Find(bson.M{"users": user.GetId()})
+	box := Box{}
+	for results.Next(&box) {
+		mates = append(mates, box.Users...)
+	}
+	return mates
+}
+
+/*
+GetUserDirectoryResponse returns the users viewer can find whose username starts with prefix, case insensitively:
+the discoverable ones and those viewer shares a box with, unless suspended. An empty prefix lists them all up to a
+limit
+*/
+func GetUserDirectoryResponse(viewer User, prefix string) PublicUserListResponse {
+	visible := bson.M{"$or": []bson.M{
+		{"hiddenFromDirectory": bson.M{"$ne": true}},
+		{"_id": bson.M{"$in": getBoxMates(viewer)}},
+	}}
+	query := bson.M{"$and": []bson.M{visible, notSuspendedQuery()}}
+	limit := directoryLimit
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		query["username"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}
+		limit = searchLimit
+	}
+	results := userCollection.Find(query)
+	results.Query.Sort("username").Limit(limit)
+
+	responses := make(PublicUserListResponse, 0)
+	user := User{}
+	for results.Next(&user) {
+		responses = append(responses, user.GetPublicResponse())
+	}
+	return responses
+}
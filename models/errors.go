@@ -0,0 +1,59 @@
+package models
+
+import "fmt"
+
+// ErrorKind tells what went wrong in a domain error, it is the stable code clients can rely on
+type ErrorKind string
+
+// Kinds of domain errors
+const (
+	KindBadRequest           = ErrorKind("bad-request")
+	KindUnauthorized         = ErrorKind("unauthorized")
+	KindForbidden            = ErrorKind("forbidden")
+	KindNotFound             = ErrorKind("not-found")
+	KindConflict             = ErrorKind("conflict")
+	KindValidation           = ErrorKind("validation-error")
+	KindTooLarge             = ErrorKind("payload-too-large")
+	KindUnsupportedMediaType = ErrorKind("unsupported-media-type")
+	KindTooManyRequests      = ErrorKind("too-many-requests")
+	KindInternal             = ErrorKind("internal-error")
+)
+
+// FieldError tells why the value of a field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error of the domain, its message is meant for the client and never holds internal details
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Fields  []FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError returns an Error of kind with a formatted message
+func NewError(kind ErrorKind, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// NewFieldError returns a validation error about a single field
+func NewFieldError(field, format string, a ...interface{}) *Error {
+	message := fmt.Sprintf(format, a...)
+	return &Error{Kind: KindValidation, Message: message, Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+// newNotFoundError returns the error of a lookup of a missing document, resource names what was looked up
+func newNotFoundError(resource string) *Error {
+	return NewError(KindNotFound, "%s not found", resource)
+}
+
+// IsNotFound returns whether err is an Error of kind KindNotFound
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Kind == KindNotFound
+}
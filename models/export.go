@@ -0,0 +1,252 @@
+package models
+
+import (
+	"log"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var dataExportCollection *bongo.Collection
+
+// DataExportTTL is how long a built export can be downloaded before it is removed
+const DataExportTTL = 48 * time.Hour
+
+// ExportStatus is a string that determines the state of a data export
+type ExportStatus string
+
+// States of a data export
+const (
+	ExportPending = ExportStatus("pending")
+	ExportReady   = ExportStatus("ready")
+	ExportFailed  = ExportStatus("failed")
+)
+
+/*
+DataExport is a document which holds an archive of the personal data of a user, built in the background when they
+ask for it. Mongo removes it once it expires, and PruneExportArchives its archive
+*/
+type DataExport struct {
+	bongo.DocumentBase `bson:",inline"`
+	User               bson.ObjectId `bson:"user"`
+	Status             ExportStatus  `bson:"status"`
+	// ArchiveFile is the GridFS file of the archive once ready, archives may not fit in a document
+	ArchiveFile bson.ObjectId `bson:"archiveFile,omitempty"`
+	ExpiresAt   time.Time     `bson:"expiresAt"`
+	// ActiveKey is only set while the export is pending or ready, so a user has at most one
+	ActiveKey string `bson:"activeKey,omitempty"`
+}
+
+// DataExportResponse is a struct that resembles a response for data export detail
+type DataExportResponse struct {
+	ID          bson.ObjectId `json:"id"`
+	Status      ExportStatus  `json:"status"`
+	Created     time.Time     `json:"created"`
+	ExpiresAt   time.Time     `json:"expiresAt"`
+	DownloadURL string        `json:"downloadUrl,omitempty"`
+}
+
+// AuthoredNote is a note written by a user along with the box it is in, as exported to them
+type AuthoredNote struct {
+	Box     bson.ObjectId `json:"box"`
+	BoxName string        `json:"boxName"`
+	Title   string        `json:"title"`
+	Detail  string        `json:"detail"`
+}
+
+// BoxMembership is a box a user is a member of, as exported to them
+type BoxMembership struct {
+	BoxResponse
+	IsOwner bool `json:"isOwner"`
+}
+
+// setupDataExportIndexes lets mongo remove exports once they expire, and a user have one pending or ready export
+func setupDataExportIndexes() {
+	c := dataExportCollection.Collection()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"expiresAt"}, ExpireAfter: time.Second}); err != nil {
+		log.Println("Could not create data export TTL index", err)
+	}
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"activeKey"}, Unique: true, Sparse: true}); err != nil {
+		log.Println("Could not create data export index", err)
+	}
+}
+
+/*
+RequestDataExport returns the export of user which is pending or ready, or saves a new pending one if there is none,
+and whether it did. Concurrent requests share the same export, as the index of ActiveKey only lets one be saved
+*/
+func RequestDataExport(user User) (*DataExport, bool, error) {
+	c := dataExportCollection.Collection()
+	key := user.GetId().Hex()
+	// Expired exports may not be removed yet, they don't hold the key anymore
+	expired := bson.M{"activeKey": key, "expiresAt": bson.M{"$lte": time.Now()}}
+	if _, err := c.UpdateAll(expired, bson.M{"$unset": bson.M{"activeKey": ""}}); err != nil {
+		return nil, false, err
+	}
+	export := &DataExport{
+		User:      user.GetId(),
+		Status:    ExportPending,
+		ExpiresAt: time.Now().Add(DataExportTTL),
+		ActiveKey: key,
+	}
+	if err := dataExportCollection.Save(export); !mgo.IsDup(err) {
+		return export, err == nil, err
+	}
+	existing := &DataExport{}
+	if err := c.Find(bson.M{"activeKey": key}).One(existing); err != nil {
+		return nil, false, err
+	}
+	existing.SetIsNew(false)
+	return existing, false, nil
+}
+
+// exportArchives is the GridFS holding the archives of data exports
+func exportArchives() *mgo.GridFS {
+	return connection.Session.DB(connection.Config.Database).GridFS("export_archive")
+}
+
+// Complete stores the built archive of the export, which can be downloaded until it expires
+func (e *DataExport) Complete(archive []byte) error {
+	file, err := exportArchives().Create(e.GetId().Hex() + ".zip")
+	if err != nil {
+		return err
+	}
+	file.SetContentType("application/zip")
+	if _, err := file.Write(archive); err != nil {
+		file.Abort()
+	}
+	// Closing an aborted or failed file removes the chunks written so far
+	if err := file.Close(); err != nil {
+		return err
+	}
+	e.Status, e.ArchiveFile, e.ExpiresAt = ExportReady, file.Id().(bson.ObjectId), time.Now().Add(DataExportTTL)
+	if err := dataExportCollection.Save(e); err != nil {
+		exportArchives().RemoveId(e.ArchiveFile)
+		return err
+	}
+	return nil
+}
+
+// OpenArchive opens the archive of a ready export for reading, the file must be closed
+func (e *DataExport) OpenArchive() (*mgo.GridFile, error) {
+	if e.Status != ExportReady || e.ArchiveFile == "" {
+		return nil, newNotFoundError("Export")
+	}
+	file, err := exportArchives().OpenId(e.ArchiveFile)
+	if err == mgo.ErrNotFound {
+		return nil, newNotFoundError("Export")
+	}
+	return file, err
+}
+
+// deleteDataExports removes the exports matching query and their archives
+func deleteDataExports(query bson.M) error {
+	archives := exportArchives()
+	results := dataExportCollection.Find(query)
+	export := DataExport{}
+	for results.Next(&export) {
+		if export.ArchiveFile == "" {
+			continue
+		}
+		if err := archives.RemoveId(export.ArchiveFile); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	_, err := dataExportCollection.Collection().RemoveAll(query)
+	return err
+}
+
+/*
+PruneExportArchives removes the archives uploaded longer than DataExportTTL ago. Mongo removes expired exports on
+its own, but not the GridFS files they point to
+*/
+func PruneExportArchives() error {
+	archives := exportArchives()
+	results := archives.Find(bson.M{"uploadDate": bson.M{"$lte": time.Now().Add(-DataExportTTL)}}).Iter()
+	var file struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	for results.Next(&file) {
+		if err := archives.RemoveId(file.ID); err != nil && err != mgo.ErrNotFound {
+			results.Close()
+			return err
+		}
+	}
+	return results.Close()
+}
+
+// Fail marks the export as failed, so the user can ask for a new one
+func (e *DataExport) Fail() error {
+	e.Status, e.ActiveKey = ExportFailed, ""
+	return dataExportCollection.Save(e)
+}
+
+// IsOwnedBy returns whether the export holds the data of user
+func (e *DataExport) IsOwnedBy(user User) bool {
+	return e.User == user.GetId()
+}
+
+// GetResponse returns a DataExportResponse, downloadURL is only shown once the archive is ready
+func (e *DataExport) GetResponse(downloadURL string) DataExportResponse {
+	response := DataExportResponse{ID: e.GetId(), Status: e.Status, Created: e.Created, ExpiresAt: e.ExpiresAt}
+	if e.Status == ExportReady {
+		response.DownloadURL = downloadURL
+	}
+	return response
+}
+
+// GetDataExportByID returns a data export searching by id
+func GetDataExportByID(id string) (export DataExport, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return export, newNotFoundError("Export")
+	}
+
+	err = dataExportCollection.FindById(bson.ObjectIdHex(id), &export)
+	if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+		err = newNotFoundError("Export")
+	}
+	return
+}
+
+// GetBoxMemberships returns the boxes user is a member of
+func GetBoxMemberships(user User) []BoxMembership {
+	results := boxCollection.Find(bson.M{"users": user.GetId()})
+	memberships := make([]BoxMembership, 0)
+	box := Box{}
+	for results.Next(&box) {
+		box.RefreshStatus()
+		memberships = append(memberships, BoxMembership{BoxResponse: box.GetResponse(user), IsOwner: box.IsOwner(user)})
+	}
+	return memberships
+}
+
+/*
+GetAuthoredNotes returns the notes user signed in open boxes. Notes of boxes that are still sealed are left out,
+not even their authors can read them until the box opens, and anonymous notes don't tell who wrote them
+*/
+func GetAuthoredNotes(user User) []AuthoredNote {
+	id := user.GetId()
+	results := boxCollection.Find(bson.M{"notes.from": id})
+	notes := make([]AuthoredNote, 0)
+	box := Box{}
+	for results.Next(&box) {
+		box.RefreshStatus()
+		if box.Status != boxStatusOpen {
+			continue
+		}
+		for _, note := range box.Notes {
+			if note.From == nil || *note.From != id {
+				continue
+			}
+			notes = append(notes, AuthoredNote{
+				Box:     box.GetId(),
+				BoxName: box.Name,
+				Title:   note.Title,
+				Detail:  note.Detail,
+			})
+		}
+	}
+	return notes
+}
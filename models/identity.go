@@ -0,0 +1,121 @@
+package models
+
+import (
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Errors returned when linking and unlinking identities
+var (
+	ErrIdentityTaken     = NewError(KindConflict, "This identity is already linked to another account")
+	ErrIdentityLinked    = NewError(KindConflict, "An identity of this provider is already linked")
+	ErrLastLoginMethod   = NewError(KindConflict, "Set a password before unlinking your last identity")
+	errIdentityNotLinked = NewError(KindNotFound, "No identity of this provider is linked")
+)
+
+// Identity is an embedded document which links a user to the subject of an OpenID Connect provider
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"`
+	Email    string    `bson:"email" json:"email"`
+	Linked   time.Time `bson:"linked" json:"linked"`
+}
+
+// IdentityListResponse is a list of the identities of a user
+type IdentityListResponse []Identity
+
+// setupUserIndexes makes sure an identity can't be linked to two users, even by concurrent requests
+func setupUserIndexes() {
+	index := mgo.Index{Key: []string{"identities.provider", "identities.subject"}, Unique: true, Sparse: true}
+	if err := userCollection.Collection().EnsureIndex(index); err != nil {
+		log.Println("Could not create user identities index", err)
+	}
+}
+
+// GetUserByIdentity returns the user the subject of provider is linked to
+func GetUserByIdentity(provider, subject string) (*User, error) {
+	user := &User{}
+	query := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	err := userCollection.FindOne(query, user)
+	return user, err
+}
+
+// GetIdentity returns the identity of provider linked to the user
+func (u *User) GetIdentity(provider string) (Identity, bool) {
+	for _, identity := range u.Identities {
+		if identity.Provider == provider {
+			return identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+/*
+IsLegacyExternalUser returns whether the user was created by a provider login before identities existed. Those
+users were matched by email and can only log in through that provider
+*/
+func (u *User) IsLegacyExternalUser() bool {
+	return u.FromGoogle && len(u.Identities) == 0 && u.Password == ""
+}
+
+// LinkIdentity links identity to the user and saves it
+func (u *User) LinkIdentity(identity Identity) error {
+	if _, ok := u.GetIdentity(identity.Provider); ok {
+		return ErrIdentityLinked
+	}
+	if other, err := GetUserByIdentity(identity.Provider, identity.Subject); err == nil && other.GetId() != u.GetId() {
+		return ErrIdentityTaken
+	}
+	identity.Linked = time.Now()
+	u.Identities = append(u.Identities, identity)
+	if err := u.saveFields("identities"); err != nil {
+		if mgo.IsDup(err) {
+			return ErrIdentityTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// UnlinkIdentity removes the identity of provider and saves the user, who must keep a way to log in
+func (u *User) UnlinkIdentity(provider string) error {
+	identities := make([]Identity, 0, len(u.Identities))
+	for _, identity := range u.Identities {
+		if identity.Provider != provider {
+			identities = append(identities, identity)
+		}
+	}
+	if len(identities) == len(u.Identities) {
+		return errIdentityNotLinked
+	}
+	if len(identities) == 0 && u.Password == "" {
+		return ErrLastLoginMethod
+	}
+	u.Identities = identities
+	return u.saveFields("identities")
+}
+
+// GetIdentityListResponse returns the identities linked to the user
+func (u *User) GetIdentityListResponse() IdentityListResponse {
+	if u.Identities == nil {
+		return make(IdentityListResponse, 0)
+	}
+	return IdentityListResponse(u.Identities)
+}
+
+func (u *User) validateIdentities() error {
+	seen := make(map[string]bool)
+	for _, identity := range u.Identities {
+		if identity.Provider == "" || identity.Subject == "" {
+			return NewFieldError("identities", "Identities need a provider and a subject")
+		}
+		if seen[identity.Provider] {
+			return NewFieldError("identities", "Only one %s identity can be linked", identity.Provider)
+		}
+		seen[identity.Provider] = true
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
 )
 
 var connection *bongo.Connection
@@ -31,10 +32,37 @@ func connectToMongo() *bongo.Connection {
 func setupCollections() {
 	boxCollection = connection.Collection("box")
 	userCollection = connection.Collection("user")
+	passwordResetCollection = connection.Collection("password_reset")
+	passwordResetAttemptCollection = connection.Collection("password_reset_attempt")
+	webhookCollection = connection.Collection("webhook")
+	webhookDeliveryCollection = connection.Collection("webhook_delivery")
+	sessionCollection = connection.Collection("session")
+	apiClientCollection = connection.Collection("api_client")
+	deniedTokenCollection = connection.Collection("denied_token")
+	loginNonceCollection = connection.Collection("login_nonce")
+	loginThrottleCollection = connection.Collection("login_throttle")
+	personalAccessTokenCollection = connection.Collection("personal_access_token")
+	accountDeletionCollection = connection.Collection("account_deletion")
+	dataExportCollection = connection.Collection("data_export")
+	backfillOpenAnnounced()
+	backfillContributors()
+	setupUserIndexes()
+	setupDeniedTokenIndexes()
+	setupLoginNonceIndexes()
+	setupLoginThrottleIndexes()
+	setupPersonalAccessTokenIndexes()
+	setupAccountDeletionIndexes()
+	setupDataExportIndexes()
 	log.Println("Collections ready")
 }
 
-func init() {
+// JobCollection returns the mongo collection holding background jobs
+func JobCollection() *mgo.Collection {
+	return connection.Collection("job").Collection()
+}
+
+// Connect connects to the database MONGO_URL and MONGO_DATABASE point to and sets its collections up
+func Connect() {
 	connectToMongo()
 	setupCollections()
 }
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var loginNonceCollection *bongo.Collection
+
+const loginNonceTTL = 10 * time.Minute
+
+var errInvalidNonce = errors.New("Invalid or expired nonce")
+
+/*
+loginNonce is a document which holds a nonce handed to a client before it logs in with an identity provider.
+The ID token must carry it, and it can only be used once, so a stolen ID token can't be replayed
+*/
+type loginNonce struct {
+	bongo.DocumentBase `bson:",inline"`
+	NonceHash          string    `bson:"nonceHash"`
+	ExpiresAt          time.Time `bson:"expiresAt"`
+}
+
+// setupLoginNonceIndexes lets mongo remove nonces once they expire
+func setupLoginNonceIndexes() {
+	c := loginNonceCollection.Collection()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"nonceHash"}, Unique: true}); err != nil {
+		log.Println("Could not create login nonce index", err)
+	}
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"expiresAt"}, ExpireAfter: time.Second}); err != nil {
+		log.Println("Could not create login nonce TTL index", err)
+	}
+}
+
+// NewLoginNonce saves and returns a new single use nonce
+func NewLoginNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return nonce, loginNonceCollection.Save(&loginNonce{
+		NonceHash: hashToken(nonce),
+		ExpiresAt: time.Now().Add(loginNonceTTL),
+	})
+}
+
+// ConsumeLoginNonce atomically removes nonce, returning an error if it wasn't issued or has expired
+func ConsumeLoginNonce(nonce string) error {
+	query := bson.M{"nonceHash": hashToken(nonce), "expiresAt": bson.M{"$gt": time.Now()}}
+	_, err := loginNonceCollection.Collection().Find(query).Apply(mgo.Change{Remove: true}, &loginNonce{})
+	if err == mgo.ErrNotFound {
+		return errInvalidNonce
+	}
+	return err
+}
+
+/*
+RemoveExpiredLoginNonces removes the nonces that expired without being used. The TTL index removes them as well,
+but only once a minute at best and not at all where it couldn't be created
+*/
+func RemoveExpiredLoginNonces() error {
+	_, err := loginNonceCollection.Collection().RemoveAll(bson.M{"expiresAt": bson.M{"$lte": time.Now()}})
+	return err
+}
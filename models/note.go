@@ -1,7 +1,6 @@
 package models
 
 import (
-	"errors"
 	"log"
 
 	"gopkg.in/mgo.v2/bson"
@@ -17,8 +16,17 @@ type Note struct {
 // NoteRequest is a struct that resembles a request performed by users to edit or create a note
 type NoteRequest struct {
 	Anonymous bool   `json:"anonymous"`
-	Title     string `json:"title"`
-	Detail    string `json:"detail"`
+	Title     string `json:"title" validate:"required,max=200"`
+	Detail    string `json:"detail" validate:"max=5000"`
+}
+
+// Validate returns a validation error about every invalid field of the request, if any
+func (r *NoteRequest) Validate() error {
+	errs, err := validateFields(r)
+	if err != nil {
+		return err
+	}
+	return NewValidationError(errs)
 }
 
 // NoteResponse is a struct that resembles a response for note detail and listing
@@ -36,6 +44,7 @@ type Notes []Note
 
 //NewNote returns a Note
 func NewNote(request NoteRequest, user User) *Note {
+	userID := user.GetId()
 	note := &Note{
 		Title:  request.Title,
 		Detail: request.Detail,
@@ -43,7 +52,6 @@ func NewNote(request NoteRequest, user User) *Note {
 	if request.Anonymous {
 		note.From = nil
 	} else {
-		userID := user.GetId()
 		note.From = &userID
 	}
 	return note
@@ -52,7 +60,7 @@ func NewNote(request NoteRequest, user User) *Note {
 // Validate returns an error if any field is missing
 func (n *Note) Validate() error {
 	if n.Title == "" {
-		return errors.New("Missing title field")
+		return NewFieldError("title", "Field title is required")
 	}
 	return nil
 }
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+/*
+Password hashes are stored in the PHC string format, so each one carries its algorithm, parameters and salt:
+
+	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+
+Hashes without a leading "$" come from the first versions of MagicBox, they are PBKDF2-SHA512 with a
+constant salt and are upgraded on the next successful challenge.
+*/
+
+// argon2Params are the cost parameters of an argon2id hash
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	keyLen  uint32
+}
+
+// currentArgon2Params are used for every new hash, hashes with other parameters are upgraded when challenged
+var currentArgon2Params = argon2Params{memory: 64 * 1024, time: 3, threads: 2, keyLen: 32}
+
+const (
+	saltLength      = 16
+	legacySalt      = "MagicBox"
+	legacyIteration = 4096
+	legacyKeyLength = 64
+)
+
+var b64 = base64.RawStdEncoding
+
+// hashPassword returns the argon2id hash of password with a fresh random salt
+func hashPassword(password string) string {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		log.Panic("Could not generate salt ", err)
+	}
+	p := currentArgon2Params
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads, b64.EncodeToString(salt), b64.EncodeToString(key))
+}
+
+/*
+verifyPassword returns whether password matches encoded, comparing in constant time. needsRehash tells if
+encoded is in a legacy format or uses outdated parameters and should be replaced by hashPassword(password)
+*/
+func verifyPassword(password, encoded string) (ok bool, needsRehash bool) {
+	if encoded == "" {
+		return false, false
+	}
+	if !strings.HasPrefix(encoded, "$") {
+		return verifyLegacyPassword(password, encoded), true
+	}
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return false, false
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	expected, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return false, false
+	}
+	p.keyLen = uint32(len(expected))
+
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	ok = subtle.ConstantTimeCompare(key, expected) == 1
+	return ok, p != currentArgon2Params
+}
+
+func verifyLegacyPassword(password, encoded string) bool {
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	key := pbkdf2.Key([]byte(password), []byte(legacySalt), legacyIteration, legacyKeyLength, sha512.New)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// MergePatch is an RFC 7396 JSON merge patch, a JSON object whose null members remove what they name
+type MergePatch map[string]interface{}
+
+// mergePatch applies patch to target as RFC 7396 describes and returns the result, target may be modified
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// Has returns whether the patch changes field
+func (p MergePatch) Has(field string) bool {
+	_, ok := p[field]
+	return ok
+}
+
+// Removes returns whether the patch removes field
+func (p MergePatch) Removes(field string) bool {
+	value, ok := p[field]
+	return ok && value == nil
+}
+
+/*
+checkFields returns a validation error about every field the patch changes which is not in mutable, or which it
+removes and mutable tells can't be removed
+*/
+func (p MergePatch) checkFields(mutable map[string]bool) error {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := make([]FieldError, 0)
+	for _, name := range names {
+		removable, ok := mutable[name]
+		if !ok {
+			errs = append(errs, FieldError{Field: name, Message: "Field " + name + " can't be modified"})
+		} else if !removable && p.Removes(name) {
+			errs = append(errs, FieldError{Field: name, Message: "Field " + name + " can't be removed"})
+		}
+	}
+	return NewValidationError(errs)
+}
+
+// apply applies the patch to the request current and decodes the result into request, which is then validated
+func (p MergePatch) apply(current interface{}, request requestValidator) error {
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var document interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return err
+	}
+	if encoded, err = json.Marshal(mergePatch(document, map[string]interface{}(p))); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(encoded, request); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return NewFieldError(typeErr.Field, "Field %s can't be a %s", typeErr.Field, typeErr.Value)
+		}
+		return err
+	}
+	return p.validate(request)
+}
+
+// validate validates request, reporting only the fields the patch changes, as the rest were valid already
+func (p MergePatch) validate(request requestValidator) error {
+	err := request.Validate()
+	domainErr, ok := err.(*Error)
+	if !ok {
+		return err
+	}
+	errs := make([]FieldError, 0)
+	for _, fieldErr := range domainErr.Fields {
+		if p.Has(fieldErr.Field) {
+			errs = append(errs, fieldErr)
+		}
+	}
+	return NewValidationError(errs)
+}
+
+// requestValidator is implemented by requests which check their own fields, reporting every invalid one at once
+type requestValidator interface {
+	Validate() error
+}
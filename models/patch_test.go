@@ -0,0 +1,161 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7396 appendix A
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		var target, patch interface{}
+		if err := json.Unmarshal([]byte(test.target), &target); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		merged, err := json.Marshal(mergePatch(target, patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(merged) != test.want {
+			t.Errorf("mergePatch() of %s with %s returned %s, want %s", test.target, test.patch, merged, test.want)
+		}
+	}
+}
+
+// fieldsOf returns the fields err is about, separated by commas, or an empty string if it isn't a *Error
+func fieldsOf(err error) string {
+	domainErr, ok := err.(*Error)
+	if !ok {
+		return ""
+	}
+	fields := make([]string, len(domainErr.Fields))
+	for i, fieldErr := range domainErr.Fields {
+		fields[i] = fieldErr.Field
+	}
+	return strings.Join(fields, ",")
+}
+
+func TestCheckFields(t *testing.T) {
+	mutable := map[string]bool{"name": false, "note": true}
+	tests := []struct {
+		name  string
+		patch MergePatch
+		want  string
+	}{
+		{name: "mutable", patch: MergePatch{"name": "Name", "note": "Note"}},
+		{name: "removable", patch: MergePatch{"note": nil}},
+		{name: "not removable", patch: MergePatch{"name": nil}, want: "name"},
+		{name: "not mutable", patch: MergePatch{"status": "open", "id": "1", "name": "Name"}, want: "id,status"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.patch.checkFields(mutable)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("checkFields() returned %v", err)
+				}
+				return
+			}
+			if fields := fieldsOf(err); fields != test.want {
+				t.Errorf("checkFields() returned %v about %q, want a validation error about %q", err, fields, test.want)
+			}
+		})
+	}
+}
+
+func TestBoxPatchRefuses(t *testing.T) {
+	owner, member := newTestUser(), newTestUser()
+	newBox := func(sealed bool) *Box {
+		box := NewBox(BoxRequest{Name: "Box", OpenDate: time.Now().Add(-time.Hour)}, owner)
+		box.Users = append(box.Users, member.GetId())
+		if sealed {
+			box.Notes = Notes{*NewNote(NoteRequest{Title: "Note"}, owner)}
+		}
+		return box
+	}
+	tests := []struct {
+		name   string
+		sealed bool
+		user   User
+		patch  MergePatch
+		kind   ErrorKind
+		fields string
+	}{
+		{name: "immutable field", user: owner, patch: MergePatch{"status": "open"}, kind: KindValidation,
+			fields: "status"},
+		{name: "removing the name", user: owner, patch: MergePatch{"name": nil}, kind: KindValidation, fields: "name"},
+		{name: "open date of a sealed box", sealed: true, user: owner,
+			patch: MergePatch{"openDate": time.Now().Add(time.Hour)}, kind: KindForbidden, fields: "openDate"},
+		{name: "reminders by a member", user: member, patch: MergePatch{"reminderHours": []int{24}},
+			kind: KindForbidden, fields: "reminderHours"},
+		// The open date has passed, but only the fields the patch changes are reported
+		{name: "invalid name", user: member, patch: MergePatch{"name": ""}, kind: KindValidation, fields: "name"},
+		{name: "wrong type", user: owner, patch: MergePatch{"name": 1}, kind: KindValidation, fields: "name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newBox(test.sealed).Patch(test.patch, test.user)
+			domainErr, ok := err.(*Error)
+			if !ok || domainErr.Kind != test.kind || fieldsOf(err) != test.fields {
+				t.Errorf("Patch() returned %v, want a %s error about %s", err, test.kind, test.fields)
+			}
+		})
+	}
+}
+
+func TestBoxPatch(t *testing.T) {
+	requireDatabase(t)
+	owner := newTestUser()
+	passphrase := "secret passphrase"
+	box := NewBox(BoxRequest{Name: "Box", OpenDate: time.Now().Add(48 * time.Hour), Passphrase: &passphrase}, owner)
+	if err := box.Save(); err != nil {
+		t.Fatal(err)
+	}
+	defer box.Delete()
+
+	patch := MergePatch{"name": "Renamed", "passphrase": nil, "reminderHours": []int{24}}
+	if err := box.Patch(patch, owner); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := GetBoxByID(box.GetId().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "Renamed" || saved.Passphrase != "" {
+		t.Errorf("Patch() saved the name %q and the passphrase %q, want Renamed and none", saved.Name, saved.Passphrase)
+	}
+	if hours := saved.getReminderHours(); len(hours) != 1 || hours[0] != 24 {
+		t.Errorf("Patch() saved the reminders %v, want [24]", hours)
+	}
+	openDate := saved.OpenDate
+	if err := saved.Patch(MergePatch{"reminderHours": nil}, owner); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Reminders) != 0 || !saved.OpenDate.Equal(openDate) {
+		t.Errorf("Patch() removing the reminders left %v and the open date %s", saved.Reminders, saved.OpenDate)
+	}
+}
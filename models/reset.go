@@ -0,0 +1,130 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var passwordResetCollection *bongo.Collection
+var passwordResetAttemptCollection *bongo.Collection
+
+const (
+	passwordResetTTL         = time.Hour
+	passwordResetWindow      = time.Hour
+	passwordResetMaxAttempts = 3
+)
+
+// ErrTooManyResetRequests is returned when an email asked for too many password resets recently
+var ErrTooManyResetRequests = NewError(KindTooManyRequests, "Too many password reset requests, try again later")
+
+var errInvalidResetToken = NewFieldError("token", "Invalid or expired reset token")
+
+// PasswordReset is a document which holds a single use password reset token. Only the token hash is stored
+type PasswordReset struct {
+	bongo.DocumentBase `bson:",inline"`
+	User               bson.ObjectId `bson:"user"`
+	TokenHash          string        `bson:"tokenHash"`
+	ExpiresAt          time.Time     `bson:"expiresAt"`
+	Used               bool          `bson:"used"`
+}
+
+// passwordResetAttempt is a document which logs a password reset request, it is used for rate limiting
+type passwordResetAttempt struct {
+	bongo.DocumentBase `bson:",inline"`
+	Email              string    `bson:"email"`
+	At                 time.Time `bson:"at"`
+}
+
+// PasswordResetRequest is a struct that resembles a request performed by users who forgot their password
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetConfirmRequest is a struct that resembles a request performed by users to set a new password
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+CheckPasswordResetRate logs a reset request for email and returns ErrTooManyResetRequests if there were too
+many recently. It works the same for registered and unknown emails
+*/
+func CheckPasswordResetRate(email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	since := time.Now().Add(-passwordResetWindow)
+	count, err := passwordResetAttemptCollection.Collection().Find(bson.M{"email": email, "at": bson.M{"$gt": since}}).Count()
+	if err != nil {
+		return err
+	}
+	if count >= passwordResetMaxAttempts {
+		return ErrTooManyResetRequests
+	}
+	return passwordResetAttemptCollection.Save(&passwordResetAttempt{Email: email, At: time.Now()})
+}
+
+// NewPasswordReset saves a new reset for user and returns the plain token, which is never stored
+func NewPasswordReset(user User) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	reset := &PasswordReset{
+		User:      user.GetId(),
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	return token, passwordResetCollection.Save(reset)
+}
+
+/*
+ResetPassword atomically consumes token and sets password on its user. Every other outstanding reset of the
+user is invalidated and the tokens issued before are revoked
+*/
+func ResetPassword(token, password string) (*User, error) {
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	reset := &PasswordReset{}
+	query := bson.M{"tokenHash": hashToken(token), "used": false, "expiresAt": bson.M{"$gt": time.Now()}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"used": true}}, ReturnNew: true}
+	if _, err := passwordResetCollection.Collection().Find(query).Apply(change, reset); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errInvalidResetToken
+		}
+		return nil, err
+	}
+	if _, err := passwordResetCollection.Collection().UpdateAll(
+		bson.M{"user": reset.User, "used": false},
+		bson.M{"$set": bson.M{"used": true}},
+	); err != nil {
+		return nil, err
+	}
+
+	user, err := GetUserByID(reset.User.Hex())
+	if err != nil {
+		return nil, errInvalidResetToken
+	}
+	user.SetPassword(password)
+	// Receiving the token proves the email belongs to the user
+	user.Status = userActive
+	user.RevokeTokens()
+	if err := user.Save(); err != nil {
+		return nil, err
+	}
+	return &user, RevokeUserSessions(user)
+}
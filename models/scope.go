@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+Scope is a permission of a delegated token, one of an API client or a personal access token, which acts on behalf
+of a user. Tokens of a user login have no scope and can do everything
+*/
+type Scope string
+
+// Scopes delegated tokens can be granted, read and write grant every resource scope of their kind
+const (
+	ScopeRead          = Scope("read")
+	ScopeWrite         = Scope("write")
+	ScopeBoxesRead     = Scope("boxes:read")
+	ScopeBoxesWrite    = Scope("boxes:write")
+	ScopeNotesRead     = Scope("notes:read")
+	ScopeNotesWrite    = Scope("notes:write")
+	ScopeUsersRead     = Scope("users:read")
+	ScopeUsersWrite    = Scope("users:write")
+	ScopeWebhooksRead  = Scope("webhooks:read")
+	ScopeWebhooksWrite = Scope("webhooks:write")
+)
+
+var scopes = []Scope{
+	ScopeRead, ScopeWrite,
+	ScopeBoxesRead, ScopeBoxesWrite,
+	ScopeNotesRead, ScopeNotesWrite,
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeWebhooksRead, ScopeWebhooksWrite,
+}
+
+// ErrInvalidScope is returned when a token is asked for a scope its client was not granted
+var ErrInvalidScope = errors.New("Requested scope is not granted to this client")
+
+func isScope(scope Scope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func validateScopes(requested []Scope) error {
+	if len(requested) == 0 {
+		return NewFieldError("scopes", "At least one scope is required")
+	}
+	for _, scope := range requested {
+		if !isScope(scope) {
+			return NewFieldError("scopes", "Unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// grants returns whether s grants required, either because they are the same or s is the read or write of it
+func (s Scope) grants(required Scope) bool {
+	switch s {
+	case required:
+		return true
+	case ScopeRead, ScopeWrite:
+		return strings.HasSuffix(string(required), ":"+string(s))
+	}
+	return false
+}
+
+// ParseScopes splits a space separated scope parameter, as sent to the token endpoint
+func ParseScopes(scope string) []Scope {
+	parsed := make([]Scope, 0)
+	for _, s := range strings.Fields(scope) {
+		parsed = append(parsed, Scope(s))
+	}
+	return parsed
+}
+
+// JoinScopes returns scopes space separated, as written in the scope claim
+func JoinScopes(scopes []Scope) string {
+	joined := make([]string, len(scopes))
+	for i, s := range scopes {
+		joined[i] = string(s)
+	}
+	return strings.Join(joined, " ")
+}
+
+// HasScope returns whether the space separated scope grants required
+func HasScope(scope string, required Scope) bool {
+	for _, s := range ParseScopes(scope) {
+		if s.grants(required) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var sessionCollection *bongo.Collection
+
+const (
+	// AccessTokenTTL is how long an access token is valid
+	AccessTokenTTL = 15 * time.Minute
+	// refreshTokenTTL is how long a session lasts since the user logged in
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// Errors returned when refreshing a session
+var (
+	ErrInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("Refresh token was already used, the session has been revoked")
+)
+
+// IssuedToken is an embedded document which records an access token issued for a session
+type IssuedToken struct {
+	JTI       string    `bson:"jti"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// SessionClient describes the client a session was opened from
+type SessionClient struct {
+	UserAgent string `bson:"userAgent" json:"userAgent"`
+	IP        string `bson:"ip" json:"ip"`
+}
+
+/*
+Session is a document which represents a login of a user. It holds the hash of the current refresh token and the
+hashes of the previous ones, so a refresh token used twice is detected and the whole session revoked
+*/
+type Session struct {
+	bongo.DocumentBase `bson:",inline"`
+	User               bson.ObjectId `bson:"user"`
+	Client             SessionClient `bson:"client"`
+	TokenHash          string        `bson:"tokenHash"`
+	UsedTokenHashes    []string      `bson:"usedTokenHashes"`
+	AccessTokens       []IssuedToken `bson:"accessTokens"`
+	LastUsed           time.Time     `bson:"lastUsed"`
+	ExpiresAt          time.Time     `bson:"expiresAt"`
+	Revoked            bool          `bson:"revoked"`
+}
+
+// SessionResponse is a struct that resembles a response for session listing
+type SessionResponse struct {
+	ID        bson.ObjectId `json:"id"`
+	Client    SessionClient `json:"client"`
+	Created   time.Time     `json:"created"`
+	LastUsed  time.Time     `json:"lastUsed"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Current   bool          `json:"current"`
+}
+
+// SessionListResponse is a list of SessionResponse
+type SessionListResponse []SessionResponse
+
+func newRefreshToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic("Could not generate refresh token ", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewSession saves a new session of user and returns it along with its plain refresh token, which is never stored
+func NewSession(user User, client SessionClient) (*Session, string, error) {
+	token := newRefreshToken()
+	now := time.Now()
+	session := &Session{
+		User:            user.GetId(),
+		Client:          client,
+		TokenHash:       hashToken(token),
+		UsedTokenHashes: make([]string, 0),
+		AccessTokens:    make([]IssuedToken, 0),
+		LastUsed:        now,
+		ExpiresAt:       now.Add(refreshTokenTTL),
+	}
+	return session, token, sessionCollection.Save(session)
+}
+
+/*
+RefreshSession atomically swaps token for a new refresh token, which is returned along with its session. When
+token was already swapped before, somebody else holds a copy of it, so the session is revoked
+*/
+func RefreshSession(token string, client SessionClient) (*Session, string, error) {
+	hash := hashToken(token)
+	next := newRefreshToken()
+	session := &Session{}
+	query := bson.M{"tokenHash": hash, "revoked": false, "expiresAt": bson.M{"$gt": time.Now()}}
+	change := mgo.Change{
+		Update: bson.M{
+			"$set":  bson.M{"tokenHash": hashToken(next), "lastUsed": time.Now(), "client": client},
+			"$push": bson.M{"usedTokenHashes": hash},
+		},
+		ReturnNew: true,
+	}
+	_, err := sessionCollection.Collection().Find(query).Apply(change, session)
+	if err == nil {
+		session.SetIsNew(false)
+		return session, next, nil
+	}
+	if err != mgo.ErrNotFound {
+		return nil, "", err
+	}
+
+	if err := sessionCollection.Collection().Find(bson.M{"usedTokenHashes": hash}).One(session); err == nil {
+		log.Printf("Refresh token of session %s was reused, revoking it", session.GetId().Hex())
+		if err := session.Revoke(); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+	return nil, "", ErrInvalidRefreshToken
+}
+
+// AddAccessToken records an access token issued for the session, so it can be denied if the session is revoked
+func (s *Session) AddAccessToken(jti string, expiresAt time.Time) error {
+	c := sessionCollection.Collection()
+	if err := c.UpdateId(s.GetId(), bson.M{"$pull": bson.M{"accessTokens": bson.M{"expiresAt": bson.M{"$lt": time.Now()}}}}); err != nil {
+		return err
+	}
+	token := IssuedToken{JTI: jti, ExpiresAt: expiresAt}
+	// Only pushed while the session is active, so a concurrent Revoke either sees the token or prevents it
+	err := c.Update(bson.M{"_id": s.GetId(), "revoked": false}, bson.M{"$push": bson.M{"accessTokens": token}})
+	if err == mgo.ErrNotFound {
+		return ErrInvalidRefreshToken
+	}
+	s.AccessTokens = append(s.AccessTokens, token)
+	return err
+}
+
+// Revoke invalidates the refresh token of the session and denies every access token issued for it
+func (s *Session) Revoke() error {
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"revoked": true}}, ReturnNew: true}
+	if _, err := sessionCollection.Collection().FindId(s.GetId()).Apply(change, s); err != nil {
+		return err
+	}
+	return DenyTokens(s.AccessTokens)
+}
+
+// IsOwnedBy returns whether the session belongs to user
+func (s *Session) IsOwnedBy(user User) bool {
+	return s.User == user.GetId()
+}
+
+// GetResponse returns a SessionResponse, current is the id of the session of the requesting token
+func (s *Session) GetResponse(current string) SessionResponse {
+	return SessionResponse{
+		ID:        s.GetId(),
+		Client:    s.Client,
+		Created:   s.Created,
+		LastUsed:  s.LastUsed,
+		ExpiresAt: s.ExpiresAt,
+		Current:   s.GetId().Hex() == current,
+	}
+}
+
+// GetSessionByID returns a session searching by id
+func GetSessionByID(id string) (session Session, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return session, newNotFoundError("Session")
+	}
+
+	err = sessionCollection.FindById(bson.ObjectIdHex(id), &session)
+	if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+		err = newNotFoundError("Session")
+	}
+	return
+}
+
+func activeSessionsQuery(user User) bson.M {
+	return bson.M{"user": user.GetId(), "revoked": false, "expiresAt": bson.M{"$gt": time.Now()}}
+}
+
+// GetSessionListResponse returns the active sessions of user, most recently used first
+func GetSessionListResponse(user User, current string) SessionListResponse {
+	results := sessionCollection.Find(activeSessionsQuery(user))
+	results.Query.Sort("-lastUsed")
+
+	responses := make(SessionListResponse, 0)
+	session := Session{}
+	for results.Next(&session) {
+		responses = append(responses, session.GetResponse(current))
+	}
+	return responses
+}
+
+// RevokeUserSessions revokes every active session of user
+func RevokeUserSessions(user User) error {
+	return RevokeOtherUserSessions(user, "")
+}
+
+// RevokeOtherUserSessions revokes every active session of user but the one whose id is keep, if any
+func RevokeOtherUserSessions(user User, keep string) error {
+	query := activeSessionsQuery(user)
+	if bson.IsObjectIdHex(keep) {
+		query["_id"] = bson.M{"$ne": bson.ObjectIdHex(keep)}
+	}
+	results := sessionCollection.Find(query)
+	session := Session{}
+	for results.Next(&session) {
+		if err := session.Revoke(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Suspension tells who suspended a user, why and until when. Suspensions without Until last until lifted by an admin
+type Suspension struct {
+	Reason string        `bson:"reason" json:"reason"`
+	Since  time.Time     `bson:"since" json:"since"`
+	Until  *time.Time    `bson:"until,omitempty" json:"until,omitempty"`
+	By     bson.ObjectId `bson:"by" json:"by"`
+}
+
+// SuspensionRequest is a struct that resembles a request performed by admins to suspend a user
+type SuspensionRequest struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// IsSuspended returns whether the user is suspended right now
+func (u *User) IsSuspended() bool {
+	return u.Suspension != nil && (u.Suspension.Until == nil || time.Now().Before(*u.Suspension.Until))
+}
+
+// notSuspendedQuery matches the users who aren't suspended right now
+func notSuspendedQuery() bson.M {
+	return bson.M{"$or": []bson.M{
+		{"suspension": bson.M{"$exists": false}},
+		{"suspension.until": bson.M{"$lte": time.Now()}},
+	}}
+}
+
+/*
+Suspend suspends the user on behalf of admin until the user is reactivated or request.Until passes. The sessions of
+the user are revoked
+*/
+func (u *User) Suspend(admin User, request SuspensionRequest) error {
+	if request.Reason == "" {
+		return NewFieldError("reason", "Field reason is required")
+	}
+	if request.Until != nil && !request.Until.After(time.Now()) {
+		return NewFieldError("until", "Field until must be in the future")
+	}
+	suspension := &Suspension{Reason: request.Reason, Since: time.Now(), Until: request.Until, By: admin.GetId()}
+	if err := userCollection.Collection().UpdateId(u.GetId(), bson.M{"$set": bson.M{"suspension": suspension}}); err != nil {
+		return err
+	}
+	u.Suspension = suspension
+	return RevokeUserSessions(*u)
+}
+
+// Reactivate lifts the suspension of the user
+func (u *User) Reactivate() error {
+	if err := userCollection.Collection().UpdateId(u.GetId(), bson.M{"$unset": bson.M{"suspension": ""}}); err != nil {
+		return err
+	}
+	u.Suspension = nil
+	return nil
+}
+
+/*
+ReactivateExpiredSuspension lifts one suspension whose expiry has passed and returns its user. It returns nil when
+there is none
+*/
+func ReactivateExpiredSuspension() (*User, error) {
+	user := &User{}
+	query := bson.M{"suspension.until": bson.M{"$lte": time.Now()}}
+	change := mgo.Change{Update: bson.M{"$unset": bson.M{"suspension": ""}}, ReturnNew: true}
+	_, err := userCollection.Collection().Find(query).Apply(change, user)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.SetIsNew(false)
+	return user, nil
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var loginThrottleCollection *bongo.Collection
+
+// maxRecordedFailures caps the failures kept for a key, older ones don't matter once a key is locked
+const maxRecordedFailures = 100
+
+/*
+ThrottleStore keeps the failed logins of a key, a username or a client IP, within a sliding window and the time
+the key is locked until. The memory store works for a single instance, the mongo store is shared by all of them
+*/
+type ThrottleStore interface {
+	// Failures returns the times of the failures of key since the given time, oldest first
+	Failures(key string, since time.Time) ([]time.Time, error)
+	// RecordFailure adds a failure of key at now and returns the failures since the given time, oldest first
+	RecordFailure(key string, now, since time.Time) ([]time.Time, error)
+	// ForgetFailure removes the failure of key recorded at the given time
+	ForgetFailure(key string, at time.Time) error
+	// LockedUntil returns the time key is locked until, the zero time when it isn't
+	LockedUntil(key string) (time.Time, error)
+	// Lock locks key until the given time
+	Lock(key string, until time.Time) error
+	// Reset forgets the failures and the lock of key
+	Reset(key string) error
+}
+
+func failuresSince(failures []time.Time, since time.Time) []time.Time {
+	recent := make([]time.Time, 0, len(failures))
+	for _, failure := range failures {
+		if failure.After(since) {
+			recent = append(recent, failure)
+		}
+	}
+	return recent
+}
+
+type throttleEntry struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+// memoryThrottleStore is a ThrottleStore local to the process
+type memoryThrottleStore struct {
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+// NewMemoryThrottleStore returns a ThrottleStore which keeps the failures in memory
+func NewMemoryThrottleStore() ThrottleStore {
+	return &memoryThrottleStore{entries: make(map[string]*throttleEntry)}
+}
+
+// prune drops the entries which are neither locked nor failed recently, s.mu must be held
+func (s *memoryThrottleStore) prune(since time.Time) {
+	for key, entry := range s.entries {
+		entry.failures = failuresSince(entry.failures, since)
+		if len(entry.failures) == 0 && entry.lockedUntil.Before(time.Now()) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *memoryThrottleStore) Failures(key string, since time.Time) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	return failuresSince(entry.failures, since), nil
+}
+
+func (s *memoryThrottleStore) RecordFailure(key string, now, since time.Time) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(since)
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &throttleEntry{}
+		s.entries[key] = entry
+	}
+	entry.failures = append(entry.failures, now)
+	if len(entry.failures) > maxRecordedFailures {
+		entry.failures = entry.failures[len(entry.failures)-maxRecordedFailures:]
+	}
+	return append([]time.Time(nil), entry.failures...), nil
+}
+
+func (s *memoryThrottleStore) ForgetFailure(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	for i, failure := range entry.failures {
+		if failure.Equal(at) {
+			entry.failures = append(entry.failures[:i], entry.failures[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryThrottleStore) LockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		return entry.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *memoryThrottleStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &throttleEntry{}
+		s.entries[key] = entry
+	}
+	entry.lockedUntil = until
+	return nil
+}
+
+func (s *memoryThrottleStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// loginThrottle is a document which holds the recent failed logins of a key
+type loginThrottle struct {
+	bongo.DocumentBase `bson:",inline"`
+	Key                string      `bson:"key"`
+	Failures           []time.Time `bson:"failures"`
+	LockedUntil        time.Time   `bson:"lockedUntil"`
+	ExpiresAt          time.Time   `bson:"expiresAt"`
+}
+
+// mongoThrottleStore is a ThrottleStore shared by every instance through mongo
+type mongoThrottleStore struct{}
+
+// NewMongoThrottleStore returns a ThrottleStore which keeps the failures in mongo
+func NewMongoThrottleStore() ThrottleStore {
+	return mongoThrottleStore{}
+}
+
+// setupLoginThrottleIndexes lets mongo remove the throttles of keys that were left alone long enough
+func setupLoginThrottleIndexes() {
+	c := loginThrottleCollection.Collection()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"key"}, Unique: true}); err != nil {
+		log.Println("Could not create login throttle index", err)
+	}
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"expiresAt"}, ExpireAfter: time.Second}); err != nil {
+		log.Println("Could not create login throttle TTL index", err)
+	}
+}
+
+func (s mongoThrottleStore) find(key string) (*loginThrottle, error) {
+	throttle := &loginThrottle{}
+	err := loginThrottleCollection.Collection().Find(bson.M{"key": key}).One(throttle)
+	if err == mgo.ErrNotFound {
+		return throttle, nil
+	}
+	return throttle, err
+}
+
+func (s mongoThrottleStore) Failures(key string, since time.Time) ([]time.Time, error) {
+	throttle, err := s.find(key)
+	if err != nil {
+		return nil, err
+	}
+	return failuresSince(throttle.Failures, since), nil
+}
+
+func (s mongoThrottleStore) RecordFailure(key string, now, since time.Time) ([]time.Time, error) {
+	throttle := &loginThrottle{}
+	change := mgo.Change{
+		Update: bson.M{
+			"$push": bson.M{"failures": bson.M{"$each": []time.Time{now}, "$slice": -maxRecordedFailures}},
+			// Kept as long as the window, a lock pushes it further
+			"$max":         bson.M{"expiresAt": now.Add(now.Sub(since))},
+			"$setOnInsert": bson.M{"_created": now},
+			"$set":         bson.M{"_modified": now},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	if _, err := loginThrottleCollection.Collection().Find(bson.M{"key": key}).Apply(change, throttle); err != nil {
+		return nil, err
+	}
+	return failuresSince(throttle.Failures, since), nil
+}
+
+func (s mongoThrottleStore) ForgetFailure(key string, at time.Time) error {
+	// Stored times are truncated to milliseconds, as is at once encoded
+	err := loginThrottleCollection.Collection().Update(bson.M{"key": key}, bson.M{"$pull": bson.M{"failures": at}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s mongoThrottleStore) LockedUntil(key string) (time.Time, error) {
+	throttle, err := s.find(key)
+	return throttle.LockedUntil, err
+}
+
+func (s mongoThrottleStore) Lock(key string, until time.Time) error {
+	_, err := loginThrottleCollection.Collection().Upsert(bson.M{"key": key}, bson.M{
+		"$set": bson.M{"lockedUntil": until, "_modified": time.Now()},
+		"$max": bson.M{"expiresAt": until},
+	})
+	return err
+}
+
+func (s mongoThrottleStore) Reset(key string) error {
+	_, err := loginThrottleCollection.Collection().RemoveAll(bson.M{"key": key})
+	return err
+}
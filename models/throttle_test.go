@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryThrottleStore(t *testing.T) {
+	store := NewMemoryThrottleStore()
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	for i := 0; i < 3; i++ {
+		failures, err := store.RecordFailure("key", at(i), at(-1))
+		if err != nil || len(failures) != i+1 || !failures[i].Equal(at(i)) {
+			t.Fatalf("RecordFailure() of failure %d returned %v, %v, want the %d failures so far", i+1, failures, err, i+1)
+		}
+	}
+	if failures, _ := store.Failures("key", at(0)); len(failures) != 2 {
+		t.Errorf("Failures() since the first failure returned %v, want the 2 after it", failures)
+	}
+	if failures, _ := store.Failures("other", at(-1)); len(failures) != 0 {
+		t.Errorf("Failures() of another key returned %v, want none", failures)
+	}
+
+	if err := store.ForgetFailure("key", at(1)); err != nil {
+		t.Fatal(err)
+	}
+	if failures, _ := store.Failures("key", at(-1)); len(failures) != 2 || !failures[1].Equal(at(2)) {
+		t.Errorf("ForgetFailure() left the failures %v, want the first and the last", failures)
+	}
+	if failures, _ := store.RecordFailure("key", at(3), at(1)); len(failures) != 2 {
+		t.Errorf("RecordFailure() returned %v, want only the failures since the window", failures)
+	}
+
+	if lockedUntil, _ := store.LockedUntil("key"); !lockedUntil.IsZero() {
+		t.Errorf("LockedUntil() of an unlocked key returned %s", lockedUntil)
+	}
+	if err := store.Lock("key", at(60)); err != nil {
+		t.Fatal(err)
+	}
+	if lockedUntil, _ := store.LockedUntil("key"); !lockedUntil.Equal(at(60)) {
+		t.Errorf("LockedUntil() returned %s, want %s", lockedUntil, at(60))
+	}
+	if err := store.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	lockedUntil, _ := store.LockedUntil("key")
+	if failures, _ := store.Failures("key", at(-1)); len(failures) != 0 || !lockedUntil.IsZero() {
+		t.Errorf("Reset() left the failures %v and the lock until %s, want neither", failures, lockedUntil)
+	}
+}
+
+func TestMemoryThrottleStoreCapsFailures(t *testing.T) {
+	store := NewMemoryThrottleStore()
+	start := time.Now()
+	var failures []time.Time
+	for i := 0; i < maxRecordedFailures+10; i++ {
+		failures, _ = store.RecordFailure("key", start.Add(time.Duration(i)*time.Millisecond), start.Add(-time.Hour))
+	}
+	if len(failures) != maxRecordedFailures || !failures[0].Equal(start.Add(10*time.Millisecond)) {
+		t.Errorf("RecordFailure() kept %d failures from %s, want the last %d", len(failures), failures[0],
+			maxRecordedFailures)
+	}
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TOTP parameters of RFC 6238, the ones every authenticator app supports
+const (
+	totpIssuer        = "MagicBox"
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1
+	totpSecretLength  = 20
+	recoveryCodeCount = 10
+)
+
+// Errors returned by two-factor authentication
+var (
+	ErrInvalidMFACode   = NewFieldError("code", "Invalid authentication code")
+	ErrMFAAlreadyActive = NewError(KindConflict, "Two-factor authentication is already enabled")
+	errNoTOTPEnrollment = NewError(KindConflict, "Start the two-factor enrollment first")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpFields are the bson names of the fields of a user about two-factor authentication
+var totpFields = []string{"totpSecret", "totpEnabled", "totpLastStep", "recoveryCodes"}
+
+// TOTPEnrollment is returned when a user starts enrolling an authenticator, URI is meant to be shown as a QR code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFACodeRequest is a struct that resembles a request carrying an authentication or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse holds the recovery codes of a user, they are only shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// totpCode returns the code of secret for the time step counter, as defined by RFC 4226
+func totpCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the time step code matches for secret around now, or 0
+func matchTOTP(secret, code string, now time.Time) int64 {
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func newRecoveryCodes() ([]string, []string) {
+	codes, hashes := make([]string, recoveryCodeCount), make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			log.Panic("Could not generate recovery code ", err)
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// MFAEnabled returns whether logging in as the user requires a second factor
+func (u *User) MFAEnabled() bool {
+	return u.TOTPEnabled
+}
+
+// StartTOTPEnrollment generates and saves a new pending TOTP secret, it is not required until confirmed
+func (u *User) StartTOTPEnrollment() (TOTPEnrollment, error) {
+	if u.TOTPEnabled {
+		return TOTPEnrollment{}, ErrMFAAlreadyActive
+	}
+	key := make([]byte, totpSecretLength)
+	if _, err := rand.Read(key); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	u.TOTPSecret = base32NoPadding.EncodeToString(key)
+	if err := u.saveFields("totpSecret"); err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	query := url.Values{}
+	query.Set("secret", u.TOTPSecret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + u.Username)
+	return TOTPEnrollment{
+		Secret: u.TOTPSecret,
+		URI:    fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode()),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication if code matches the pending secret and returns new recovery codes
+func (u *User) ConfirmTOTP(code string) (RecoveryCodesResponse, error) {
+	if u.TOTPEnabled {
+		return RecoveryCodesResponse{}, ErrMFAAlreadyActive
+	}
+	if u.TOTPSecret == "" {
+		return RecoveryCodesResponse{}, errNoTOTPEnrollment
+	}
+	step := matchTOTP(u.TOTPSecret, code, time.Now())
+	if step == 0 {
+		return RecoveryCodesResponse{}, ErrInvalidMFACode
+	}
+	codes, hashes := newRecoveryCodes()
+	u.TOTPEnabled, u.TOTPLastStep, u.RecoveryCodes = true, step, hashes
+	return RecoveryCodesResponse{RecoveryCodes: codes}, u.saveFields(totpFields...)
+}
+
+// DisableTOTP removes the authenticator and the recovery codes of the user
+func (u *User) DisableTOTP() error {
+	u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep, u.RecoveryCodes = "", false, 0, nil
+	return u.saveFields(totpFields...)
+}
+
+/*
+VerifyMFA checks a TOTP code or a recovery code of the user. Both are single use: the time step of a TOTP code
+can't be used again and recovery codes are removed, atomically so concurrent logins can't share a code
+*/
+func (u *User) VerifyMFA(code string) error {
+	if !u.TOTPEnabled {
+		return nil
+	}
+	c := userCollection.Collection()
+	if step := matchTOTP(u.TOTPSecret, strings.TrimSpace(code), time.Now()); step != 0 {
+		err := c.Update(bson.M{"_id": u.GetId(), "totpLastStep": bson.M{"$lt": step}}, bson.M{"$set": bson.M{"totpLastStep": step}})
+		if err == mgo.ErrNotFound {
+			return ErrInvalidMFACode
+		}
+		u.TOTPLastStep = step
+		return err
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	err := c.Update(bson.M{"_id": u.GetId(), "recoveryCodes": hash}, bson.M{"$pull": bson.M{"recoveryCodes": hash}})
+	if err == mgo.ErrNotFound {
+		return ErrInvalidMFACode
+	}
+	remaining := make([]string, 0, len(u.RecoveryCodes))
+	for _, h := range u.RecoveryCodes {
+		if h != hash {
+			remaining = append(remaining, h)
+		}
+	}
+	u.RecoveryCodes = remaining
+	return err
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret of the test vectors of RFC 6238 appendix B
+const testTOTPSecret = "12345678901234567890"
+
+// totpVectors are the RFC 6238 SHA-1 test vectors, truncated to the last 6 of their 8 digits
+var totpVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, vector := range totpVectors {
+		if code := totpCode([]byte(testTOTPSecret), vector.unix/totpPeriod); code != vector.code {
+			t.Errorf("totpCode() at %d returned %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := base32NoPadding.EncodeToString([]byte(testTOTPSecret))
+	for _, vector := range totpVectors {
+		step := vector.unix / totpPeriod
+		tests := []struct {
+			name string
+			code string
+			now  int64
+			want int64
+		}{
+			{name: "current step", code: vector.code, now: vector.unix, want: step},
+			{name: "previous step", code: vector.code, now: vector.unix + totpPeriod, want: step},
+			{name: "next step", code: vector.code, now: vector.unix - totpPeriod, want: step},
+			{name: "outside the skew", code: vector.code, now: vector.unix + 3*totpPeriod},
+			{name: "wrong code", code: "000000", now: vector.unix},
+			{name: "too short", code: vector.code[1:], now: vector.unix},
+		}
+		for _, test := range tests {
+			if got := matchTOTP(secret, test.code, time.Unix(test.now, 0)); got != test.want {
+				t.Errorf("matchTOTP() of the %s of %d returned %d, want %d", test.name, vector.unix, got, test.want)
+			}
+		}
+	}
+	if got := matchTOTP("not base32!", totpVectors[0].code, time.Unix(totpVectors[0].unix, 0)); got != 0 {
+		t.Errorf("matchTOTP() with a malformed secret returned %d, want 0", got)
+	}
+}
@@ -1,15 +1,15 @@
 package models
 
 import (
-	"crypto/sha512"
-	"encoding/base64"
-	"errors"
 	"fmt"
 	"log"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-bongo/bongo"
-	"golang.org/x/crypto/pbkdf2"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -23,39 +23,94 @@ const (
 	userInactive = userStatus("INACTIVE")
 )
 
+// NotificationKind is a kind of email the user can choose not to receive
+type NotificationKind string
+
+// Kinds of notification emails
+const (
+	NotifyBoxOpened        = NotificationKind("boxOpened")
+	NotifyInvitation       = NotificationKind("invitation")
+	NotifyDeadlineReminder = NotificationKind("deadlineReminder")
+)
+
+var notificationKinds = []NotificationKind{NotifyBoxOpened, NotifyInvitation, NotifyDeadlineReminder}
+
+// NotificationPreferences tells for each kind of notification whether the user wants to receive it
+type NotificationPreferences map[NotificationKind]bool
+
 // User is a document which holds information about a user
 type User struct {
-	bongo.DocumentBase `bson:",inline"`
-	Username           string     `bson:"username"`
-	Password           string     `bson:"password"`
-	Email              string     `bson:"email"`
-	FirstName          string     `bson:"firstName"`
-	LastName           string     `bson:"lastName"`
-	Status             userStatus `bson:"status"`
-	FromGoogle         bool       `bson:"from_google"`
-	ImageURL           string     `bson:"image_url"`
+	bongo.DocumentBase  `bson:",inline"`
+	Username            string             `bson:"username"`
+	Password            string             `bson:"password"`
+	Email               string             `bson:"email"`
+	FirstName           string             `bson:"firstName"`
+	LastName            string             `bson:"lastName"`
+	Status              userStatus         `bson:"status"`
+	FromGoogle          bool               `bson:"from_google"`
+	ImageURL            string             `bson:"image_url"`
+	MutedNotifications  []NotificationKind `bson:"mutedNotifications"`
+	TokensValidAfter    time.Time          `bson:"tokensValidAfter"`
+	Identities          []Identity         `bson:"identities,omitempty"`
+	TOTPSecret          string             `bson:"totpSecret,omitempty"`
+	TOTPEnabled         bool               `bson:"totpEnabled"`
+	TOTPLastStep        int64              `bson:"totpLastStep"`
+	RecoveryCodes       []string           `bson:"recoveryCodes,omitempty"`
+	HiddenFromDirectory bool               `bson:"hiddenFromDirectory"`
+	HideFullName        bool               `bson:"hideFullName"`
+	Suspension          *Suspension        `bson:"suspension,omitempty"`
+	VerificationNonce   string             `bson:"verificationNonce,omitempty"`
+	// Admin users manage every account, the first one must be granted directly in the database
+	Admin bool `bson:"admin"`
 }
 
 // UserRequest is a struct that resembles a request performed by users to edit or create a user
 type UserRequest struct {
-	Username   string  `json:"username"`
-	Password   *string `json:"password,omitempty"`
-	Email      string  `json:"email"`
-	FirstName  string  `json:"firstName"`
-	LastName   string  `json:"lastName"`
-	FromGoogle bool    `json:"-"` // never comes from json
-	ImageURL   string  `json:"imageUrl"`
+	Username      string                  `json:"username" validate:"required,max=64,username"`
+	Password      *string                 `json:"password,omitempty" validate:"min=8,max=128"`
+	Email         string                  `json:"email" validate:"required,max=254,email"`
+	FirstName     string                  `json:"firstName" validate:"required,max=50"`
+	LastName      string                  `json:"lastName" validate:"max=50"`
+	FromGoogle    bool                    `json:"-"` // never comes from json
+	ImageURL      string                  `json:"imageUrl" validate:"max=2048,url"`
+	Notifications NotificationPreferences `json:"notifications,omitempty"`
+	Privacy       *PrivacySettings        `json:"privacy,omitempty"`
+}
+
+// Validate returns a validation error about every invalid field of the request, if any
+func (r *UserRequest) Validate() error {
+	errs, err := validateFields(r)
+	if err != nil {
+		return err
+	}
+	for kind := range r.Notifications {
+		if !isNotificationKind(kind) {
+			errs = append(errs, FieldError{Field: "notifications", Message: fmt.Sprintf("Unknown notification %q", kind)})
+			break
+		}
+	}
+	return NewValidationError(errs)
+}
+
+// UserRoleRequest is a struct that resembles a request performed by admins to grant or revoke the admin role
+type UserRoleRequest struct {
+	Admin bool `json:"admin"`
 }
 
-//UserResponse is a struct that resembles a response for user detail and listing
+//UserResponse is a struct that resembles the private response for user detail, only shown to the user and admins
 type UserResponse struct {
-	Username  string        `json:"username"`
-	Email     string        `json:"email"`
-	FirstName string        `json:"firstName"`
-	LastName  string        `json:"lastName"`
-	Status    userStatus    `json:"status"`
-	ID        bson.ObjectId `json:"id"`
-	ImageURL  string        `json:"imageUrl"`
+	Username      string                  `json:"username"`
+	Email         string                  `json:"email"`
+	FirstName     string                  `json:"firstName"`
+	LastName      string                  `json:"lastName"`
+	Status        userStatus              `json:"status"`
+	ID            bson.ObjectId           `json:"id"`
+	ImageURL      string                  `json:"imageUrl"`
+	Notifications NotificationPreferences `json:"notifications"`
+	MFAEnabled    bool                    `json:"mfaEnabled"`
+	Admin         bool                    `json:"admin"`
+	Privacy       PrivacySettings         `json:"privacy"`
+	Suspension    *Suspension             `json:"suspension,omitempty"`
 }
 
 // UserList is a list of User Documents
@@ -66,16 +121,29 @@ type UserListResponse []UserResponse
 
 func validatePassword(password string) error {
 	if password == "" {
-		return errors.New("Password is required")
+		return NewFieldError("password", "Field password is required")
 	}
 	if len(password) < 8 {
-		return errors.New("Password must have at least 8 characters")
+		return NewFieldError("password", "Password must have at least 8 characters")
 	}
 
 	return nil
 }
 
-// NewUser returns an User instance, with status set to inactive
+// UserVerificationRequest is a struct that resembles a request performed by users to verify their email
+type UserVerificationRequest struct {
+	Token string `json:"token"`
+}
+
+// UserResendVerificationRequest is a struct that resembles a request performed by users to get a new verification email
+type UserResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+/*
+NewUser returns an User instance, with status set to inactive until the email is verified. Users coming from
+an OpenID Connect provider (FromGoogle) are active right away, as the provider already verified their email
+*/
 func NewUser(request UserRequest) (*User, error) {
 	log.Println(request.ImageURL)
 	user := &User{
@@ -87,10 +155,18 @@ func NewUser(request UserRequest) (*User, error) {
 		ImageURL:   request.ImageURL,
 		FromGoogle: request.FromGoogle,
 	}
+	if err := user.setNotificationPreferences(request.Notifications); err != nil {
+		return user, err
+	}
+	user.setPrivacySettings(request.Privacy)
 	if user.FromGoogle { // ignore password stuff
+		user.Status = userActive
 		return user, nil
 	}
 
+	if request.Password == nil {
+		return user, NewFieldError("password", "Field password is required")
+	}
 	if err := validatePassword(*request.Password); err != nil {
 		return user, err
 	}
@@ -106,6 +182,38 @@ func GetUserByEmail(email string) (*User, error) {
 
 }
 
+// maxUsernameBase leaves room within the 64 characters of a username for the number UniqueUsername may append
+const maxUsernameBase = 58
+
+/*
+UniqueUsername returns base without spaces, or followed by the lowest number that makes it a username nobody has
+taken yet, so users signing up through identity providers with the same email local part can all get an account
+*/
+func UniqueUsername(base string) (string, error) {
+	base = strings.Join(strings.Fields(base), "")
+	if base == "" {
+		base = "user"
+	}
+	if runes := []rune(base); len(runes) > maxUsernameBase {
+		base = string(runes[:maxUsernameBase])
+	}
+	query := bson.M{"username": bson.M{"$regex": "^" + regexp.QuoteMeta(base) + "[0-9]*$"}}
+	results := userCollection.Collection().Find(query).Select(bson.M{"username": 1}).Iter()
+	taken := make(map[string]bool)
+	user := User{}
+	for results.Next(&user) {
+		taken[user.Username] = true
+	}
+	if err := results.Close(); err != nil {
+		return "", err
+	}
+	username := base
+	for i := 2; taken[username]; i++ {
+		username = base + strconv.Itoa(i)
+	}
+	return username, nil
+}
+
 // GetUserByUsername return an user from database if the email exists
 func GetUserByUsername(username string) (*User, error) {
 	user := &User{}
@@ -118,13 +226,13 @@ func GetUserByUsername(username string) (*User, error) {
 // GetUserByID return an user from database if an user with the specified ID exists.
 func GetUserByID(id string) (user User, err error) {
 	if !bson.IsObjectIdHex(id) {
-		return user, fmt.Errorf("%s is not a valid id}", id)
+		return user, newNotFoundError("User")
 	}
 
 	err = userCollection.FindById(bson.ObjectIdHex(id), &user)
 	if err != nil {
-		if dnfError, ok := err.(*bongo.DocumentNotFoundError); ok {
-			return user, dnfError
+		if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+			return user, newNotFoundError("User")
 		}
 		log.Panic("WTF", err.Error())
 	}
@@ -140,20 +248,55 @@ func (u *User) Save() error {
 	if err := u.validate(); err != nil {
 		return err
 	}
-	u.Status = userActive
 	return userCollection.Save(u)
 }
 
+/*
+saveFields validates the user and saves only the given fields, those of their bson names. Users are loaded for a
+whole request, so saving every field would undo what changed in between, like a suspension by an admin
+*/
+func (u *User) saveFields(fields ...string) error {
+	if err := u.validate(); err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(u)
+	if err != nil {
+		return err
+	}
+	document := bson.M{}
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return err
+	}
+	u.Modified = time.Now()
+	set, unset := bson.M{"_modified": u.Modified}, bson.M{}
+	for _, field := range fields {
+		// Fields left out when empty are removed
+		if value, ok := document[field]; ok {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return userCollection.Collection().UpdateId(u.GetId(), update)
+}
+
 func (u *User) validate() error {
 	if err := u.validateUsername(); err != nil {
 		return err
 	}
 	if u.FirstName == "" {
-		return errors.New("Field firstName is required")
+		return NewFieldError("firstName", "Field firstName is required")
 	}
 	if err := u.validateEmail(); err != nil {
 		return err
 	}
+	if err := u.validateIdentities(); err != nil {
+		return err
+	}
 	if !u.FromGoogle {
 		return validatePassword(u.Password)
 	}
@@ -162,55 +305,92 @@ func (u *User) validate() error {
 }
 
 func (u *User) validateEmail() error {
-	emailRegexp := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
 	if u.Email == "" {
-		return errors.New("Field email is required")
+		return NewFieldError("email", "Field email is required")
 	}
 	if !emailRegexp.MatchString(u.Email) {
-		return errors.New("Invalid email format")
+		return NewFieldError("email", "Invalid email format")
 	}
 
 	if otherU, err := GetUserByEmail(u.Email); err == nil && u.GetId() != otherU.GetId() { //ensure unique email
-		return errors.New("Email already exists")
+		return NewFieldError("email", "Email already exists")
 	}
 	return nil
 }
 
 func (u *User) validateUsername() error {
 	if u.Username == "" {
-		return errors.New("Field username is required")
+		return NewFieldError("username", "Field username is required")
 	}
 	if otherU, err := GetUserByUsername(u.Username); err == nil && u.GetId() != otherU.GetId() { //ensure unique email
-		return errors.New("Username already exists")
+		return NewFieldError("username", "Username already exists")
 	}
 	return nil
 }
 
-// SetPassword sets the privided password to the user, but using PBKDF2 cypher
+// SetPassword sets the privided password to the user, hashed with argon2id and a per user salt
 func (u *User) SetPassword(password string) {
-	dk := getPBKDF2([]byte(password))
-	u.Password = base64.StdEncoding.EncodeToString(dk)
+	u.Password = hashPassword(password)
 }
 
-func getPBKDF2(passphrase []byte) []byte {
-	salt := []byte("MagicBox")
-	iterations := 4096
-	keylen := 64
-
-	return pbkdf2.Key([]byte(passphrase), salt, iterations, keylen, sha512.New)
+// userPatchFields are the fields of a user a merge patch can change, and whether it can remove them
+var userPatchFields = map[string]bool{
+	"username": false, "password": false, "email": false, "firstName": false, "lastName": true,
+	"notifications": true, "privacy": true,
 }
 
-// Delete deletes a box instance from database
-func (u *User) Delete() error {
-	return userCollection.DeleteDocument(u)
+/*
+Patch applies a merge patch to the user. Removing the notifications or the privacy settings restores their
+defaults, everything is sent and the user is public. keepSession is the session kept if the password changes
+*/
+func (u *User) Patch(patch MergePatch, keepSession string) error {
+	if err := patch.checkFields(userPatchFields); err != nil {
+		return err
+	}
+
+	current := UserRequest{
+		Username:      u.Username,
+		Email:         u.Email,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		Notifications: u.getNotificationPreferences(),
+	}
+	privacy := u.getPrivacySettings()
+	current.Privacy = &privacy
+	var request UserRequest
+	if err := patch.apply(current, &request); err != nil {
+		return err
+	}
+	if patch.Removes("notifications") {
+		request.Notifications = make(NotificationPreferences, len(notificationKinds))
+		for _, kind := range notificationKinds {
+			request.Notifications[kind] = true
+		}
+	}
+	if patch.Removes("privacy") {
+		request.Privacy = &PrivacySettings{Discoverable: true, ShowFullName: true}
+	}
+	return u.Update(request, keepSession)
 }
 
-// Update updates a User instance from database
-func (u *User) Update(request UserRequest) error {
+/*
+Update updates a User instance from database. Changing the password revokes every token of the user and every
+session but keepSession, so the user can keep the session they changed it from. Its client gets a new access token
+with its refresh token
+*/
+func (u *User) Update(request UserRequest, keepSession string) error {
 	u.Username = request.Username
+	if u.Email != request.Email && !request.FromGoogle {
+		u.Status = userInactive
+	}
 	u.Email = request.Email
 	u.FirstName = request.FirstName
 	u.LastName = request.LastName
+	if err := u.setNotificationPreferences(request.Notifications); err != nil {
+		return err
+	}
+	u.setPrivacySettings(request.Privacy)
+	passwordChanged := false
 	if request.FromGoogle {
 		u.ImageURL = request.ImageURL
 	} else {
@@ -219,30 +399,170 @@ func (u *User) Update(request UserRequest) error {
 				return err
 			}
 			u.SetPassword(*request.Password)
+			u.RevokeTokens()
+			passwordChanged = true
 		}
 	}
 
-	return u.Save()
+	err := u.saveFields("username", "status", "email", "firstName", "lastName", "image_url", "mutedNotifications",
+		"hiddenFromDirectory", "hideFullName", "password", "tokensValidAfter")
+	if err != nil {
+		return err
+	}
+	if passwordChanged {
+		return RevokeOtherUserSessions(*u, keepSession)
+	}
+	return nil
+}
+
+// IsActive returns whether the user has verified their email
+func (u *User) IsActive() bool {
+	return u.Status == userActive
+}
+
+// RevokeTokens makes every token issued until now invalid, it is not saved until Save is called
+func (u *User) RevokeTokens() {
+	u.TokensValidAfter = time.Now().Truncate(time.Second)
+}
+
+/*
+CanManage returns whether the user may modify target, which only admins may do for users other than themselves.
+Admin powers are never delegated, so delegated tells whether the user acts through a delegated token
+*/
+func (u *User) CanManage(target User, delegated bool) bool {
+	return u.GetId() == target.GetId() || (u.Admin && !delegated)
+}
+
+// SetAdmin grants or revokes the admin role of the user
+func (u *User) SetAdmin(admin bool) error {
+	if err := userCollection.Collection().UpdateId(u.GetId(), bson.M{"$set": bson.M{"admin": admin}}); err != nil {
+		return err
+	}
+	u.Admin = admin
+	return nil
+}
+
+// IsTokenRevoked returns whether a token issued at issuedAt was revoked
+func (u *User) IsTokenRevoked(issuedAt time.Time) bool {
+	return issuedAt.Before(u.TokensValidAfter)
+}
+
+// Activate marks the user's email as verified
+func (u *User) Activate() error {
+	u.Status = userActive
+	return u.saveFields("status")
+}
+
+// SetVerificationNonce stores the nonce of the latest verification email of the user, earlier emails stop working
+func (u *User) SetVerificationNonce(nonce string) error {
+	u.VerificationNonce = nonce
+	return userCollection.Collection().UpdateId(u.GetId(), bson.M{"$set": bson.M{"verificationNonce": nonce}})
+}
+
+/*
+ActivateVerifiedUser activates the user id if email is still their address and nonce the one of their latest
+verification email, which is cleared so it can't be used again. It returns mgo.ErrNotFound otherwise
+*/
+func ActivateVerifiedUser(id bson.ObjectId, email, nonce string) (*User, error) {
+	user := &User{}
+	query := bson.M{"_id": id, "email": email, "verificationNonce": nonce}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": userActive}, "$unset": bson.M{"verificationNonce": ""}},
+		ReturnNew: true,
+	}
+	if _, err := userCollection.Collection().Find(query).Apply(change, user); err != nil {
+		return nil, err
+	}
+	user.SetIsNew(false)
+	return user, nil
 }
 
 // GetResponse returns a BoxResponse
 func (u *User) GetResponse() UserResponse {
 	response := UserResponse{
-		Username:  u.Username,
-		Status:    u.Status,
-		Email:     u.Email,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		ImageURL:  u.ImageURL,
-		ID:        u.GetId(),
+		Username:      u.Username,
+		Status:        u.Status,
+		Email:         u.Email,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		ImageURL:      u.ImageURL,
+		ID:            u.GetId(),
+		Notifications: u.getNotificationPreferences(),
+		MFAEnabled:    u.TOTPEnabled,
+		Admin:         u.Admin,
+		Privacy:       u.getPrivacySettings(),
+	}
+	if u.IsSuspended() {
+		response.Suspension = u.Suspension
 	}
 	return response
 }
 
-// ChallengePassword returns whether a provided password equals the user's passowrd
+/*
+WantsNotification returns whether the user has not muted the kind of notification. Notifications are sent
+unless muted, so users created before preferences existed get them too
+*/
+func (u *User) WantsNotification(kind NotificationKind) bool {
+	for _, muted := range u.MutedNotifications {
+		if muted == kind {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) getNotificationPreferences() NotificationPreferences {
+	preferences := make(NotificationPreferences, len(notificationKinds))
+	for _, kind := range notificationKinds {
+		preferences[kind] = u.WantsNotification(kind)
+	}
+	return preferences
+}
+
+// setNotificationPreferences updates the muted notifications, kinds missing in preferences are left untouched
+func (u *User) setNotificationPreferences(preferences NotificationPreferences) error {
+	for kind := range preferences {
+		if !isNotificationKind(kind) {
+			return NewFieldError("notifications", "Unknown notification %q", kind)
+		}
+	}
+	current := u.getNotificationPreferences()
+	muted := make([]NotificationKind, 0)
+	for _, kind := range notificationKinds {
+		wanted, ok := preferences[kind]
+		if !ok {
+			wanted = current[kind]
+		}
+		if !wanted {
+			muted = append(muted, kind)
+		}
+	}
+	u.MutedNotifications = muted
+	return nil
+}
+
+func isNotificationKind(kind NotificationKind) bool {
+	for _, k := range notificationKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ChallengePassword returns whether a provided password equals the user's passowrd. When it does and the stored
+hash is in a legacy format or uses outdated parameters, the password is rehashed and saved
+*/
 func (u *User) ChallengePassword(password string) bool {
-	ciphered := getPBKDF2([]byte(password))
-	return base64.StdEncoding.EncodeToString(ciphered) == u.Password
+	ok, needsRehash := verifyPassword(password, u.Password)
+	if ok && needsRehash {
+		u.SetPassword(password)
+		if err := u.saveFields("password"); err != nil {
+			log.Println("Could not rehash password of user", u.Username, err)
+		}
+	}
+	return ok
 }
 
 func newUserList() UserList {
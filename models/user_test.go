@@ -0,0 +1,61 @@
+package models
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var connectOnce sync.Once
+
+// requireDatabase skips the test when MONGO_URL doesn't point to a database, and connects to it otherwise
+func requireDatabase(t *testing.T) {
+	if os.Getenv("MONGO_URL") == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	connectOnce.Do(Connect)
+}
+
+func TestSavingAStaleUserKeepsTheSuspension(t *testing.T) {
+	requireDatabase(t)
+	username := "test" + bson.NewObjectId().Hex()
+	password := "correct horse battery"
+	user, err := NewUser(UserRequest{
+		Username: username, Password: &password, Email: username + "@example.com", FirstName: "Test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Save(); err != nil {
+		t.Fatal(err)
+	}
+	stale := *user
+	admin := newTestUser()
+	if err := user.Suspend(admin, SuspensionRequest{Reason: "Spam"}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]func() error{
+		"patch":        func() error { return stale.Patch(MergePatch{"firstName": "Changed"}, "") },
+		"enroll TOTP":  func() error { _, err := stale.StartTOTPEnrollment(); return err },
+		"disable TOTP": func() error { return stale.DisableTOTP() },
+	}
+	for name, change := range changes {
+		if err := change(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		saved, err := GetUserByID(user.GetId().Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !saved.IsSuspended() {
+			t.Errorf("%s of a copy loaded before the suspension lifted it", name)
+		}
+	}
+	saved, _ := GetUserByID(user.GetId().Hex())
+	if saved.FirstName != "Changed" {
+		t.Errorf("Patch() saved the first name %q, want Changed", saved.FirstName)
+	}
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	emailRegexp    = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+	usernameRegexp = regexp.MustCompile(`^\S+$`)
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+/*
+validateFields checks the fields of the request struct v against the rules of their validate tag and returns every
+field that breaks one, named after its json field. Fields of embedded structs are checked as fields of v, like
+encoding/json decodes them. Rules are comma separated:
+
+	required   the field can't be empty
+	min=N      strings have at least N characters, numbers are at least N and lists have at least N items
+	max=N      strings have at most N characters, numbers are at most N and lists have at most N items
+	email      strings are an email address
+	username   strings have no spaces
+	url        strings are an absolute http or https URL
+	future     times are after now
+
+Rules other than required are skipped for empty fields, and nil pointers are empty. A rule which is unknown or
+can't check its field is a mistake of the request type, it is returned as an error instead of a field error
+*/
+func validateFields(v interface{}) ([]FieldError, error) {
+	errs := make([]FieldError, 0)
+	if err := validateStruct(reflect.Indirect(reflect.ValueOf(v)), &errs); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// validateStruct adds to errs the fields of the struct value which break a rule, as validateFields does
+func validateStruct(value reflect.Value, errs *[]FieldError) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if isEmbeddedStruct(field) {
+			if embedded := reflect.Indirect(value.Field(i)); embedded.IsValid() {
+				if err := validateStruct(embedded, errs); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		name := jsonFieldName(field)
+		fieldValue := reflect.Indirect(value.Field(i))
+		for _, rule := range strings.Split(rules, ",") {
+			if err := checkRuleType(rule, field.Type); err != nil {
+				return fmt.Errorf("Field %s of %s: %s", field.Name, value.Type(), err)
+			}
+			if message := checkRule(rule, fieldValue); message != "" {
+				*errs = append(*errs, FieldError{Field: name, Message: fmt.Sprintf("Field %s %s", name, message)})
+				break
+			}
+		}
+	}
+	return nil
+}
+
+/*
+checkRules returns an error if a validate tag of the struct type t, or of the structs it embeds, has a rule which is
+unknown or can't check its field. Tests check the rules of every request type with it
+*/
+func checkRules(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isEmbeddedStruct(field) {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if err := checkRules(embedded); err != nil {
+				return err
+			}
+			continue
+		}
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			if err := checkRuleType(rule, field.Type); err != nil {
+				return fmt.Errorf("Field %s of %s: %s", field.Name, t, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isEmbeddedStruct returns whether field is an embedded struct whose fields encoding/json treats as its parent's
+func isEmbeddedStruct(field reflect.StructField) bool {
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return field.Anonymous && t.Kind() == reflect.Struct && t != timeType &&
+		strings.Split(field.Tag.Get("json"), ",")[0] == ""
+}
+
+// jsonFieldName returns the name field has in json
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// isEmpty returns whether value is the zero value of its type or, for nil pointers, invalid
+func isEmpty(value reflect.Value) bool {
+	if !value.IsValid() {
+		return true
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	if value.Type() == timeType {
+		return value.Interface().(time.Time).IsZero()
+	}
+	return false
+}
+
+// splitRule returns the name and the argument of rule
+func splitRule(rule string) (string, string) {
+	if i := strings.Index(rule, "="); i >= 0 {
+		return rule[:i], rule[i+1:]
+	}
+	return rule, ""
+}
+
+// checkRuleType returns an error if rule is unknown or can't check fields of type t, or of the type t points to
+func checkRuleType(rule string, t reflect.Type) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name, arg := splitRule(rule)
+	switch name {
+	case "required":
+		return nil
+	case "min", "max":
+		if _, err := strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("invalid bound of validation rule %s", rule)
+		}
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Int64:
+			return nil
+		}
+	case "email", "username", "url":
+		if t.Kind() == reflect.String {
+			return nil
+		}
+	case "future":
+		if t == timeType {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown validation rule %q", rule)
+	}
+	return fmt.Errorf("validation rule %s can't check a %s", rule, t)
+}
+
+// checkRule returns why value breaks rule, or an empty string if it doesn't. checkRuleType must accept the rule
+func checkRule(rule string, value reflect.Value) string {
+	if rule == "required" {
+		if isEmpty(value) {
+			return "is required"
+		}
+		return ""
+	}
+	if isEmpty(value) {
+		return ""
+	}
+	name, arg := splitRule(rule)
+	switch name {
+	case "min", "max":
+		limit, _ := strconv.Atoi(arg)
+		return checkBound(name, limit, value)
+	case "email":
+		if !emailRegexp.MatchString(value.String()) {
+			return "must be a valid email address"
+		}
+	case "username":
+		if !usernameRegexp.MatchString(value.String()) {
+			return "can't have spaces"
+		}
+	case "url":
+		if u, err := url.Parse(value.String()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "must be an absolute http or https URL"
+		}
+	case "future":
+		if !value.Interface().(time.Time).After(time.Now()) {
+			return "must be in the future"
+		}
+	}
+	return ""
+}
+
+// checkBound checks the min and max rules, limit is the argument of the rule
+func checkBound(rule string, limit int, value reflect.Value) string {
+	var size int
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = utf8.RuneCountInString(value.String()), " characters"
+	case reflect.Slice, reflect.Map:
+		size, unit = value.Len(), " items"
+	default:
+		size = int(value.Int())
+	}
+	if rule == "min" && size < limit {
+		if unit == "" {
+			return fmt.Sprintf("must be at least %d", limit)
+		}
+		return fmt.Sprintf("must have at least %d%s", limit, unit)
+	}
+	if rule == "max" && size > limit {
+		if unit == "" {
+			return fmt.Sprintf("must be at most %d", limit)
+		}
+		return fmt.Sprintf("must have at most %d%s", limit, unit)
+	}
+	return ""
+}
+
+// NewValidationError returns a validation error about every field of errs, or nil if there is none
+func NewValidationError(errs []FieldError) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return &Error{Kind: KindValidation, Message: errs[0].Message, Fields: errs}
+	}
+	return &Error{Kind: KindValidation, Message: fmt.Sprintf("%d fields are invalid", len(errs)), Fields: errs}
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestRules(t *testing.T) {
+	requests := []interface{}{
+		APIClientRequest{}, AccountDeletionRequest{}, BoxRegisterRequest{}, BoxRequest{}, MFACodeRequest{},
+		NoteRequest{}, PasswordResetConfirmRequest{}, PasswordResetRequest{}, PersonalAccessTokenRequest{},
+		SuspensionRequest{}, UserRequest{}, UserResendVerificationRequest{}, UserRoleRequest{},
+		UserVerificationRequest{}, WebhookRequest{},
+	}
+	for _, request := range requests {
+		if err := checkRules(reflect.TypeOf(request)); err != nil {
+			t.Errorf("checkRules() of %T returned %v", request, err)
+		}
+	}
+}
+
+func TestCheckRule(t *testing.T) {
+	text := func(s string) reflect.Value { return reflect.ValueOf(s) }
+	tests := []struct {
+		rule  string
+		value reflect.Value
+		want  string
+	}{
+		{"required", text(""), "is required"},
+		{"required", text("  "), "is required"},
+		{"required", reflect.ValueOf([]int{}), "is required"},
+		{"required", reflect.ValueOf(time.Time{}), "is required"},
+		{"required", reflect.Value{}, "is required"},
+		{"required", text("set"), ""},
+		{"min=3", text("ab"), "must have at least 3 characters"},
+		{"min=3", text("abc"), ""},
+		{"min=3", text(""), ""},
+		{"max=3", text("abcd"), "must have at most 3 characters"},
+		{"max=3", text("ñññ"), ""},
+		{"max=1", reflect.ValueOf([]int{1, 2}), "must have at most 1 items"},
+		{"min=2", reflect.ValueOf(1), "must be at least 2"},
+		{"max=2", reflect.ValueOf(int64(3)), "must be at most 2"},
+		{"email", text("someone@example.com"), ""},
+		{"email", text("someone"), "must be a valid email address"},
+		{"username", text("someone"), ""},
+		{"username", text("some one"), "can't have spaces"},
+		{"url", text("https://example.com/image.png"), ""},
+		{"url", text("ftp://example.com"), "must be an absolute http or https URL"},
+		{"url", text("/image.png"), "must be an absolute http or https URL"},
+		{"future", reflect.ValueOf(time.Now().Add(time.Hour)), ""},
+		{"future", reflect.ValueOf(time.Now().Add(-time.Hour)), "must be in the future"},
+	}
+	for _, test := range tests {
+		if message := checkRule(test.rule, test.value); message != test.want {
+			t.Errorf("checkRule(%q) of %v returned %q, want %q", test.rule, test.value, message, test.want)
+		}
+	}
+}
+
+func TestCheckRuleType(t *testing.T) {
+	var text *string
+	tests := []struct {
+		rule  string
+		value interface{}
+		valid bool
+	}{
+		{"required", 0, true},
+		{"min=1", "", true},
+		{"max=1", text, true},
+		{"max=1", []int{}, true},
+		{"max=1", map[string]bool{}, true},
+		{"max=1", 0, true},
+		{"max=one", "", false},
+		{"max=1", 1.5, false},
+		{"email", "", true},
+		{"email", 0, false},
+		{"username", text, true},
+		{"url", []string{}, false},
+		{"future", time.Time{}, true},
+		{"future", "", false},
+		{"unknown", "", false},
+	}
+	for _, test := range tests {
+		if err := checkRuleType(test.rule, reflect.TypeOf(test.value)); (err == nil) != test.valid {
+			t.Errorf("checkRuleType(%q) of a %T returned %v, want valid: %v", test.rule, test.value, err, test.valid)
+		}
+	}
+}
+
+type testEmbeddedRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type testRequest struct {
+	testEmbeddedRequest
+	Email    string  `json:"email" validate:"email"`
+	Password *string `json:"password,omitempty" validate:"min=8"`
+	Ignored  string  `json:"ignored"`
+}
+
+func TestValidateFields(t *testing.T) {
+	short := "short"
+	tests := []struct {
+		name    string
+		request testRequest
+		want    []string
+	}{
+		{name: "valid", request: testRequest{testEmbeddedRequest{"Name"}, "someone@example.com", nil, ""}},
+		{name: "embedded", request: testRequest{Email: "someone@example.com"}, want: []string{"name"}},
+		{name: "every field", request: testRequest{Email: "someone", Password: &short}, want: []string{
+			"name", "email", "password",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs, err := validateFields(&test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields := make([]string, len(errs))
+			for i, fieldErr := range errs {
+				fields[i] = fieldErr.Field
+				if !strings.HasPrefix(fieldErr.Message, "Field "+fieldErr.Field+" ") {
+					t.Errorf("validateFields() returned the message %q for %s", fieldErr.Message, fieldErr.Field)
+				}
+			}
+			if strings.Join(fields, ",") != strings.Join(test.want, ",") {
+				t.Errorf("validateFields() refused the fields %v, want %v", fields, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateFieldsRefusesBrokenRules(t *testing.T) {
+	requests := []interface{}{
+		&struct {
+			Name string `json:"name" validate:"required,unknown"`
+		}{Name: "Name"},
+		&struct {
+			Count float64 `json:"count" validate:"max=3"`
+		}{Count: 4},
+		&struct {
+			testEmbeddedRequest
+			When string `json:"when" validate:"future"`
+		}{When: "tomorrow"},
+	}
+	for _, request := range requests {
+		if _, err := validateFields(request); err == nil {
+			t.Errorf("validateFields() of a %T accepted its rules", request)
+		}
+		if err := checkRules(reflect.TypeOf(request).Elem()); err == nil {
+			t.Errorf("checkRules() of a %T accepted its rules", request)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/url"
+
+	"github.com/go-bongo/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var webhookCollection *bongo.Collection
+
+// WebhookEvent is the name of an event a webhook can subscribe to
+type WebhookEvent string
+
+// Events emitted during a box lifecycle
+const (
+	EventBoxCreated   = WebhookEvent("box.created")
+	EventMemberJoined = WebhookEvent("member.joined")
+	EventNoteAdded    = WebhookEvent("note.added")
+	EventBoxOpened    = WebhookEvent("box.opened")
+	EventBoxDeleted   = WebhookEvent("box.deleted")
+	// EventPing is only sent by the test-delivery endpoint, webhooks can't subscribe to it
+	EventPing = WebhookEvent("ping")
+)
+
+var webhookEvents = []WebhookEvent{EventBoxCreated, EventMemberJoined, EventNoteAdded, EventBoxOpened, EventBoxDeleted}
+
+// Webhook is a document which holds a subscription to box events. If Box is nil, the webhook receives
+// events from every box its owner is registered in
+type Webhook struct {
+	bongo.DocumentBase `bson:",inline"`
+	Owner              bson.ObjectId  `bson:"owner"`
+	Box                *bson.ObjectId `bson:"box,omitempty"`
+	URL                string         `bson:"url"`
+	Secret             string         `bson:"secret"`
+	Events             []WebhookEvent `bson:"events"`
+	Active             bool           `bson:"active"`
+}
+
+// WebhookRequest is a struct that resembles a request performed by users to create or edit a webhook
+type WebhookRequest struct {
+	URL    string         `json:"url"`
+	Box    *bson.ObjectId `json:"box,omitempty"`
+	Events []WebhookEvent `json:"events"`
+	Active *bool          `json:"active,omitempty"`
+}
+
+// WebhookResponse is a struct that resembles a response for webhook detail and listing
+type WebhookResponse struct {
+	ID     bson.ObjectId  `json:"id"`
+	URL    string         `json:"url"`
+	Box    *bson.ObjectId `json:"box,omitempty"`
+	Events []WebhookEvent `json:"events"`
+	Active bool           `json:"active"`
+}
+
+// WebhookCreatedResponse is returned only once, when the webhook is created, as it holds the signing secret
+type WebhookCreatedResponse struct {
+	WebhookResponse
+	Secret string `json:"secret"`
+}
+
+// WebhookList is a list of Webhook documents
+type WebhookList []Webhook
+
+// WebhookListResponse is a list of WebhookResponse
+type WebhookListResponse []WebhookResponse
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic("Could not generate webhook secret ", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewWebhook returns a new Webhook owned by user with a freshly generated secret
+func NewWebhook(request WebhookRequest, user User) *Webhook {
+	webhook := &Webhook{
+		Owner:  user.GetId(),
+		Secret: newWebhookSecret(),
+		Active: true,
+	}
+	webhook.apply(request)
+	return webhook
+}
+
+func (w *Webhook) apply(request WebhookRequest) {
+	w.URL = request.URL
+	w.Box = request.Box
+	w.Events = request.Events
+	if request.Active != nil {
+		w.Active = *request.Active
+	}
+}
+
+/*
+blockedNetworks are the loopback, private, link-local, shared and reserved ranges. Webhooks can't point to them, so
+they can't be used to reach the server itself, the cloud metadata endpoint or the internal network
+*/
+var blockedNetworks = parseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24",
+	"192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4",
+	"240.0.0.0/4", "::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Panic("Invalid blocked network ", cidr)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// IsPublicIP returns whether ip is a public unicast address, which webhooks are allowed to be delivered to
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// LookupPublicIPs resolves host and returns its addresses, or an error if any of them is not public
+func LookupPublicIPs(host string) ([]net.IP, error) {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(host); err != nil {
+			return nil, err
+		}
+	}
+	for _, ip := range ips {
+		if !IsPublicIP(ip) {
+			return nil, ErrWebhookAddressNotAllowed
+		}
+	}
+	return ips, nil
+}
+
+// ErrWebhookAddressNotAllowed is returned when a webhook URL points to an address which is not public
+var ErrWebhookAddressNotAllowed = NewFieldError("url", "Field url must point to a public address")
+
+func isWebhookEvent(event WebhookEvent) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return NewFieldError("url", "Field url must be an absolute http or https URL")
+	}
+	if _, err := LookupPublicIPs(u.Hostname()); err == ErrWebhookAddressNotAllowed {
+		return err
+	} else if err != nil {
+		return NewFieldError("url", "Host %q could not be resolved", u.Hostname())
+	}
+	if len(w.Events) == 0 {
+		return NewFieldError("events", "At least one event is required")
+	}
+	for _, event := range w.Events {
+		if !isWebhookEvent(event) {
+			return NewFieldError("events", "Unknown event %q", event)
+		}
+	}
+	if w.Box != nil {
+		box, err := GetBoxByID(w.Box.Hex())
+		if err != nil {
+			return NewFieldError("box", "Box does not exist")
+		}
+		if !box.IsUserRegistered(User{DocumentBase: bongo.DocumentBase{Id: w.Owner}}) {
+			return NewError(KindForbidden, "You are not registered in this box")
+		}
+	}
+	return nil
+}
+
+// Save saves a Webhook instance into database
+func (w *Webhook) Save() error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+	return webhookCollection.Save(w)
+}
+
+// Update updates a Webhook instance from database
+func (w *Webhook) Update(request WebhookRequest) error {
+	w.apply(request)
+	return w.Save()
+}
+
+// Delete deletes a Webhook instance and its delivery log from database
+func (w *Webhook) Delete() error {
+	if _, err := webhookDeliveryCollection.Delete(bson.M{"webhook": w.GetId()}); err != nil {
+		return err
+	}
+	return webhookCollection.DeleteDocument(w)
+}
+
+// IsOwnedBy returns whether user owns the webhook
+func (w *Webhook) IsOwnedBy(user User) bool {
+	return w.Owner == user.GetId()
+}
+
+// Subscribes returns whether the webhook wants to receive event
+func (w *Webhook) Subscribes(event WebhookEvent) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// GetResponse returns a WebhookResponse
+func (w *Webhook) GetResponse() WebhookResponse {
+	return WebhookResponse{
+		ID:     w.GetId(),
+		URL:    w.URL,
+		Box:    w.Box,
+		Events: w.Events,
+		Active: w.Active,
+	}
+}
+
+// GetWebhookByID returns a webhook searching by id
+func GetWebhookByID(id string) (webhook Webhook, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return webhook, newNotFoundError("Webhook")
+	}
+
+	err = webhookCollection.FindById(bson.ObjectIdHex(id), &webhook)
+	if err != nil {
+		if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+			return webhook, newNotFoundError("Webhook")
+		}
+		log.Panic("WTF", err.Error())
+	}
+	return
+}
+
+func findWebhooks(query bson.M) (webhooks WebhookList) {
+	webhooks = make(WebhookList, 0)
+	results := webhookCollection.Find(query)
+
+	webhook := Webhook{}
+	for results.Next(&webhook) {
+		webhooks = append(webhooks, webhook)
+	}
+	return
+}
+
+// GetWebhookListResponse returns the webhooks owned by user
+func GetWebhookListResponse(user User) WebhookListResponse {
+	webhooks := findWebhooks(bson.M{"owner": user.GetId()})
+	responses := make(WebhookListResponse, len(webhooks))
+	for i, webhook := range webhooks {
+		responses[i] = webhook.GetResponse()
+	}
+	return responses
+}
+
+// ListWebhooksForEvent returns the active webhooks subscribed to event on box whose owner is registered in the
+// box, that is, the ones attached to the box and the user level ones
+func ListWebhooksForEvent(event WebhookEvent, box Box) WebhookList {
+	return findWebhooks(bson.M{
+		"active": true,
+		"events": event,
+		"owner":  bson.M{"$in": box.Users},
+		"$or":    []bson.M{{"box": box.GetId()}, {"box": bson.M{"$exists": false}}},
+	})
+}
+
+// DeleteBoxWebhooks deletes the webhooks user attached to box and their delivery logs, once they leave it
+func DeleteBoxWebhooks(box Box, user User) error {
+	for _, webhook := range findWebhooks(bson.M{"box": box.GetId(), "owner": user.GetId()}) {
+		if err := webhook.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jenarvaezg/magicbox/jobs"
+	"github.com/jenarvaezg/magicbox/mailer"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SendJobType is the type of the jobs that send an email
+const SendJobType = "mail.send"
+
+const dateFormat = "Monday, January 2 2006 at 15:04 MST"
+
+// mailData is what every mail template gets
+type mailData struct {
+	Name     string
+	Box      string
+	OpenDate string
+	Inviter  string
+	URL      string
+}
+
+// Register makes pool send the queued emails with mailer.DefaultMailer
+func Register(pool *jobs.Pool) {
+	pool.Handle(SendJobType, sendJob)
+}
+
+func sendJob(job *jobs.Job) error {
+	var message mailer.Message
+	if err := job.Decode(&message); err != nil {
+		return jobs.Permanent(err)
+	}
+	return mailer.DefaultMailer.Send(message)
+}
+
+// frontendURL returns the absolute URL of path in the web frontend
+func frontendURL(format string, args ...interface{}) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + fmt.Sprintf(format, args...)
+}
+
+func displayName(user models.User) string {
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return user.Username
+}
+
+func newBoxData(box models.Box) mailData {
+	return mailData{
+		Box:      box.Name,
+		OpenDate: box.OpenDate.UTC().Format(dateFormat),
+		URL:      frontendURL("/box/%s", box.GetId().Hex()),
+	}
+}
+
+// enqueue queues an email rendered from template for user, unless they muted kind
+func enqueue(user models.User, kind models.NotificationKind, template string, data mailData) {
+	if user.WantsNotification(kind) {
+		send(user, template, data)
+	}
+}
+
+// send queues an email rendered from template for user
+func send(user models.User, template string, data mailData) {
+	queue(user, template, data, jobs.Enqueue)
+}
+
+/*
+sendSecret queues an email rendered from template for user whose link carries a token. The job payload is
+removed once the email is sent or given up on, so the token doesn't outlive it in the jobs collection
+*/
+func sendSecret(user models.User, template string, data mailData) {
+	queue(user, template, data, jobs.EnqueueSensitive)
+}
+
+func queue(user models.User, template string, data mailData, enqueue func(string, interface{}) error) {
+	if user.Email == "" {
+		return
+	}
+	data.Name = displayName(user)
+	message, err := mailer.Render(template, user.Email, data)
+	if err != nil {
+		log.Println("Could not render email", template, err)
+		return
+	}
+	if err := enqueue(SendJobType, message); err != nil {
+		log.Println("Could not enqueue email", template, err)
+	}
+}
+
+func notifyUsers(ids []bson.ObjectId, box models.Box, kind models.NotificationKind, template string) {
+	for _, id := range ids {
+		user, err := models.GetUserByID(id.Hex())
+		if err != nil {
+			continue
+		}
+		enqueue(user, kind, template, newBoxData(box))
+	}
+}
+
+// BoxOpened emails every member of box telling it has opened
+func BoxOpened(box models.Box) {
+	notifyUsers(box.Users, box, models.NotifyBoxOpened, mailer.TemplateBoxOpened)
+}
+
+// ContributionReminder emails the members of box that have not written a note yet telling it opens soon
+func ContributionReminder(box models.Box) {
+	notifyUsers(box.GetPendingContributors(), box, models.NotifyDeadlineReminder, mailer.TemplateDeadlineReminder)
+}
+
+// InvitationReceived emails invitee telling inviter invited them to box
+func InvitationReceived(box models.Box, inviter, invitee models.User) {
+	data := newBoxData(box)
+	data.Inviter = displayName(inviter)
+	data.URL = frontendURL("/invitations")
+	enqueue(invitee, models.NotifyInvitation, mailer.TemplateInvitation, data)
+}
+
+// EmailVerification emails user the link to verify their email, it can't be muted
+func EmailVerification(user models.User, token string) {
+	sendSecret(user, mailer.TemplateVerifyEmail, mailData{URL: frontendURL("/verify?token=%s", url.QueryEscape(token))})
+}
+
+// PasswordReset emails user the link to set a new password, it can't be muted
+func PasswordReset(user models.User, token string) {
+	sendSecret(user, mailer.TemplatePasswordReset, mailData{URL: frontendURL("/reset-password?token=%s", url.QueryEscape(token))})
+}
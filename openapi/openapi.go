@@ -0,0 +1,268 @@
+/*
+Package openapi generates the OpenAPI document of the API from its mux routes. Every route must be described by an
+Endpoint, whose request and response types are turned into schemas by reflection the way encoding/json
+serializes them, so the document can't drift from the structs. Routes and endpoints which don't match each other
+are reported as a DriftError.
+*/
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const jsonMediaType = "application/json"
+
+// Endpoint describes a route of some method, Request and Response are values of the types of the bodies
+type Endpoint struct {
+	ID      string
+	Summary string
+	// Tag groups the endpoint, it defaults to the first segment of the path after the base path of the API
+	Tag string
+	// Public endpoints don't require an access token
+	Public bool
+	// Query holds the description of the query parameters by their name
+	Query    map[string]string
+	Request  interface{}
+	Consumes string
+	// Status is the status of a successful response, it defaults to 200, or 204 when it has no body
+	Status   int
+	Response interface{}
+	// List responses are wrapped in the results of an object, as utils.ResponseJSON does
+	List     bool
+	Produces string
+	// Error is the body of error responses, it defaults to the problem of the API
+	Error interface{}
+}
+
+// OneOf is a Response which may be any of its values
+type OneOf []interface{}
+
+// API describes the routes of a router, its endpoints are keyed by method and path, like "GET /api/v1/box/{id}"
+type API struct {
+	Title       string
+	Description string
+	Version     string
+	BasePath    string
+	// Problem is the body of error responses, sent as application/problem+json
+	Problem   interface{}
+	Endpoints map[string]Endpoint
+}
+
+// DriftError lists the differences between the routes and the endpoints of an API
+type DriftError []string
+
+func (e DriftError) Error() string {
+	return "OpenAPI document is out of date:\n\t" + strings.Join(e, "\n\t")
+}
+
+// route is a method of a mux route, its path has the regular expressions of its variables removed
+type route struct {
+	method     string
+	path       string
+	parameters []Parameter
+}
+
+func (r route) key() string {
+	return r.method + " " + r.path
+}
+
+/*
+Generate returns the document of the routes of router. When they aren't described by the endpoints it returns a
+DriftError along with the document, which leaves the undocumented routes out
+*/
+func (api API) Generate(router *mux.Router) (*Document, error) {
+	routes, err := walkRoutes(router)
+	if err != nil {
+		return nil, err
+	}
+	schemas := newSchemaSet()
+	document := &Document{
+		OpenAPI:  Version,
+		Info:     Info{Title: api.Title, Description: api.Description, Version: api.Version},
+		Paths:    make(map[string]PathItem),
+		Security: []SecurityRequirement{{"bearerAuth": {}}},
+		Components: Components{
+			Schemas: schemas.components,
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+
+	var drift DriftError
+	routed := make(map[string]bool)
+	operationIDs := make(map[string]string)
+	for _, route := range routes {
+		key := route.key()
+		routed[key] = true
+		endpoint, ok := api.Endpoints[key]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("%s is routed but not documented", key))
+			continue
+		}
+		if other, taken := operationIDs[endpoint.ID]; taken {
+			drift = append(drift, fmt.Sprintf("%s has the operation id %q of %s", key, endpoint.ID, other))
+		} else if endpoint.ID == "" {
+			drift = append(drift, fmt.Sprintf("%s has no operation id", key))
+		}
+		operationIDs[endpoint.ID] = key
+		if document.Paths[route.path] == nil {
+			document.Paths[route.path] = make(PathItem)
+		}
+		document.Paths[route.path][strings.ToLower(route.method)] = api.operation(schemas, route, endpoint)
+	}
+	for key := range api.Endpoints {
+		if !routed[key] {
+			drift = append(drift, fmt.Sprintf("%s is documented but not routed", key))
+		}
+	}
+	drift = append(drift, schemas.drift...)
+	if len(drift) > 0 {
+		sort.Strings(drift)
+		return document, drift
+	}
+	return document, nil
+}
+
+func (api API) operation(schemas *schemaSet, route route, endpoint Endpoint) *Operation {
+	operation := &Operation{
+		OperationID: endpoint.ID,
+		Summary:     endpoint.Summary,
+		Tags:        []string{api.tag(route, endpoint)},
+		Parameters:  route.parameters,
+		Responses:   make(map[string]Response),
+	}
+	if endpoint.Public {
+		operation.Security = &[]SecurityRequirement{}
+	}
+	for _, name := range sortedKeys(endpoint.Query) {
+		operation.Parameters = append(operation.Parameters, Parameter{
+			Name: name, In: "query", Description: endpoint.Query[name], Schema: &Schema{Type: "string"},
+		})
+	}
+	if endpoint.Request != nil {
+		operation.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+			orDefault(endpoint.Consumes, jsonMediaType): {Schema: schemas.schemaOf(endpoint.Request)},
+		}}
+	}
+
+	status, response := endpoint.Status, Response{}
+	switch {
+	case endpoint.Response != nil:
+		schema := schemas.schemaOf(endpoint.Response)
+		if endpoint.List {
+			schema = &Schema{Type: "object", Properties: map[string]*Schema{"results": schema}, Required: []string{"results"}}
+		}
+		response.Content = map[string]MediaType{orDefault(endpoint.Produces, jsonMediaType): {Schema: schema}}
+	case endpoint.Produces != "":
+		response.Content = map[string]MediaType{endpoint.Produces: {Schema: &Schema{Type: "string", Format: "binary"}}}
+	case status == 0:
+		status = http.StatusNoContent
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response.Description = http.StatusText(status)
+	operation.Responses[strconv.Itoa(status)] = response
+
+	errorMediaType, errorBody := "application/problem+json", api.Problem
+	if endpoint.Error != nil {
+		errorMediaType, errorBody = jsonMediaType, endpoint.Error
+	}
+	operation.Responses["default"] = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{errorMediaType: {Schema: schemas.schemaOf(errorBody)}},
+	}
+	return operation
+}
+
+func (api API) tag(route route, endpoint Endpoint) string {
+	if endpoint.Tag != "" {
+		return endpoint.Tag
+	}
+	path := strings.TrimPrefix(route.path, api.BasePath)
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")[0]
+}
+
+// walkRoutes returns every method of the routes of router, routes without methods are the prefixes of subrouters
+func walkRoutes(router *mux.Router) ([]route, error) {
+	var routes []route
+	err := router.Walk(func(muxRoute *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := muxRoute.GetMethods()
+		if err != nil || len(methods) == 0 {
+			return nil
+		}
+		template, err := muxRoute.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path, parameters := parsePathTemplate(template)
+		for _, method := range methods {
+			routes = append(routes, route{method: method, path: path, parameters: parameters})
+		}
+		return nil
+	})
+	return routes, err
+}
+
+var alternativesRegexp = regexp.MustCompile(`^\w+(\|\w+)*$`)
+
+// parsePathTemplate removes the patterns of the variables of a mux path template, which become path parameters
+func parsePathTemplate(template string) (string, []Parameter) {
+	var path bytes.Buffer
+	var parameters []Parameter
+	for {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			break
+		}
+		end, depth := start, 0
+		for ; end < len(template); end++ {
+			if template[end] == '{' {
+				depth++
+			} else if template[end] == '}' {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		variable := strings.SplitN(template[start+1:end], ":", 2)
+		schema := &Schema{Type: "string"}
+		if len(variable) == 2 {
+			if alternativesRegexp.MatchString(variable[1]) {
+				schema.Enum = strings.Split(variable[1], "|")
+			} else {
+				schema.Pattern = "^" + variable[1] + "$"
+			}
+		}
+		parameters = append(parameters, Parameter{Name: variable[0], In: "path", Required: true, Schema: schema})
+		path.WriteString(template[:start] + "{" + variable[0] + "}")
+		template = template[end+1:]
+	}
+	path.WriteString(template)
+	return path.String(), parameters
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
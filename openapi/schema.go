@@ -0,0 +1,176 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const schemaRefBase = "#/components/schemas/"
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	objectIDType = reflect.TypeOf(bson.ObjectId(""))
+)
+
+// schemaSet builds the schemas of Go types, structs are added to the components and referenced by name
+type schemaSet struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+	// drift holds the fields which are serialized under their Go name, because they lack a json tag
+	drift []string
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{components: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+// schemaOf returns the schema of the value v, a OneOf is any of the schemas of its values
+func (s *schemaSet) schemaOf(v interface{}) *Schema {
+	if values, ok := v.(OneOf); ok {
+		schema := &Schema{}
+		for _, value := range values {
+			schema.OneOf = append(schema.OneOf, s.schemaOf(value))
+		}
+		return schema
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemaSet) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64"}
+	case objectIDType:
+		return &Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schema(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		return s.ref(t)
+	}
+	return &Schema{}
+}
+
+// ref adds the struct t to the components the first time it is seen, and returns a reference to it
+func (s *schemaSet) ref(t reflect.Type) *Schema {
+	name, ok := s.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := s.components[name]; taken {
+			name = strings.Title(pkgName(t)) + name
+		}
+		s.names[t] = name
+		// Saved before building it, so structs which contain themselves reference it
+		s.components[name] = &Schema{}
+		*s.components[name] = *s.structSchema(t)
+	}
+	return &Schema{Ref: schemaRefBase + name}
+}
+
+func pkgName(t reflect.Type) string {
+	path := t.PkgPath()
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func (s *schemaSet) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.addFields(schema, t)
+	return schema
+}
+
+// addFields adds the fields of t to the properties of schema, the way encoding/json serializes them
+func (s *schemaSet) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(schema, field.Type)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if !hasTag {
+			s.drift = append(s.drift, fmt.Sprintf("field %s.%s has no json tag", t.Name(), field.Name))
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := s.schema(field.Type)
+		if required := applyValidation(property, field.Tag.Get("validate")); required {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// applyValidation documents the validate tag of a field in its schema, it returns whether the field is required
+func applyValidation(schema *Schema, tag string) bool {
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		parts := strings.SplitN(rule, "=", 2)
+		switch parts[0] {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "url":
+			schema.Format = "uri"
+		case "min", "max":
+			if len(parts) < 2 {
+				continue
+			}
+			limit, err := strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+			switch {
+			case schema.Type == "array" && parts[0] == "max":
+				schema.MaxItems = &limit
+			case schema.Type == "string" && parts[0] == "max":
+				schema.MaxLength = &limit
+			case schema.Type == "string":
+				schema.MinLength = &limit
+			}
+		}
+	}
+	return required
+}
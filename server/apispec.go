@@ -0,0 +1,233 @@
+package server
+
+import (
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/handlers"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/openapi"
+	"github.com/jenarvaezg/magicbox/utils"
+)
+
+// TokenRequest is the form of a token request, which fields are required depends on its grant type
+type TokenRequest struct {
+	GrantType        string `json:"grant_type"`
+	Username         string `json:"username,omitempty"`
+	Password         string `json:"password,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	ClientID         string `json:"client_id,omitempty"`
+	ClientSecret     string `json:"client_secret,omitempty"`
+	Scope            string `json:"scope,omitempty"`
+	MFAToken         string `json:"mfa_token,omitempty"`
+	OTP              string `json:"otp,omitempty"`
+	SubjectToken     string `json:"subject_token,omitempty"`
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	Nonce            string `json:"nonce,omitempty"`
+}
+
+// RefreshTokenRequest is the form of a request exchanging a refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+const formMediaType = "application/x-www-form-urlencoded"
+
+// apiSpec describes every route of NewRouter, apispec_test.go fails when they drift apart
+var apiSpec = openapi.API{
+	Title:       "MagicBox",
+	Description: "Time capsules which hold the notes of their members until their open date",
+	BasePath:    baseRoute,
+	Problem:     utils.Problem{},
+	Endpoints: map[string]openapi.Endpoint{
+		// Tokens
+		"POST /login": {
+			ID: "login", Tag: "auth", Public: true,
+			Summary: "Issue a token, requests with an X-Google-Login header send a Google sign-in response as JSON instead",
+			Request: TokenRequest{}, Consumes: formMediaType, Response: auth.TokenResponse{}, Error: auth.TokenError{},
+		},
+		"POST /login/refresh": {
+			ID: "refreshToken", Tag: "auth", Public: true, Summary: "Exchange a refresh token for new tokens",
+			Request: RefreshTokenRequest{}, Consumes: formMediaType, Response: auth.TokenResponse{}, Error: auth.TokenError{},
+		},
+		"POST /login/nonce": {
+			ID: "createLoginNonce", Tag: "auth", Public: true, Summary: "Get a nonce to put in the ID token of a provider login",
+			Response: map[string]string{},
+		},
+		"POST /logout": {ID: "logout", Tag: "auth", Summary: "End the session of the requesting token"},
+		"GET /.well-known/jwks.json": {
+			ID: "getJWKS", Tag: "auth", Public: true, Summary: "Public keys tokens are signed with", Response: auth.JWKS{},
+		},
+		"GET /export/{exportID}": {
+			ID: "downloadExport", Tag: "user", Public: true, Summary: "Download a data export with its signed link",
+			Query: map[string]string{"token": "Signature of the download link"}, Produces: "application/zip",
+		},
+		"GET /api/v1/openapi.json": {
+			ID: "getOpenAPI", Tag: "meta", Public: true, Summary: "This document", Response: map[string]interface{}{},
+		},
+
+		// Boxes
+		"GET /api/v1/box": {
+			ID: "listBoxes", Summary: "List the boxes of the requesting user", Response: models.BoxListResponse{}, List: true,
+		},
+		"POST /api/v1/box": {
+			ID: "createBox", Summary: "Create a box", Request: models.BoxRequest{}, Status: 201,
+		},
+		"GET /api/v1/box/{id}":    {ID: "getBox", Summary: "Get a box", Response: models.BoxResponse{}},
+		"DELETE /api/v1/box/{id}": {ID: "deleteBox", Summary: "Delete a box"},
+		"PATCH /api/v1/box/{id}": {
+			ID: "patchBox", Summary: "Update a box with a JSON merge patch",
+			Request: models.BoxRequest{}, Consumes: "application/merge-patch+json",
+		},
+		"POST /api/v1/box/{id}/register": {
+			ID: "registerInBox", Summary: "Become a member of a box", Request: models.BoxRegisterRequest{}, Status: 200,
+		},
+		"DELETE /api/v1/box/{id}/register": {ID: "unregisterFromBox", Summary: "Leave a box"},
+		"GET /api/v1/box/{id}/contributions": {
+			ID: "listContributions", Summary: "Which members of a box have left notes",
+			Response: models.ContributionListResponse{}, List: true,
+		},
+		"GET /api/v1/box/{id}/notes": {
+			ID: "listNotes", Tag: "notes", Summary: "List the notes of an open box", Response: models.NoteListResponse{}, List: true,
+		},
+		"POST /api/v1/box/{id}/notes": {
+			ID: "createNote", Tag: "notes", Summary: "Leave a note in a box", Request: models.NoteRequest{}, Status: 201,
+		},
+		"DELETE /api/v1/box/{id}/notes": {
+			ID: "deleteNotes", Tag: "notes", Summary: "Delete every note of a box",
+		},
+
+		// Users
+		"GET /api/v1/user": {
+			ID: "listUsers", Summary: "List every user to admins, and the directory to everyone else",
+			Response: openapi.OneOf{models.UserListResponse{}, models.PublicUserListResponse{}}, List: true,
+		},
+		"GET /api/v1/user/search": {
+			ID: "searchUsers", Summary: "Search the directory by username prefix",
+			Query: map[string]string{"q": "Prefix of the username"}, Response: models.PublicUserListResponse{}, List: true,
+		},
+		"POST /api/v1/user": {
+			ID: "createUser", Public: true, Summary: "Sign up", Request: models.UserRequest{}, Status: 201,
+		},
+		"POST /api/v1/user/verify": {
+			ID: "verifyUser", Public: true, Summary: "Verify the email of a user", Request: models.UserVerificationRequest{},
+		},
+		"POST /api/v1/user/verify/resend": {
+			ID: "resendVerification", Public: true, Summary: "Send the verification email again",
+			Request: models.UserResendVerificationRequest{}, Status: 202,
+		},
+		"POST /api/v1/user/password/reset": {
+			ID: "requestPasswordReset", Public: true, Summary: "Email a password reset link",
+			Request: models.PasswordResetRequest{}, Status: 202,
+		},
+		"POST /api/v1/user/password/reset/confirm": {
+			ID: "confirmPasswordReset", Public: true, Summary: "Set a new password with a reset token",
+			Request: models.PasswordResetConfirmRequest{},
+		},
+		"GET /api/v1/user/{id}": {
+			ID: "getUser", Summary: "Get a user, others only see their public profile",
+			Response: openapi.OneOf{models.UserResponse{}, models.PublicUserResponse{}},
+		},
+		"DELETE /api/v1/user/{id}": {
+			ID: "deleteUser", Summary: "Schedule the deletion of an account",
+			Query:  map[string]string{"notes": "What happens to the notes of the user, anonymize or delete"},
+			Status: 202, Response: models.AccountDeletionResponse{},
+		},
+		"PATCH /api/v1/user/{id}": {
+			ID: "patchUser", Summary: "Update a user with a JSON merge patch",
+			Request: models.UserRequest{}, Consumes: "application/merge-patch+json",
+		},
+		"GET /api/v1/user/{id}/deletion": {
+			ID: "getUserDeletion", Summary: "Get the scheduled deletion of an account", Response: models.AccountDeletionResponse{},
+		},
+		"DELETE /api/v1/user/{id}/deletion": {ID: "cancelUserDeletion", Summary: "Cancel the deletion of an account"},
+		"GET /api/v1/user/{id}/sessions": {
+			ID: "listSessions", Summary: "List the sessions of a user", Response: models.SessionListResponse{}, List: true,
+		},
+		"DELETE /api/v1/user/{id}/sessions":             {ID: "revokeSessions", Summary: "Log a user out everywhere"},
+		"DELETE /api/v1/user/{id}/sessions/{sessionID}": {ID: "revokeSession", Summary: "Revoke a session"},
+		"GET /api/v1/user/{id}/identities": {
+			ID: "listIdentities", Summary: "List the external identities of a user",
+			Response: models.IdentityListResponse{}, List: true,
+		},
+		"POST /api/v1/user/{id}/identities": {
+			ID: "linkIdentity", Summary: "Link an external identity", Request: handlers.IdentityLinkRequest{},
+			Status: 201, Response: models.IdentityListResponse{},
+		},
+		"DELETE /api/v1/user/{id}/identities/{provider}": {ID: "unlinkIdentity", Summary: "Unlink an external identity"},
+		"POST /api/v1/user/{id}/mfa/totp": {
+			ID: "startTOTP", Summary: "Start the enrollment of an authenticator app",
+			Status: 201, Response: models.TOTPEnrollment{},
+		},
+		"POST /api/v1/user/{id}/mfa/totp/confirm": {
+			ID: "confirmTOTP", Summary: "Confirm the enrollment of an authenticator app, answering the recovery codes",
+			Request: models.MFACodeRequest{}, Response: models.RecoveryCodesResponse{}, List: true,
+		},
+		"POST /api/v1/user/{id}/mfa/totp/disable": {
+			ID: "disableTOTP", Summary: "Disable two-factor authentication", Request: handlers.MFADisableRequest{},
+		},
+		"POST /api/v1/user/{id}/export": {
+			ID: "requestExport", Status: 202, Response: models.DataExportResponse{},
+			Summary: "Export the personal data of a user in the background, or get the export already pending or ready",
+		},
+		"GET /api/v1/user/{id}/export/{exportID}": {
+			ID: "getExport", Summary: "Get a data export, with its download link once ready", Response: models.DataExportResponse{},
+		},
+		"GET /api/v1/user/{id}/tokens": {
+			ID: "listPersonalAccessTokens", Summary: "List the personal access tokens of a user",
+			Response: models.PersonalAccessTokenListResponse{}, List: true,
+		},
+		"POST /api/v1/user/{id}/tokens": {
+			ID: "createPersonalAccessToken", Summary: "Create a personal access token, answered only once",
+			Request: models.PersonalAccessTokenRequest{}, Status: 201, Response: models.PersonalAccessTokenCreatedResponse{},
+		},
+		"DELETE /api/v1/user/{id}/tokens/{tokenID}": {
+			ID: "revokePersonalAccessToken", Summary: "Revoke a personal access token",
+		},
+
+		// Webhooks
+		"GET /api/v1/webhook": {
+			ID: "listWebhooks", Summary: "List the webhooks of the requesting user", Response: models.WebhookListResponse{}, List: true,
+		},
+		"POST /api/v1/webhook": {
+			ID: "createWebhook", Summary: "Create a webhook, its signing secret is answered only once",
+			Request: models.WebhookRequest{}, Status: 201, Response: models.WebhookCreatedResponse{},
+		},
+		"GET /api/v1/webhook/{id}":    {ID: "getWebhook", Summary: "Get a webhook", Response: models.WebhookResponse{}},
+		"DELETE /api/v1/webhook/{id}": {ID: "deleteWebhook", Summary: "Delete a webhook"},
+		"PATCH /api/v1/webhook/{id}":  {ID: "updateWebhook", Summary: "Update a webhook", Request: models.WebhookRequest{}},
+		"POST /api/v1/webhook/{id}/test": {
+			ID: "testWebhook", Summary: "Send a ping to a webhook", Response: models.WebhookDeliveryResponse{},
+		},
+		"GET /api/v1/webhook/{id}/deliveries": {
+			ID: "listWebhookDeliveries", Summary: "List the last deliveries of a webhook",
+			Response: models.WebhookDeliveryListResponse{}, List: true,
+		},
+
+		// API clients
+		"GET /api/v1/client": {
+			ID: "listAPIClients", Summary: "List the API clients of the requesting user",
+			Response: models.APIClientListResponse{}, List: true,
+		},
+		"POST /api/v1/client": {
+			ID: "createAPIClient", Summary: "Create an API client, its secret is answered only once",
+			Request: models.APIClientRequest{}, Status: 201, Response: models.APIClientCreatedResponse{},
+		},
+		"GET /api/v1/client/{id}":    {ID: "getAPIClient", Summary: "Get an API client", Response: models.APIClientResponse{}},
+		"DELETE /api/v1/client/{id}": {ID: "deleteAPIClient", Summary: "Delete an API client"},
+
+		// Admin
+		"GET /api/v1/admin/lockouts/{kind}/{value}": {
+			ID: "getLockout", Summary: "Get the failed logins of a user or an IP", Response: auth.LockoutResponse{},
+		},
+		"DELETE /api/v1/admin/lockouts/{kind}/{value}": {ID: "unlock", Summary: "Clear the failed logins of a user or an IP"},
+		"PUT /api/v1/admin/user/{id}/role": {
+			ID: "setUserRole", Summary: "Grant or revoke the admin role", Request: models.UserRoleRequest{},
+			Response: models.UserResponse{},
+		},
+		"PUT /api/v1/admin/user/{id}/suspension": {
+			ID: "suspendUser", Summary: "Suspend an account", Request: models.SuspensionRequest{}, Response: models.UserResponse{},
+		},
+		"DELETE /api/v1/admin/user/{id}/suspension": {
+			ID: "reactivateUser", Summary: "Reactivate a suspended account", Response: models.UserResponse{},
+		},
+	},
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jenarvaezg/magicbox/openapi"
+)
+
+// bodyPackages are the packages whose request and response types are sent by the API, by their import path
+var bodyPackages = map[string]string{
+	".":           "github.com/jenarvaezg/magicbox/server",
+	"../auth":     "github.com/jenarvaezg/magicbox/auth",
+	"../handlers": "github.com/jenarvaezg/magicbox/handlers",
+	"../models":   "github.com/jenarvaezg/magicbox/models",
+}
+
+// notBodies are the request types which aren't sent as a body, with the reason
+var notBodies = map[string]string{
+	"github.com/jenarvaezg/magicbox/models.AccountDeletionRequest": "it is the query of DELETE /api/v1/user/{id}",
+	"github.com/jenarvaezg/magicbox/auth.GoogleFrontendRequest":    "POST /login describes it in its summary",
+}
+
+var bodyTypeRegexp = regexp.MustCompile(`^[A-Z]\w*(Request|Response)$`)
+
+func TestAPISpecDescribesEveryRoute(t *testing.T) {
+	_, err := NewRouter("test")
+	if drift, ok := err.(openapi.DriftError); ok {
+		for _, difference := range drift {
+			t.Error(difference)
+		}
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPISpecDescribesEveryRequestAndResponse(t *testing.T) {
+	documented := make(map[string]bool)
+	for _, endpoint := range apiSpec.Endpoints {
+		for _, body := range []interface{}{endpoint.Request, endpoint.Response, endpoint.Error} {
+			if values, ok := body.(openapi.OneOf); ok {
+				for _, value := range values {
+					addBodyTypes(documented, reflect.TypeOf(value))
+				}
+			} else if body != nil {
+				addBodyTypes(documented, reflect.TypeOf(body))
+			}
+		}
+	}
+
+	for dir, importPath := range bodyPackages {
+		for _, name := range declaredBodyTypes(t, dir) {
+			name = importPath + "." + name
+			if _, ok := notBodies[name]; !ok && !documented[name] {
+				t.Errorf("%s isn't the body, or in the body, of any endpoint of the OpenAPI document", name)
+			}
+		}
+	}
+}
+
+// addBodyTypes adds the named types of t, and those of its elements and fields, to types by their full name
+func addBodyTypes(types map[string]bool, t reflect.Type) {
+	if t.Name() != "" {
+		name := t.PkgPath() + "." + t.Name()
+		if types[name] {
+			return
+		}
+		types[name] = true
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		addBodyTypes(types, t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			addBodyTypes(types, t.Field(i).Type)
+		}
+	}
+}
+
+// declaredBodyTypes returns the exported types of the package in dir named like requests or responses
+func declaredBodyTypes(t *testing.T, dir string) []string {
+	notTest := func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}
+	packages, err := parser.ParseDir(token.NewFileSet(), dir, notTest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.TYPE {
+					for _, spec := range decl.Specs {
+						if name := spec.(*ast.TypeSpec).Name.Name; bodyTypeRegexp.MatchString(name) {
+							names = append(names, name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,236 @@
+// Package server sets up the routes of the API with their middlewares, and describes them in its OpenAPI document
+package server
+
+import (
+	"net/http"
+
+	"github.com/jenarvaezg/magicbox/handlers"
+	"github.com/jenarvaezg/magicbox/middleware"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/openapi"
+	"github.com/rs/cors"
+	"github.com/urfave/negroni"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	baseRoute          string = "/api/v1"
+	boxRoute           string = "/box"
+	notesRoute         string = "/notes"
+	userRoute          string = "/user"
+	webhookRoute       string = "/webhook"
+	clientRoute        string = "/client"
+	adminRoute         string = "/admin"
+	lockoutsRoute      string = "/lockouts"
+	roleRoute          string = "/role"
+	suspensionRoute    string = "/suspension"
+	searchRoute        string = "/search"
+	deletionRoute      string = "/deletion"
+	exportRoute        string = "/export"
+	contributionsRoute string = "/contributions"
+	verifyRoute        string = "/verify"
+	resendRoute        string = "/resend"
+	passwordResetRoute string = "/password/reset"
+	confirmRoute       string = "/confirm"
+	testRoute          string = "/test"
+	deliveriesRoute    string = "/deliveries"
+	loginRoute         string = "/login"
+	refreshRoute       string = "/refresh"
+	nonceRoute         string = "/nonce"
+	logoutRoute        string = "/logout"
+	sessionsRoute      string = "/sessions"
+	identitiesRoute    string = "/identities"
+	mfaRoute           string = "/mfa/totp"
+	tokensRoute        string = "/tokens"
+	jwksRoute          string = "/.well-known/jwks.json"
+	openAPIRoute       string = "/openapi.json"
+	register           string = "/register"
+	idRoute            string = "/{id:[0-9a-f]+}"
+)
+
+var apiCommonMiddleware *negroni.Negroni
+
+func getAPICommonMiddleware() *negroni.Negroni {
+	optionsMiddleware := cors.AllowAll()
+	return negroni.New(
+		middleware.NewRequestIDMiddleware(),
+		negroni.NewLogger(),
+		optionsMiddleware,
+		middleware.NewRequireJSONMiddleware(),
+		middleware.NewUserFromJWTMiddleware(),
+	)
+}
+
+func init() {
+	apiCommonMiddleware = getAPICommonMiddleware()
+}
+
+/*
+NewRouter sets every route up with its middlewares and returns the router the server serves, whose OpenAPI document
+has the given version. It returns an openapi.DriftError along with the router if the document, which is generated
+from the routes, doesn't describe them
+*/
+func NewRouter(version string) (http.Handler, error) {
+	middlewareRouter := mux.NewRouter()
+	router := mux.NewRouter() //two routers are neccesary due to negroni
+	// Token routes
+	tokenRouter := router.PathPrefix(loginRoute).Subrouter()
+	tokenRouter.HandleFunc("", handlers.LoginRequestHandler).Methods("POST")
+	tokenRouter.HandleFunc(refreshRoute, handlers.RefreshTokenHandler).Methods("POST")
+	tokenRouter.HandleFunc(nonceRoute, handlers.LoginNonceHandler).Methods("POST")
+	logoutRouter := router.PathPrefix(logoutRoute).Subrouter()
+	logoutRouter.HandleFunc("", handlers.LogoutHandler).Methods("POST")
+	jwksRouter := router.PathPrefix(jwksRoute).Subrouter()
+	jwksRouter.HandleFunc("", handlers.JWKSHandler).Methods("GET")
+	downloadRouter := router.PathPrefix(exportRoute).Subrouter()
+	downloadRouter.HandleFunc("/{exportID:[0-9a-f]+}", handlers.DownloadExportHandler).Methods("GET")
+	openAPIRouter := router.PathPrefix(baseRoute + openAPIRoute).Subrouter()
+	// Its handler is the document, which can only be generated once every route is set up
+	openAPIDocumentRoute := openAPIRouter.NewRoute().Path("").Methods("GET")
+
+	// API routes, delegated tokens can only use those wrapped with the scope they need
+	scoped := middleware.RequireScope
+	apiRouter := router.PathPrefix(baseRoute).Subrouter()
+	// Box router
+	boxRouter := apiRouter.PathPrefix(boxRoute).Subrouter()
+	boxRouter.HandleFunc("", scoped(models.ScopeBoxesRead, handlers.ListBoxesHandler)).Methods("GET")
+	boxRouter.HandleFunc("", scoped(models.ScopeBoxesWrite, handlers.CreateBoxHandler)).Methods("POST")
+	//Box detail routes
+	boxDetailRouter := boxRouter.PathPrefix(idRoute).Subrouter()
+	boxDetailRouter.HandleFunc("", scoped(models.ScopeBoxesRead, handlers.BoxDetailHandler)).Methods("GET")
+	boxDetailRouter.HandleFunc("", scoped(models.ScopeBoxesWrite, handlers.BoxDeleteHandler)).Methods("DELETE")
+	boxDetailRouter.HandleFunc("", scoped(models.ScopeBoxesWrite, handlers.BoxPatchHandler)).Methods("PATCH")
+	//Box register routes
+	boxRegisterRouter := boxDetailRouter.PathPrefix(register).Subrouter()
+	boxRegisterRouter.HandleFunc("", scoped(models.ScopeBoxesWrite, handlers.RegisterInBoxHandler)).Methods("POST")
+	boxRegisterRouter.HandleFunc("", scoped(models.ScopeBoxesWrite, handlers.RemoveFromBoxHandler)).Methods("DELETE")
+	boxDetailRouter.HandleFunc(contributionsRoute, scoped(models.ScopeBoxesRead, handlers.BoxContributionsHandler)).Methods("GET")
+	// Note routes
+	noteRouter := boxDetailRouter.PathPrefix(notesRoute).Subrouter()
+	noteRouter.HandleFunc("", scoped(models.ScopeNotesRead, handlers.ListNotesHandler)).Methods("GET")
+	noteRouter.HandleFunc("", scoped(models.ScopeNotesWrite, handlers.InsertNoteHandler)).Methods("POST")
+	noteRouter.HandleFunc("", scoped(models.ScopeNotesWrite, handlers.DeleteNotesHandler)).Methods("DELETE")
+	// User routes
+	userRouter := apiRouter.PathPrefix(userRoute).Subrouter()
+	userRouter.HandleFunc("", scoped(models.ScopeUsersRead, handlers.ListUsersHandler)).Methods("GET")
+	userRouter.HandleFunc(searchRoute, scoped(models.ScopeUsersRead, handlers.SearchUsersHandler)).Methods("GET")
+	userRouter.HandleFunc("", handlers.CreateUserHandler).Methods("POST").Name("create-user-url")
+	userRouter.HandleFunc(verifyRoute, handlers.VerifyUserHandler).Methods("POST")
+	userRouter.HandleFunc(verifyRoute+resendRoute, handlers.ResendVerificationHandler).Methods("POST")
+	userRouter.HandleFunc(passwordResetRoute, handlers.RequestPasswordResetHandler).Methods("POST")
+	userRouter.HandleFunc(passwordResetRoute+confirmRoute, handlers.ConfirmPasswordResetHandler).Methods("POST")
+	// User detail routes
+	userDetailRouter := userRouter.PathPrefix(idRoute).Subrouter()
+	userDetailRouter.HandleFunc("", scoped(models.ScopeUsersRead, handlers.UserDetailHandler)).Methods("GET")
+	userDetailRouter.HandleFunc("", scoped(models.ScopeUsersWrite, handlers.UserDeleteHandler)).Methods("DELETE")
+	userDetailRouter.HandleFunc("", scoped(models.ScopeUsersWrite, handlers.UserPatchHandler)).Methods("PATCH")
+	userDetailRouter.HandleFunc(deletionRoute, scoped(models.ScopeUsersRead, handlers.UserDeletionHandler)).Methods("GET")
+	userDetailRouter.HandleFunc(deletionRoute, scoped(models.ScopeUsersWrite, handlers.CancelUserDeletionHandler)).Methods("DELETE")
+	userDetailRouter.HandleFunc(sessionsRoute, handlers.ListSessionsHandler).Methods("GET")
+	userDetailRouter.HandleFunc(sessionsRoute, handlers.RevokeSessionsHandler).Methods("DELETE")
+	userDetailRouter.HandleFunc(sessionsRoute+"/{sessionID:[0-9a-f]+}", handlers.RevokeSessionHandler).Methods("DELETE")
+	userDetailRouter.HandleFunc(identitiesRoute, handlers.ListIdentitiesHandler).Methods("GET")
+	userDetailRouter.HandleFunc(identitiesRoute, handlers.LinkIdentityHandler).Methods("POST")
+	userDetailRouter.HandleFunc(identitiesRoute+"/{provider}", handlers.UnlinkIdentityHandler).Methods("DELETE")
+	userDetailRouter.HandleFunc(mfaRoute, handlers.StartTOTPHandler).Methods("POST")
+	userDetailRouter.HandleFunc(mfaRoute+confirmRoute, handlers.ConfirmTOTPHandler).Methods("POST")
+	userDetailRouter.HandleFunc(mfaRoute+"/disable", handlers.DisableTOTPHandler).Methods("POST")
+	userDetailRouter.HandleFunc(exportRoute, handlers.RequestExportHandler).Methods("POST")
+	userDetailRouter.HandleFunc(exportRoute+"/{exportID:[0-9a-f]+}", handlers.ExportDetailHandler).Methods("GET")
+	userDetailRouter.HandleFunc(tokensRoute, handlers.ListPersonalAccessTokensHandler).Methods("GET")
+	userDetailRouter.HandleFunc(tokensRoute, handlers.CreatePersonalAccessTokenHandler).Methods("POST")
+	userDetailRouter.HandleFunc(tokensRoute+"/{tokenID:[0-9a-f]+}", handlers.RevokePersonalAccessTokenHandler).Methods("DELETE")
+	// Webhook routes
+	webhookRouter := apiRouter.PathPrefix(webhookRoute).Subrouter()
+	webhookRouter.HandleFunc("", scoped(models.ScopeWebhooksRead, handlers.ListWebhooksHandler)).Methods("GET")
+	webhookRouter.HandleFunc("", scoped(models.ScopeWebhooksWrite, handlers.CreateWebhookHandler)).Methods("POST")
+	// Webhook detail routes
+	webhookDetailRouter := webhookRouter.PathPrefix(idRoute).Subrouter()
+	webhookDetailRouter.HandleFunc("", scoped(models.ScopeWebhooksRead, handlers.WebhookDetailHandler)).Methods("GET")
+	webhookDetailRouter.HandleFunc("", scoped(models.ScopeWebhooksWrite, handlers.WebhookDeleteHandler)).Methods("DELETE")
+	webhookDetailRouter.HandleFunc("", scoped(models.ScopeWebhooksWrite, handlers.WebhookPatchHandler)).Methods("PATCH")
+	webhookDetailRouter.HandleFunc(testRoute, scoped(models.ScopeWebhooksWrite, handlers.WebhookTestHandler)).Methods("POST")
+	webhookDetailRouter.HandleFunc(deliveriesRoute, scoped(models.ScopeWebhooksRead, handlers.WebhookDeliveriesHandler)).Methods("GET")
+	// API client routes
+	clientRouter := apiRouter.PathPrefix(clientRoute).Subrouter()
+	clientRouter.HandleFunc("", handlers.ListAPIClientsHandler).Methods("GET")
+	clientRouter.HandleFunc("", handlers.CreateAPIClientHandler).Methods("POST")
+	clientDetailRouter := clientRouter.PathPrefix(idRoute).Subrouter()
+	clientDetailRouter.HandleFunc("", handlers.APIClientDetailHandler).Methods("GET")
+	clientDetailRouter.HandleFunc("", handlers.APIClientDeleteHandler).Methods("DELETE")
+	// Admin routes
+	adminRouter := apiRouter.PathPrefix(adminRoute).Subrouter()
+	lockoutRoute := lockoutsRoute + "/{kind:user|ip}/{value}"
+	adminRouter.HandleFunc(lockoutRoute, handlers.LockoutDetailHandler).Methods("GET")
+	adminRouter.HandleFunc(lockoutRoute, handlers.UnlockHandler).Methods("DELETE")
+	adminRouter.HandleFunc(userRoute+idRoute+roleRoute, handlers.UserRoleHandler).Methods("PUT")
+	adminRouter.HandleFunc(userRoute+idRoute+suspensionRoute, handlers.SuspendUserHandler).Methods("PUT")
+	adminRouter.HandleFunc(userRoute+idRoute+suspensionRoute, handlers.ReactivateUserHandler).Methods("DELETE")
+	// Middlewares
+	// Order matters, we have to go from most to least specific routes
+
+	middlewareRouter.PathPrefix(baseRoute + userRoute + idRoute).Handler(apiCommonMiddleware.With(
+		middleware.NewRequireUserMiddleware(),
+		middleware.NewRequireUserOwnerMiddleware(),
+		negroni.Wrap(userDetailRouter),
+	))
+	middlewareRouter.PathPrefix(baseRoute + webhookRoute + idRoute).Handler(apiCommonMiddleware.With(
+		middleware.NewRequireWebhookMiddleware(),
+		negroni.Wrap(webhookDetailRouter),
+	))
+	middlewareRouter.PathPrefix(baseRoute + clientRoute + idRoute).Handler(apiCommonMiddleware.With(
+		middleware.NewRequireAPIClientMiddleware(),
+		negroni.Wrap(clientDetailRouter),
+	))
+	middlewareRouter.PathPrefix(baseRoute + adminRoute).Handler(apiCommonMiddleware.With(
+		middleware.NewRequireAdminMiddleware(),
+		negroni.Wrap(adminRouter),
+	))
+	middlewareRouter.PathPrefix(baseRoute + boxRoute + idRoute).Handler(apiCommonMiddleware.With(
+		middleware.NewRequireBoxMiddleware(),
+		negroni.Wrap(boxDetailRouter),
+	))
+	middlewareRouter.PathPrefix(baseRoute + openAPIRoute).Handler(negroni.New(
+		middleware.NewRequestIDMiddleware(),
+		negroni.NewLogger(),
+		cors.AllowAll(),
+		negroni.Wrap(openAPIRouter),
+	))
+	middlewareRouter.PathPrefix(baseRoute).Handler(apiCommonMiddleware.With(
+		negroni.Wrap(apiRouter),
+	))
+	middlewareRouter.PathPrefix(loginRoute).Handler(negroni.New(
+		middleware.NewRequestIDMiddleware(),
+		negroni.NewLogger(),
+		cors.AllowAll(),
+		negroni.Wrap(tokenRouter),
+	))
+	middlewareRouter.PathPrefix(logoutRoute).Handler(negroni.New(
+		middleware.NewRequestIDMiddleware(),
+		negroni.NewLogger(),
+		cors.AllowAll(),
+		middleware.NewUserFromJWTMiddleware(),
+		negroni.Wrap(logoutRouter),
+	))
+	middlewareRouter.PathPrefix(jwksRoute).Handler(negroni.New(
+		middleware.NewRequestIDMiddleware(),
+		negroni.NewLogger(),
+		cors.AllowAll(),
+		negroni.Wrap(jwksRouter),
+	))
+	middlewareRouter.PathPrefix(exportRoute).Handler(negroni.New(
+		middleware.NewRequestIDMiddleware(),
+		negroni.NewLogger(),
+		negroni.Wrap(downloadRouter),
+	))
+
+	spec := apiSpec
+	spec.Version = version
+	document, err := spec.Generate(router)
+	if _, drift := err.(openapi.DriftError); err != nil && !drift {
+		return nil, err
+	}
+	openAPIDocumentRoute.Handler(document)
+	return middlewareRouter, err
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const testPassword = "correct horse battery"
+
+var connectOnce sync.Once
+
+// newTestServer serves the router of the server, the test is skipped when MONGO_URL doesn't point to a database
+func newTestServer(t *testing.T) *httptest.Server {
+	if os.Getenv("MONGO_URL") == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	connectOnce.Do(models.Connect)
+	handler, err := NewRouter("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(handler)
+}
+
+// testUser is an active user, logged in with a first-party session whose access token is token
+type testUser struct {
+	models.User
+	token string
+	// resource is the id of something of the user, created by the setup of user route tests whose path needs one
+	resource string
+}
+
+// newTestUser signs up a user with a unique username, verifies their email and logs them in
+func newTestUser(t *testing.T, server *httptest.Server, admin bool) *testUser {
+	username := "test" + bson.NewObjectId().Hex()
+	password := testPassword
+	user, err := models.NewUser(models.UserRequest{
+		Username:  username,
+		Password:  &password,
+		Email:     username + "@example.com",
+		FirstName: "Test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if admin {
+		if err := user.SetAdmin(true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	form := url.Values{"grant_type": {"password"}, "username": {username}, "password": {password}}
+	resp, err := http.PostForm(server.URL+loginRoute, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Logging %s in answered %s", username, resp.Status)
+	}
+	var token auth.TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+	return &testUser{User: *user, token: token.AccessToken}
+}
+
+// path returns the path of u in the API
+func (u *testUser) path() string {
+	return baseRoute + userRoute + "/" + u.GetId().Hex()
+}
+
+// personalAccessToken returns a new personal access token of u with scopes
+func (u *testUser) personalAccessToken(t *testing.T, scopes ...models.Scope) string {
+	request := models.PersonalAccessTokenRequest{Name: "test", Scopes: scopes}
+	accessToken, token := models.NewPersonalAccessToken(request, u.User)
+	if err := accessToken.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+/*
+doRequest sends a request to server authorized with token. body is sent as JSON, with contentType or
+application/json, and the response is returned with its body read
+*/
+func doRequest(t *testing.T, server *httptest.Server, token, method, path string, body interface{},
+	contentType string) (*http.Response, []byte) {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = bytes.NewReader(encoded)
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+	req, err := http.NewRequest(method, server.URL+path, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response bytes.Buffer
+	if _, err := response.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	return resp, response.Bytes()
+}
@@ -0,0 +1,455 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jenarvaezg/magicbox/auth"
+	"github.com/jenarvaezg/magicbox/handlers"
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+// Who sends the requests of the user route tests, about the user of the url
+const (
+	actorSelf  = "self"
+	actorOther = "another user"
+	actorAdmin = "admin"
+	// actorToken is the user of the url with a personal access token which has every users scope
+	actorToken = "self with a personal access token"
+)
+
+var actors = []string{actorSelf, actorOther, actorAdmin, actorToken}
+
+type userRouteTest struct {
+	name   string
+	method string
+	// path returns the path of the request about target, once setup prepared it
+	path        func(target *testUser) string
+	body        func(target *testUser) interface{}
+	contentType string
+	// setup prepares target before the request, admin is an admin other than the actor
+	setup func(t *testing.T, target, admin *testUser)
+	// want is the status answered to each actor
+	want  map[string]int
+	check func(t *testing.T, actor string, target *testUser, body []byte)
+}
+
+func userPath(target *testUser) string {
+	return target.path()
+}
+
+func adminUserPath(route string) func(target *testUser) string {
+	return func(target *testUser) string {
+		return baseRoute + adminRoute + userRoute + "/" + target.GetId().Hex() + route
+	}
+}
+
+// userSubPath returns the path of route under the user of the url
+func userSubPath(route string) func(target *testUser) string {
+	return func(target *testUser) string {
+		return target.path() + route
+	}
+}
+
+// resourcePath returns the path of the resource setup created under route of the user of the url
+func resourcePath(route string) func(target *testUser) string {
+	return func(target *testUser) string {
+		return target.path() + route + "/" + target.resource
+	}
+}
+
+// lockoutPath returns the admin path of the lockout of the username of the target
+func lockoutPath(target *testUser) string {
+	return baseRoute + adminRoute + lockoutsRoute + "/" + auth.ThrottleUser + "/" + target.Username
+}
+
+// scheduleDeletion schedules the deletion of target, for the tests of the routes of a scheduled deletion
+func scheduleDeletion(t *testing.T, target, admin *testUser) {
+	if _, err := models.ScheduleAccountDeletion(target.User, models.AccountDeletionRequest{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// requestExport saves a pending export of target, for the tests of the routes of an export
+func requestExport(t *testing.T, target, admin *testUser) {
+	dataExport, _, err := models.RequestDataExport(target.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.resource = dataExport.GetId().Hex()
+}
+
+// jsonBody returns a body of user route tests which doesn't depend on their target
+func jsonBody(body interface{}) func(target *testUser) interface{} {
+	return func(*testUser) interface{} {
+		return body
+	}
+}
+
+var userRouteTests = []userRouteTest{
+	{
+		name: "get user", method: http.MethodGet, path: userPath,
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusOK,
+			actorAdmin: http.StatusOK, actorToken: http.StatusOK,
+		},
+		check: func(t *testing.T, actor string, target *testUser, body []byte) {
+			var user struct {
+				Email string `json:"email"`
+			}
+			if err := json.Unmarshal(body, &user); err != nil {
+				t.Fatal(err)
+			}
+			if private := actor != actorOther; private != (user.Email == target.Email) {
+				t.Errorf("%s got email %q, private profile expected: %v", actor, user.Email, private)
+			}
+		},
+	},
+	{
+		name: "patch name", method: http.MethodPatch, path: userPath, contentType: "application/merge-patch+json",
+		body: jsonBody(map[string]string{"firstName": "Changed"}),
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusNoContent,
+		},
+		check: func(t *testing.T, actor string, target *testUser, body []byte) {
+			user, err := models.GetUserByID(target.GetId().Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := actor != actorOther; changed != (user.FirstName == "Changed") {
+				t.Errorf("%s left the first name %q, change expected: %v", actor, user.FirstName, changed)
+			}
+		},
+	},
+	{
+		name: "patch password", method: http.MethodPatch, path: userPath, contentType: "application/merge-patch+json",
+		body: jsonBody(map[string]string{"password": "another password"}),
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "patch email", method: http.MethodPatch, path: userPath, contentType: "application/merge-patch+json",
+		body: func(target *testUser) interface{} {
+			return map[string]string{"email": "changed-" + target.Email}
+		},
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "delete user", method: http.MethodDelete, path: userPath,
+		want: map[string]int{
+			actorSelf: http.StatusAccepted, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusAccepted, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "grant admin role", method: http.MethodPut, path: adminUserPath(roleRoute),
+		body: jsonBody(models.UserRoleRequest{Admin: true}),
+		want: map[string]int{
+			actorSelf: http.StatusForbidden, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusOK, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "suspend user", method: http.MethodPut, path: adminUserPath(suspensionRoute),
+		body: jsonBody(models.SuspensionRequest{Reason: "Spam"}),
+		want: map[string]int{
+			actorSelf: http.StatusForbidden, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusOK, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		// Suspending a user revokes their sessions, personal access tokens are refused as the user is suspended
+		name: "reactivate user", method: http.MethodDelete, path: adminUserPath(suspensionRoute),
+		setup: func(t *testing.T, target, admin *testUser) {
+			if err := target.Suspend(admin.User, models.SuspensionRequest{Reason: "Spam"}); err != nil {
+				t.Fatal(err)
+			}
+		},
+		want: map[string]int{
+			actorSelf: http.StatusUnauthorized, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusOK, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "list users", method: http.MethodGet, path: func(*testUser) string { return baseRoute + userRoute },
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusOK,
+			actorAdmin: http.StatusOK, actorToken: http.StatusOK,
+		},
+		check: func(t *testing.T, actor string, target *testUser, body []byte) {
+			var users []struct {
+				Email string `json:"email"`
+			}
+			if err := json.Unmarshal(body, &users); err != nil {
+				t.Fatal(err)
+			}
+			emails := false
+			for _, user := range users {
+				emails = emails || user.Email != ""
+			}
+			if full := actor == actorAdmin; full != emails {
+				t.Errorf("%s got emails in the user list: %v, full list expected: %v", actor, emails, full)
+			}
+		},
+	},
+	{
+		name: "search users", method: http.MethodGet,
+		path: func(target *testUser) string { return baseRoute + userRoute + searchRoute + "?q=" + target.Username },
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusOK,
+			actorAdmin: http.StatusOK, actorToken: http.StatusOK,
+		},
+		check: func(t *testing.T, actor string, target *testUser, body []byte) {
+			var users []struct {
+				Username string `json:"username"`
+			}
+			if err := json.Unmarshal(body, &users); err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 1 || users[0].Username != target.Username {
+				t.Errorf("%s found %+v, want only %s", actor, users, target.Username)
+			}
+		},
+	},
+	{
+		name: "get scheduled deletion", method: http.MethodGet, path: userSubPath(deletionRoute),
+		setup: scheduleDeletion,
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusOK, actorToken: http.StatusOK,
+		},
+	},
+	{
+		name: "get deletion not scheduled", method: http.MethodGet, path: userSubPath(deletionRoute),
+		want: map[string]int{
+			actorSelf: http.StatusConflict, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusConflict, actorToken: http.StatusConflict,
+		},
+	},
+	{
+		name: "cancel deletion", method: http.MethodDelete, path: userSubPath(deletionRoute),
+		setup: scheduleDeletion,
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusNoContent,
+		},
+		check: func(t *testing.T, actor string, target *testUser, body []byte) {
+			if _, err := models.GetActiveAccountDeletion(target.User); err == nil {
+				t.Errorf("%s left the deletion scheduled", actor)
+			}
+		},
+	},
+	{
+		name: "list sessions", method: http.MethodGet, path: userSubPath(sessionsRoute),
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusOK, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "revoke sessions", method: http.MethodDelete, path: userSubPath(sessionsRoute),
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "revoke session", method: http.MethodDelete, path: resourcePath(sessionsRoute),
+		setup: func(t *testing.T, target, admin *testUser) {
+			claims, err := auth.ParseToken(target.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target.resource = claims.SessionID
+		},
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "list identities", method: http.MethodGet, path: userSubPath(identitiesRoute),
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "link identity", method: http.MethodPost, path: userSubPath(identitiesRoute),
+		body: jsonBody(map[string]string{"idToken": "not a token", "nonce": "nonce"}),
+		want: map[string]int{
+			actorSelf: http.StatusUnprocessableEntity, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "unlink identity", method: http.MethodDelete, path: userSubPath(identitiesRoute + "/google"),
+		want: map[string]int{
+			actorSelf: http.StatusNotFound, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "start TOTP enrollment", method: http.MethodPost, path: userSubPath(mfaRoute),
+		want: map[string]int{
+			actorSelf: http.StatusCreated, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "confirm TOTP", method: http.MethodPost, path: userSubPath(mfaRoute + confirmRoute),
+		body: jsonBody(models.MFACodeRequest{Code: "000000"}),
+		setup: func(t *testing.T, target, admin *testUser) {
+			if _, err := target.StartTOTPEnrollment(); err != nil {
+				t.Fatal(err)
+			}
+		},
+		want: map[string]int{
+			actorSelf: http.StatusUnprocessableEntity, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "disable TOTP", method: http.MethodPost, path: userSubPath(mfaRoute + "/disable"),
+		body: jsonBody(handlers.MFADisableRequest{Password: testPassword}),
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "request export", method: http.MethodPost, path: userSubPath(exportRoute),
+		want: map[string]int{
+			actorSelf: http.StatusAccepted, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "request export again", method: http.MethodPost, path: userSubPath(exportRoute),
+		setup: requestExport,
+		want: map[string]int{
+			actorSelf: http.StatusAccepted, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+		check: func(t *testing.T, actor string, target *testUser, body []byte) {
+			var dataExport models.DataExportResponse
+			if err := json.Unmarshal(body, &dataExport); err != nil {
+				t.Fatal(err)
+			}
+			if actor == actorSelf && dataExport.ID.Hex() != target.resource {
+				t.Errorf("%s got the export %s, want the pending one %s", actor, dataExport.ID.Hex(), target.resource)
+			}
+		},
+	},
+	{
+		name: "get export", method: http.MethodGet, path: resourcePath(exportRoute),
+		setup: requestExport,
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "list personal access tokens", method: http.MethodGet, path: userSubPath(tokensRoute),
+		want: map[string]int{
+			actorSelf: http.StatusOK, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "create personal access token", method: http.MethodPost, path: userSubPath(tokensRoute),
+		body: jsonBody(models.PersonalAccessTokenRequest{Name: "test", Scopes: []models.Scope{models.ScopeBoxesRead}}),
+		want: map[string]int{
+			actorSelf: http.StatusCreated, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "create personal access token without JSON", method: http.MethodPost, path: userSubPath(tokensRoute),
+		body:        jsonBody(models.PersonalAccessTokenRequest{Name: "test", Scopes: []models.Scope{models.ScopeBoxesRead}}),
+		contentType: "text/plain",
+		want: map[string]int{
+			actorSelf: http.StatusUnsupportedMediaType, actorOther: http.StatusUnsupportedMediaType,
+			actorAdmin: http.StatusUnsupportedMediaType, actorToken: http.StatusUnsupportedMediaType,
+		},
+	},
+	{
+		name: "revoke personal access token", method: http.MethodDelete, path: resourcePath(tokensRoute),
+		setup: func(t *testing.T, target, admin *testUser) {
+			request := models.PersonalAccessTokenRequest{Name: "test", Scopes: []models.Scope{models.ScopeBoxesRead}}
+			accessToken, _ := models.NewPersonalAccessToken(request, target.User)
+			if err := accessToken.Save(); err != nil {
+				t.Fatal(err)
+			}
+			target.resource = accessToken.GetId().Hex()
+		},
+		want: map[string]int{
+			actorSelf: http.StatusNoContent, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusForbidden, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "get lockout", method: http.MethodGet, path: lockoutPath,
+		want: map[string]int{
+			actorSelf: http.StatusForbidden, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusOK, actorToken: http.StatusForbidden,
+		},
+	},
+	{
+		name: "unlock", method: http.MethodDelete, path: lockoutPath,
+		want: map[string]int{
+			actorSelf: http.StatusForbidden, actorOther: http.StatusForbidden,
+			actorAdmin: http.StatusNoContent, actorToken: http.StatusForbidden,
+		},
+	},
+}
+
+func TestUserRoutes(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	for _, test := range userRouteTests {
+		for _, actor := range actors {
+			want, ok := test.want[actor]
+			if !ok {
+				continue
+			}
+			test, actor := test, actor
+			t.Run(test.name+" as "+actor, func(t *testing.T) {
+				target, admin := newTestUser(t, server, false), newTestUser(t, server, true)
+				token := target.token
+				switch actor {
+				case actorOther:
+					token = newTestUser(t, server, false).token
+				case actorAdmin:
+					token = admin.token
+				case actorToken:
+					token = target.personalAccessToken(t, models.ScopeUsersRead, models.ScopeUsersWrite)
+				}
+				if test.setup != nil {
+					test.setup(t, target, admin)
+				}
+
+				var requestBody interface{}
+				if test.body != nil {
+					requestBody = test.body(target)
+				}
+				path := test.path(target)
+				resp, body := doRequest(t, server, token, test.method, path, requestBody, test.contentType)
+				if resp.StatusCode != want {
+					t.Fatalf("%s %s answered %d, want %d: %s", test.method, path, resp.StatusCode, want, body)
+				}
+				if test.check != nil {
+					test.check(t, actor, target, body)
+				}
+			})
+		}
+	}
+}
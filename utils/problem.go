@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/jenarvaezg/magicbox/models"
+)
+
+const (
+	// RequestIDHeader is the header which carries the id of a request, set on every response
+	RequestIDHeader = "X-Request-ID"
+	problemTypeBase = "urn:magicbox:problem:"
+)
+
+// Problem is an RFC 7807 problem details document, the body of every error response
+type Problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty"`
+	RequestID string              `json:"requestId,omitempty"`
+	Errors    []models.FieldError `json:"errors,omitempty"`
+}
+
+type problemKind struct {
+	status int
+	title  string
+}
+
+var problemKinds = map[models.ErrorKind]problemKind{
+	models.KindBadRequest:           {http.StatusBadRequest, "Bad request"},
+	models.KindUnauthorized:         {http.StatusUnauthorized, "Unauthorized"},
+	models.KindForbidden:            {http.StatusForbidden, "Forbidden"},
+	models.KindNotFound:             {http.StatusNotFound, "Not found"},
+	models.KindConflict:             {http.StatusConflict, "Conflict"},
+	models.KindValidation:           {http.StatusUnprocessableEntity, "Validation failed"},
+	models.KindTooLarge:             {http.StatusRequestEntityTooLarge, "Payload too large"},
+	models.KindUnsupportedMediaType: {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	models.KindTooManyRequests:      {http.StatusTooManyRequests, "Too many requests"},
+	models.KindInternal:             {http.StatusInternalServerError, "Internal server error"},
+}
+
+// kindOfStatus returns the kind of error answered with status
+func kindOfStatus(status int) models.ErrorKind {
+	for kind, problem := range problemKinds {
+		if problem.status == status {
+			return kind
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		return models.KindInternal
+	}
+	return models.KindBadRequest
+}
+
+func newProblem(kind models.ErrorKind, detail string) Problem {
+	problem := problemKinds[kind]
+	return Problem{Type: problemTypeBase + string(kind), Title: problem.title, Status: problem.status, Detail: detail}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	problem.RequestID = w.Header().Get(RequestIDHeader)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	getJSONEncoder(w).Encode(problem)
+}
+
+/*
+ResponseProblem writes err to w as a problem. Errors of the domain keep their kind and message, any other error is
+logged and answered as an internal error, so its text never reaches the client
+*/
+func ResponseProblem(w http.ResponseWriter, err error) {
+	domainErr, ok := err.(*models.Error)
+	if !ok {
+		log.Printf("request %s failed: %s", w.Header().Get(RequestIDHeader), err)
+		writeProblem(w, newProblem(models.KindInternal, "The request could not be completed"))
+		return
+	}
+	problem := newProblem(domainErr.Kind, domainErr.Message)
+	problem.Errors = domainErr.Fields
+	writeProblem(w, problem)
+}
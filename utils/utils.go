@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 )
 
@@ -21,6 +22,18 @@ var ContextKeyUser = ContextKey("user")
 //ContextKeyCurrentUser is a key used for indexing a user in a context
 var ContextKeyCurrentUser = ContextKey("current-user")
 
+//ContextKeyWebhook is a key used for indexing a webhook in a context
+var ContextKeyWebhook = ContextKey("webhook")
+
+//ContextKeyAPIClient is a key used for indexing an API client in a context
+var ContextKeyAPIClient = ContextKey("api-client")
+
+//ContextKeyTokenClaims is a key used for indexing the claims of the requesting token in a context
+var ContextKeyTokenClaims = ContextKey("token-claims")
+
+//ContextKeyRequestID is a key used for indexing the id of the request in a context
+var ContextKeyRequestID = ContextKey("request-id")
+
 //RemoveForbiddenFields removes id created_at and modified at from JSONMap
 
 func getJSONEncoder(w http.ResponseWriter) *json.Encoder {
@@ -29,11 +42,13 @@ func getJSONEncoder(w http.ResponseWriter) *json.Encoder {
 	return encoder
 }
 
-// ResponseError returns writes to w a mesage and sets the status code to code
+// ResponseError writes to w a problem of the kind of the status code, with message as its detail
 func ResponseError(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	getJSONEncoder(w).Encode(map[string]string{"error": message})
+	problem := newProblem(kindOfStatus(code), message)
+	if problem.Status != code {
+		problem.Title, problem.Status = http.StatusText(code), code
+	}
+	writeProblem(w, problem)
 }
 
 // ResponseJSON serializes a object and sends the result to w
@@ -48,7 +63,7 @@ func ResponseJSON(w http.ResponseWriter, object interface{}, many bool) { //seri
 		err = encoder.Encode(object)
 	}
 	if err != nil {
-		ResponseError(w, err.Error(), http.StatusBadRequest)
+		log.Printf("request %s: could not encode response: %s", w.Header().Get(RequestIDHeader), err)
 	}
 }
 
@@ -57,6 +72,20 @@ func ResponseCreated(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// ResponseCreatedJSON sets header to 201 Created and serializes object as the body
+func ResponseCreatedJSON(w http.ResponseWriter, object interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	getJSONEncoder(w).Encode(object)
+}
+
+// ResponseAcceptedJSON sets header to 202 Accepted and serializes object as the body, for work done in the background
+func ResponseAcceptedJSON(w http.ResponseWriter, object interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	getJSONEncoder(w).Encode(object)
+}
+
 // ResponseNoContent sets header to 204 NoContent
 func ResponseNoContent(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,224 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/jobs"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const userAgent = "MagicBox-Webhooks/1.0"
+
+// DeliverJobType is the type of the jobs that deliver a queued webhook payload
+const DeliverJobType = "webhook.deliver"
+
+// maxDeliveryAttempts bounds the retries of a delivery before it is marked as failed
+const maxDeliveryAttempts = 8
+
+// deliverJob is the payload of webhook delivery jobs
+type deliverJob struct {
+	Delivery bson.ObjectId `bson:"delivery"`
+}
+
+// Dispatcher delivers queued webhook payloads, the retries are driven by the jobs pool
+type Dispatcher struct {
+	// Client sends the deliveries. The one of NewDispatcher only dials public addresses, tests give their own
+	Client *http.Client
+}
+
+// Errors logged in the attempts of a delivery, they are shown to the webhook owner so they never carry the
+// error of the connection, which could tell them about the network of the server
+var (
+	errReceiverUnreachable = errors.New("Could not connect to the receiver")
+	errAddressNotAllowed   = errors.New("Receiver address is not allowed")
+)
+
+// NewDispatcher returns a Dispatcher with sensible defaults
+func NewDispatcher() *Dispatcher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &Dispatcher{
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: publicDialContext(dialer)},
+		},
+	}
+}
+
+/*
+publicDialContext returns a DialContext which resolves the host itself and only dials public addresses. URLs are
+checked when webhooks are saved, this check is the one that matters: it covers redirects and hosts whose DNS
+records changed after the webhook was saved
+*/
+func publicDialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := models.LookupPublicIPs(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// DefaultDispatcher is the dispatcher used by the server
+var DefaultDispatcher = NewDispatcher()
+
+// Register makes pool run webhook deliveries with d
+func (d *Dispatcher) Register(pool *jobs.Pool) {
+	pool.Handle(DeliverJobType, d.Deliver)
+}
+
+/*
+Attempt POSTs a delivery to webhook.URL once and returns the logged attempt. A delivery succeeds when the
+receiver answers with any 2xx status
+*/
+func (d *Dispatcher) Attempt(webhook models.Webhook, delivery models.WebhookDelivery) models.DeliveryAttempt {
+	start := time.Now()
+	attempt := models.DeliveryAttempt{At: start}
+	err := d.post(webhook, delivery, &attempt)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	return attempt
+}
+
+// sanitize returns the error logged for a request to a receiver which failed with err
+func sanitize(delivery models.WebhookDelivery, err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if err == models.ErrWebhookAddressNotAllowed {
+		return errAddressNotAllowed
+	}
+	log.Println("Webhook delivery", delivery.GetId().Hex(), "failed:", err)
+	return errReceiverUnreachable
+}
+
+func (d *Dispatcher) post(webhook models.Webhook, delivery models.WebhookDelivery, attempt *models.DeliveryAttempt) error {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return sanitize(delivery, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, delivery.GetId().Hex())
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return sanitize(delivery, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+/*
+Deliver is the handler of webhook delivery jobs. It performs one attempt and records it in the delivery log,
+returning an error so the pool retries with backoff until the job runs out of attempts
+*/
+func (d *Dispatcher) Deliver(job *jobs.Job) error {
+	var payload deliverJob
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	delivery, err := models.GetWebhookDeliveryByID(payload.Delivery.Hex())
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	webhook, err := models.GetWebhookByID(delivery.Webhook.Hex())
+	if err != nil || !webhook.Active {
+		return cancel(delivery, "Webhook was deleted or deactivated")
+	}
+	if !ownerIsMember(webhook, delivery) {
+		return cancel(delivery, "Webhook owner is no longer a member of the box")
+	}
+
+	attempt := d.Attempt(webhook, delivery)
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	switch {
+	case attempt.Error == "":
+		delivery.Status = models.DeliverySucceeded
+	case job.IsLastAttempt():
+		delivery.Status = models.DeliveryFailed
+	}
+	if err := delivery.Save(); err != nil {
+		return err
+	}
+	if attempt.Error != "" {
+		return errors.New(attempt.Error)
+	}
+	return nil
+}
+
+// cancel marks a delivery which must not be attempted anymore as failed, logging why
+func cancel(delivery models.WebhookDelivery, reason string) error {
+	delivery.Status = models.DeliveryFailed
+	delivery.Attempts = append(delivery.Attempts, models.DeliveryAttempt{At: time.Now(), Error: reason})
+	return delivery.Save()
+}
+
+/*
+ownerIsMember returns whether the owner of webhook is still registered in the box of delivery, they may have left it
+after the delivery was queued. A box which was deleted since keeps the members it had, so box.deleted is delivered
+*/
+func ownerIsMember(webhook models.Webhook, delivery models.WebhookDelivery) bool {
+	if delivery.Box == nil {
+		return true
+	}
+	box, err := models.GetBoxByID(delivery.Box.Hex())
+	if err != nil {
+		return models.IsNotFound(err)
+	}
+	owner := models.User{}
+	owner.SetId(webhook.Owner)
+	return box.IsUserRegistered(owner)
+}
+
+// SendTest synchronously delivers a ping event to webhook once, the delivery is logged like any other
+func (d *Dispatcher) SendTest(webhook models.Webhook) (*models.WebhookDelivery, error) {
+	body := []byte(fmt.Sprintf(`{"event":%q,"createdAt":%q}`, models.EventPing, time.Now().UTC().Format(time.RFC3339)))
+	delivery := models.NewWebhookDelivery(webhook, models.EventPing, body)
+	delivery.Status = models.DeliveryFailed
+	// Saved once first so the delivery header carries a real id
+	if err := delivery.Save(); err != nil {
+		return nil, err
+	}
+
+	attempt := d.Attempt(webhook, *delivery)
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if attempt.Error == "" {
+		delivery.Status = models.DeliverySucceeded
+	}
+	return delivery, delivery.Save()
+}
@@ -0,0 +1,239 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/jobs"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	testSecret = "webhook-secret"
+	// testURL is a public address, which webhooks can be saved with, the test clients send its deliveries to receivers
+	testURL = "http://93.184.216.34/hook"
+)
+
+// receiver is a stand-in webhook receiver which answers the queued statuses, then 204, and checks every signature
+type receiver struct {
+	t        *testing.T
+	server   *httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	received []*http.Request
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{t: t, statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+func (r *receiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.t.Error(err)
+	}
+	if err := Verify(testSecret, req.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+		r.t.Errorf("Delivery %s has an invalid signature: %v", req.Header.Get(DeliveryHeader), err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, req)
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) requests() []*http.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.received
+}
+
+// dispatcher returns a Dispatcher whose client sends every delivery to r, whatever the URL of the webhook
+func (r *receiver) dispatcher() *Dispatcher {
+	address := r.server.Listener.Addr().String()
+	dialer := &net.Dialer{}
+	return &Dispatcher{Client: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+	}}}
+}
+
+func testWebhook() models.Webhook {
+	webhook := models.Webhook{URL: testURL, Secret: testSecret, Active: true, Events: []models.WebhookEvent{
+		models.EventBoxCreated,
+	}}
+	webhook.SetId(bson.NewObjectId())
+	return webhook
+}
+
+func testDelivery(webhook models.Webhook) models.WebhookDelivery {
+	delivery := models.NewWebhookDelivery(webhook, models.EventBoxCreated, []byte(`{"event":"box.created"}`))
+	delivery.SetId(bson.NewObjectId())
+	return *delivery
+}
+
+func TestDispatcherAttempt(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		valid  bool
+	}{
+		{name: "accepted", status: http.StatusOK, valid: true},
+		{name: "accepted without content", status: http.StatusNoContent, valid: true},
+		{name: "redirected", status: http.StatusNotModified},
+		{name: "refused", status: http.StatusBadRequest},
+		{name: "failing", status: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := newReceiver(t, test.status)
+			defer receiver.server.Close()
+			webhook := testWebhook()
+			delivery := testDelivery(webhook)
+
+			attempt := receiver.dispatcher().Attempt(webhook, delivery)
+			if attempt.StatusCode != test.status {
+				t.Errorf("Attempt() logged the status %d, want %d", attempt.StatusCode, test.status)
+			}
+			if succeeded := attempt.Error == ""; succeeded != test.valid {
+				t.Errorf("Attempt() logged the error %q, want success: %v", attempt.Error, test.valid)
+			}
+			requests := receiver.requests()
+			if len(requests) != 1 {
+				t.Fatalf("The receiver got %d requests, want 1", len(requests))
+			}
+			headers := requests[0].Header
+			event, id := headers.Get(EventHeader), headers.Get(DeliveryHeader)
+			if event != string(models.EventBoxCreated) || id != delivery.GetId().Hex() {
+				t.Errorf("The delivery was sent with the headers %v", headers)
+			}
+		})
+	}
+}
+
+func TestDispatcherAttemptHidesConnectionErrors(t *testing.T) {
+	receiver := newReceiver(t)
+	d := receiver.dispatcher()
+	receiver.server.Close()
+	webhook := testWebhook()
+
+	attempt := d.Attempt(webhook, testDelivery(webhook))
+	if attempt.Error != errReceiverUnreachable.Error() {
+		t.Errorf("Attempt() to a closed receiver logged %q, want %q", attempt.Error, errReceiverUnreachable)
+	}
+}
+
+func TestDefaultDispatcherRefusesPrivateAddresses(t *testing.T) {
+	receiver := newReceiver(t)
+	defer receiver.server.Close()
+	webhook := testWebhook()
+	webhook.URL = receiver.server.URL
+
+	attempt := NewDispatcher().Attempt(webhook, testDelivery(webhook))
+	if attempt.Error != errAddressNotAllowed.Error() {
+		t.Errorf("Attempt() to a loopback receiver logged %q, want %q", attempt.Error, errAddressNotAllowed)
+	}
+	if len(receiver.requests()) != 0 {
+		t.Error("The loopback receiver got the delivery")
+	}
+}
+
+var connectOnce sync.Once
+
+// requireDatabase skips tests which need mongo when MONGO_URL is not set, and connects to it otherwise
+func requireDatabase(t *testing.T) {
+	if os.Getenv("MONGO_URL") == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	connectOnce.Do(models.Connect)
+}
+
+// waitForDelivery returns the delivery once it isn't pending, failing the test if it takes too long
+func waitForDelivery(t *testing.T, id bson.ObjectId) models.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		delivery, err := models.GetWebhookDeliveryByID(id.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != models.DeliveryPending {
+			return delivery
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Delivery is still pending after %d attempts", len(delivery.Attempts))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatcherDeliverRetries(t *testing.T) {
+	requireDatabase(t)
+	tests := []struct {
+		name     string
+		statuses []int
+		want     models.DeliveryStatus
+		attempts int
+	}{
+		{name: "accepted after failing", want: models.DeliverySucceeded, attempts: 3,
+			statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}},
+		{name: "out of attempts", want: models.DeliveryFailed, attempts: 3,
+			statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusBadGateway}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := newReceiver(t, test.statuses...)
+			defer receiver.server.Close()
+			owner := models.User{}
+			owner.SetId(bson.NewObjectId())
+			webhook := models.NewWebhook(models.WebhookRequest{URL: testURL, Events: []models.WebhookEvent{
+				models.EventBoxCreated,
+			}}, owner)
+			webhook.Secret = testSecret
+			if err := webhook.Save(); err != nil {
+				t.Fatal(err)
+			}
+			defer webhook.Delete()
+			delivery := models.NewWebhookDelivery(*webhook, models.EventBoxCreated, []byte(`{"event":"box.created"}`))
+			if err := delivery.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			pool := jobs.NewPool(jobs.NewMemoryStore(), 1)
+			pool.PollInterval, pool.BaseBackoff = time.Millisecond, time.Millisecond
+			receiver.dispatcher().Register(pool)
+			job, err := jobs.New(DeliverJobType, deliverJob{Delivery: delivery.GetId()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			job.MaxAttempts = 3
+			if err := pool.Store.Enqueue(job); err != nil {
+				t.Fatal(err)
+			}
+			pool.Start()
+			finished := waitForDelivery(t, delivery.GetId())
+			pool.Stop()
+
+			if finished.Status != test.want || len(finished.Attempts) != test.attempts {
+				t.Errorf("Delivery is %s after %d attempts, want %s after %d", finished.Status, len(finished.Attempts),
+					test.want, test.attempts)
+			}
+			if len(receiver.requests()) != test.attempts {
+				t.Errorf("The receiver got %d requests, want %d", len(receiver.requests()), test.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/jobs"
+	"github.com/jenarvaezg/magicbox/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BoxPayload is the representation of a box sent in webhook payloads
+type BoxPayload struct {
+	ID       bson.ObjectId    `json:"id"`
+	Name     string           `json:"name"`
+	Status   models.BoxStatus `json:"status"`
+	OpenDate time.Time        `json:"openDate"`
+	Members  int              `json:"members"`
+}
+
+// NotePayload is the representation of a note sent in webhook payloads, it never holds the note content
+type NotePayload struct {
+	Anonymous     bool `json:"anonymous"`
+	NumberOfNotes int  `json:"numberOfNotes"`
+}
+
+// Payload is the body POSTed to webhook URLs
+type Payload struct {
+	Event     models.WebhookEvent `json:"event"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Box       *BoxPayload         `json:"box,omitempty"`
+	Actor     *bson.ObjectId      `json:"actor,omitempty"`
+	Note      *NotePayload        `json:"note,omitempty"`
+}
+
+func newPayload(event models.WebhookEvent, box models.Box, actor *models.User) Payload {
+	payload := Payload{
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Box: &BoxPayload{
+			ID:       box.GetId(),
+			Name:     box.Name,
+			Status:   box.Status,
+			OpenDate: box.OpenDate,
+			Members:  len(box.Users),
+		},
+	}
+	if actor != nil {
+		actorID := actor.GetId()
+		payload.Actor = &actorID
+	}
+	return payload
+}
+
+func enqueue(event models.WebhookEvent, box models.Box, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("Could not serialize webhook payload", err)
+		return
+	}
+	boxID := box.GetId()
+	for _, webhook := range models.ListWebhooksForEvent(event, box) {
+		delivery := models.NewWebhookDelivery(webhook, event, body)
+		delivery.Box = &boxID
+		if err := delivery.Save(); err != nil {
+			log.Println("Could not save webhook delivery", err)
+			continue
+		}
+		job, err := jobs.New(DeliverJobType, deliverJob{Delivery: delivery.GetId()})
+		if err == nil {
+			job.MaxAttempts = maxDeliveryAttempts
+			err = jobs.DefaultStore.Enqueue(job)
+		}
+		if err != nil {
+			log.Println("Could not enqueue webhook delivery", err)
+		}
+	}
+}
+
+// Emit queues a delivery of event for every webhook subscribed to it in box. actor is the user that caused
+// the event, if any
+func Emit(event models.WebhookEvent, box models.Box, actor *models.User) {
+	enqueue(event, box, newPayload(event, box, actor))
+}
+
+// EmitNoteAdded queues a note.added delivery, the note is described without its content
+func EmitNoteAdded(box models.Box, note models.Note, actor models.User) {
+	payload := newPayload(models.EventNoteAdded, box, nil)
+	payload.Note = &NotePayload{
+		Anonymous:     note.From == nil,
+		NumberOfNotes: len(box.Notes),
+	}
+	if note.From != nil {
+		actorID := actor.GetId()
+		payload.Actor = &actorID
+	}
+	enqueue(models.EventNoteAdded, box, payload)
+}
@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on every delivery
+const (
+	SignatureHeader = "X-MagicBox-Signature"
+	EventHeader     = "X-MagicBox-Event"
+	DeliveryHeader  = "X-MagicBox-Delivery"
+)
+
+func computeMAC(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+/*
+Sign returns the value of the signature header for body, which has the form "t=<unix time>,v1=<hex mac>".
+The mac is an HMAC-SHA256 keyed with the webhook secret of "<unix time>.<body>", so a captured
+delivery can't be replayed with a different timestamp
+*/
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := timestamp.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", t, hex.EncodeToString(computeMAC(secret, t, body)))
+}
+
+// Verify checks a signature header produced by Sign, rejecting it if it is older than tolerance
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp int64
+	var mac []byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return errors.New("Malformed signature header")
+		}
+		switch kv[0] {
+		case "t":
+			t, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return errors.New("Malformed signature timestamp")
+			}
+			timestamp = t
+		case "v1":
+			m, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return errors.New("Malformed signature")
+			}
+			mac = m
+		}
+	}
+	if timestamp == 0 || mac == nil {
+		return errors.New("Malformed signature header")
+	}
+	if time.Since(time.Unix(timestamp, 0)) > tolerance {
+		return errors.New("Signature is too old")
+	}
+	if !hmac.Equal(mac, computeMAC(secret, timestamp, body)) {
+		return errors.New("Signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package webhooks
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureRoundTrip(t *testing.T) {
+	const secret = "webhook-secret"
+	body := []byte(`{"event":"box.created"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		valid  bool
+	}{
+		{name: "signed", secret: secret, header: Sign(secret, now, body), body: body, valid: true},
+		{name: "signed within the tolerance", secret: secret, header: Sign(secret, now.Add(-4*time.Minute), body),
+			body: body, valid: true},
+		{name: "other secret", secret: "other-secret", header: Sign(secret, now, body), body: body},
+		{name: "tampered body", secret: secret, header: Sign(secret, now, body), body: []byte(`{"event":"box.deleted"}`)},
+		{name: "too old", secret: secret, header: Sign(secret, now.Add(-time.Hour), body), body: body},
+		{name: "mac of another timestamp", secret: secret, body: body,
+			header: "t=" + strconv.FormatInt(now.Unix()+1, 10) + "," + strings.SplitN(Sign(secret, now, body), ",", 2)[1]},
+		{name: "without timestamp", secret: secret, header: "v1=00", body: body},
+		{name: "without mac", secret: secret, header: "t=1", body: body},
+		{name: "malformed mac", secret: secret, header: "t=1,v1=zz", body: body},
+		{name: "malformed header", secret: secret, header: "signature", body: body},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.secret, test.header, test.body, 5*time.Minute)
+			if test.valid && err != nil {
+				t.Fatalf("Verify() returned %v, want no error", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("Verify() accepted the signature, want an error")
+			}
+		})
+	}
+}
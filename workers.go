@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jenarvaezg/magicbox/export"
+	"github.com/jenarvaezg/magicbox/jobs"
+	"github.com/jenarvaezg/magicbox/models"
+	"github.com/jenarvaezg/magicbox/notify"
+	"github.com/jenarvaezg/magicbox/webhooks"
+)
+
+const (
+	defaultWorkers         = 4
+	openBoxesJobType       = "boxes.open"
+	openBoxesCheckInterval = time.Minute
+	remindBoxesJobType     = "boxes.remind"
+	remindBoxesInterval    = 5 * time.Minute
+	deleteUsersJobType     = "users.delete"
+	deleteUsersInterval    = time.Minute
+	reactivateUsersJobType = "users.reactivate"
+	reactivateInterval     = time.Minute
+	expireNoncesJobType    = "nonces.expire"
+	expireNoncesInterval   = 10 * time.Minute
+)
+
+func getWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("JOBS_WORKERS"))
+	if err != nil || workers < 1 {
+		return defaultWorkers
+	}
+	return workers
+}
+
+// openBoxesJob opens the boxes whose open date has passed and announces them
+func openBoxesJob(job *jobs.Job) error {
+	for {
+		box, err := models.ClaimOpenedBox()
+		if err != nil {
+			return err
+		}
+		if box == nil {
+			return nil
+		}
+		webhooks.Emit(models.EventBoxOpened, *box, nil)
+		notify.BoxOpened(*box)
+	}
+}
+
+// remindBoxesJob sends the due box reminders to the members that have not written a note yet
+func remindBoxesJob(job *jobs.Job) error {
+	for {
+		box, err := models.ClaimDueReminder()
+		if err != nil {
+			return err
+		}
+		if box == nil {
+			return nil
+		}
+		notify.ContributionReminder(*box)
+	}
+}
+
+// deleteUsersJob runs the account deletions whose grace period is over, resuming those interrupted midway
+func deleteUsersJob(job *jobs.Job) error {
+	for {
+		deletion, err := models.ClaimDueAccountDeletion()
+		if err != nil {
+			return err
+		}
+		if deletion == nil {
+			return nil
+		}
+		if err := deletion.Run(emitBoxDeleted); err != nil {
+			// Left running, it is claimed again once its lease expires
+			log.Println(err)
+		}
+	}
+}
+
+// emitBoxDeleted announces a box deleted along with the account of its last member, who is not the actor any more
+func emitBoxDeleted(box models.Box) {
+	webhooks.Emit(models.EventBoxDeleted, box, nil)
+}
+
+// reactivateUsersJob lifts the suspensions whose expiry has passed
+func reactivateUsersJob(job *jobs.Job) error {
+	for {
+		user, err := models.ReactivateExpiredSuspension()
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return nil
+		}
+	}
+}
+
+// expireNoncesJob removes the login nonces that expired unused
+func expireNoncesJob(job *jobs.Job) error {
+	return models.RemoveExpiredLoginNonces()
+}
+
+func setupJobs() *jobs.Pool {
+	store, err := jobs.NewMongoStore(models.JobCollection())
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobs.DefaultStore = store
+
+	pool := jobs.NewPool(store, getWorkers())
+	webhooks.DefaultDispatcher.Register(pool)
+	pool.Handle(openBoxesJobType, openBoxesJob)
+	pool.Every(openBoxesJobType, openBoxesCheckInterval)
+	pool.Handle(remindBoxesJobType, remindBoxesJob)
+	pool.Every(remindBoxesJobType, remindBoxesInterval)
+	pool.Handle(deleteUsersJobType, deleteUsersJob)
+	pool.Every(deleteUsersJobType, deleteUsersInterval)
+	pool.Handle(reactivateUsersJobType, reactivateUsersJob)
+	pool.Every(reactivateUsersJobType, reactivateInterval)
+	pool.Handle(expireNoncesJobType, expireNoncesJob)
+	pool.Every(expireNoncesJobType, expireNoncesInterval)
+	notify.Register(pool)
+	export.Register(pool)
+	return pool
+}